@@ -206,7 +206,7 @@ func (s *testAgentSuite) testStore(c *C, agent testAgentInfo, port int) {
 	addr := fmt.Sprintf("127.0.0.1:%d", port)
 	agent.httpCall(c, nil, "start_redis", fmt.Sprintf("addr=%s", url.QueryEscape(addr)), "POST")
 
-	err := utils.Ping(addr, globalEnv.StoreAuth())
+	err := utils.Ping(addr, &utils.Credential{Auth: globalEnv.StoreAuth()})
 	c.Assert(err, IsNil)
 
 	// kill store and then wait 2s for restart
@@ -214,7 +214,7 @@ func (s *testAgentSuite) testStore(c *C, agent testAgentInfo, port int) {
 
 	time.Sleep(2 * time.Second)
 
-	err = utils.Ping(addr, globalEnv.StoreAuth())
+	err = utils.Ping(addr, &utils.Credential{Auth: globalEnv.StoreAuth()})
 	c.Assert(err, IsNil)
 }
 
@@ -274,7 +274,7 @@ func (s *testAgentSuite) testProxy(c *C) {
 	var err error
 	for i := 0; i < 3; i++ {
 		time.Sleep(2 * time.Second)
-		if err = utils.Ping(proxyAddr, ""); err == nil {
+		if err = utils.Ping(proxyAddr, nil); err == nil {
 			break
 		}
 	}
@@ -285,7 +285,7 @@ func (s *testAgentSuite) testProxy(c *C) {
 
 	for i := 0; i < 3; i++ {
 		time.Sleep(2 * time.Second)
-		if err = utils.Ping(proxyAddr, ""); err == nil {
+		if err = utils.Ping(proxyAddr, nil); err == nil {
 			break
 		}
 	}
@@ -331,7 +331,7 @@ func (s *testAgentSuite) TestHA(c *C) {
 	time.Sleep(3 * time.Second)
 
 	// now agentStoreSlave restart and redis restart
-	role, err := utils.GetRole("127.0.0.1:6382", globalEnv.StoreAuth())
+	role, err := utils.GetRole("127.0.0.1:6382", &utils.Credential{Auth: globalEnv.StoreAuth()})
 	c.Assert(err, IsNil)
 	c.Assert(role, Equals, "master")
 
@@ -339,7 +339,7 @@ func (s *testAgentSuite) TestHA(c *C) {
 
 	s.checkStoreServerType(c, "127.0.0.1:6382", models.SERVER_TYPE_SLAVE)
 
-	role, err = utils.GetRole("127.0.0.1:6382", globalEnv.StoreAuth())
+	role, err = utils.GetRole("127.0.0.1:6382", &utils.Credential{Auth: globalEnv.StoreAuth()})
 	c.Assert(err, IsNil)
 	c.Assert(role, Equals, "slave")
 
@@ -354,7 +354,7 @@ func (s *testAgentSuite) TestHA(c *C) {
 	// now 6382 is slave, and 6381 is offline
 	s.checkStoreServerType(c, "127.0.0.1:6382", models.SERVER_TYPE_MASTER)
 
-	role, err = utils.GetRole("127.0.0.1:6382", globalEnv.StoreAuth())
+	role, err = utils.GetRole("127.0.0.1:6382", &utils.Credential{Auth: globalEnv.StoreAuth()})
 	c.Assert(err, IsNil)
 	c.Assert(role, Equals, "master")
 
@@ -365,7 +365,7 @@ func (s *testAgentSuite) TestHA(c *C) {
 	time.Sleep(3 * time.Second)
 
 	// now agentStoreMaster restart and redis restart
-	role, err = utils.GetRole("127.0.0.1:6381", globalEnv.StoreAuth())
+	role, err = utils.GetRole("127.0.0.1:6381", &utils.Credential{Auth: globalEnv.StoreAuth()})
 	c.Assert(err, IsNil)
 	c.Assert(role, Equals, "master")
 
@@ -373,7 +373,7 @@ func (s *testAgentSuite) TestHA(c *C) {
 
 	s.checkStoreServerType(c, "127.0.0.1:6381", models.SERVER_TYPE_SLAVE)
 
-	role, err = utils.GetRole("127.0.0.1:6381", globalEnv.StoreAuth())
+	role, err = utils.GetRole("127.0.0.1:6381", &utils.Credential{Auth: globalEnv.StoreAuth()})
 	c.Assert(err, IsNil)
 	c.Assert(role, Equals, "slave")
 }
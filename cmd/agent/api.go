@@ -201,7 +201,19 @@ func apiListProcs(w http.ResponseWriter, r *http.Request) {
 func apiCheckStore(w http.ResponseWriter, r *http.Request) {
 	addr := r.FormValue("addr")
 
-	err := utils.Ping(addr, globalEnv.StoreAuth())
+	creds, err := getCredentials()
+	if err != nil {
+		respError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	cred, err := creds.GetServer(globalConn, globalEnv.ProductName(), addr)
+	if err != nil {
+		respError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	err = utils.Ping(addr, cred)
 	if err != nil {
 		respError(w, http.StatusServiceUnavailable, err.Error())
 		return
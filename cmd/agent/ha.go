@@ -72,12 +72,24 @@ func (t *haTask) Interrupted() bool {
 	return false
 }
 
+// getCredentials returns the credentials of the servers, they are read in
+// every check, so the rotated ones are used at once.
+func getCredentials() (*models.Credentials, error) {
+	creds, err := models.GetCredentials(globalConn, globalEnv.ProductName(), globalEnv.StoreAuth())
+	return creds, errors.Trace(err)
+}
+
 func (t *haTask) check() error {
 	groups, err := models.ServerGroups(globalConn, globalEnv.ProductName())
 	if err != nil {
 		return errors.Trace(err)
 	}
 
+	creds, err := getCredentials()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
 	cnt := 0
 
 	const checkChanSize = 100
@@ -88,7 +100,7 @@ func (t *haTask) check() error {
 		for _, server := range group.Servers {
 			cnt++
 
-			go t.checkGroupServer(server, ch)
+			go t.checkGroupServer(creds, server, ch)
 		}
 	}
 
@@ -119,7 +131,7 @@ func (t *haTask) check() error {
 
 		s.Type = models.SERVER_TYPE_OFFLINE
 
-		if err := group.AddServer(globalConn, s, globalEnv.StoreAuth()); err != nil {
+		if err := group.AddServer(globalConn, s, creds); err != nil {
 			return errors.Trace(err)
 		}
 	}
@@ -127,7 +139,7 @@ func (t *haTask) check() error {
 	for _, s := range crashMasters {
 		log.Infof("master %s in group %d is down, do failover", s.Addr, s.GroupId)
 
-		if err := t.doFailover(s); err != nil {
+		if err := t.doFailover(creds, s); err != nil {
 			log.Errorf("master %s in group %d is down, do failover err: %v", s.Addr, s.GroupId, err)
 		}
 	}
@@ -135,7 +147,7 @@ func (t *haTask) check() error {
 	return nil
 }
 
-func (t *haTask) checkGroupServer(s *models.Server, ch chan<- interface{}) {
+func (t *haTask) checkGroupServer(creds *models.Credentials, s *models.Server, ch chan<- interface{}) {
 	// we don't check offline server
 	if s.Type == models.SERVER_TYPE_OFFLINE {
 		ch <- nil
@@ -144,7 +156,7 @@ func (t *haTask) checkGroupServer(s *models.Server, ch chan<- interface{}) {
 
 	var err error
 	for i := 0; i < haMaxRetryNum; i++ {
-		if err = utils.Ping(s.Addr, globalEnv.StoreAuth()); err == nil {
+		if err = utils.Ping(s.Addr, creds.Get(s.GroupId, s.Addr)); err == nil {
 			break
 		}
 
@@ -229,7 +241,7 @@ func (t *haTask) checkGroupServer(s *models.Server, ch chan<- interface{}) {
 	ch <- s
 }
 
-func (t *haTask) doFailover(s *models.Server) error {
+func (t *haTask) doFailover(creds *models.Credentials, s *models.Server) error {
 	// first get all slaves
 	group := models.NewServerGroup(globalEnv.ProductName(), s.GroupId)
 
@@ -251,14 +263,14 @@ func (t *haTask) doFailover(s *models.Server) error {
 
 	// elect a new master
 	log.Infof("elect a new master in %v", slaveAddrs)
-	addr, err := t.electNewMaster(slaves)
+	addr, err := t.electNewMaster(creds, slaves)
 	if err != nil {
 		return errors.Trace(err)
 	}
 
 	// prmote it as new master
 	log.Infof("promote %s as the new master", addr)
-	if err := group.Promote(globalConn, addr, globalEnv.StoreAuth()); err != nil {
+	if err := group.Promote(globalConn, addr, creds); err != nil {
 		// should we fatal here and let human intervention ???
 		return errors.Trace(err)
 	}
@@ -270,7 +282,7 @@ func (t *haTask) doFailover(s *models.Server) error {
 		}
 
 		log.Infof("let %s slaveof new master %s", slave.Addr, addr)
-		if err := utils.SlaveOf(slave.Addr, addr, globalEnv.StoreAuth()); err != nil {
+		if err := utils.SlaveOf(slave.Addr, addr, creds.Get(slave.GroupId, slave.Addr)); err != nil {
 			// should we fatal here and let human intervention ???
 			return errors.Trace(err)
 		}
@@ -279,8 +291,8 @@ func (t *haTask) doFailover(s *models.Server) error {
 	return nil
 }
 
-func (t *haTask) getReplicationInfo(s *models.Server) (map[string]string, error) {
-	v, err := utils.GetRedisInfo(s.Addr, "REPLICATION", globalEnv.StoreAuth())
+func (t *haTask) getReplicationInfo(creds *models.Credentials, s *models.Server) (map[string]string, error) {
+	v, err := utils.GetRedisInfo(s.Addr, "REPLICATION", creds.Get(s.GroupId, s.Addr))
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -299,13 +311,13 @@ func (t *haTask) getReplicationInfo(s *models.Server) (map[string]string, error)
 	return m, nil
 }
 
-func (t *haTask) electNewMaster(slaves []*models.Server) (string, error) {
+func (t *haTask) electNewMaster(creds *models.Credentials, slaves []*models.Server) (string, error) {
 	var addr string
 	var checkOffset int64 = 0
 	var checkPriority int = 0
 
 	for _, slave := range slaves {
-		m, err := t.getReplicationInfo(slave)
+		m, err := t.getReplicationInfo(creds, slave)
 		if err != nil {
 			return "", errors.Errorf("slave %s get replication info err %v", slave.Addr, err)
 		}
@@ -0,0 +1,77 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/docopt/docopt-go"
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	"github.com/reborndb/reborn/pkg/models"
+)
+
+func cmdCommand(argv []string) (err error) {
+	usage := `usage:
+	reborn-config command list
+	reborn-config command set <json_file>
+	reborn-config command reset
+
+options:
+	list	show the command overrides of proxy
+	set	replace the command overrides with a json array in file
+	reset	remove all the command overrides, proxy uses its built-in table
+`
+	args, err := docopt.Parse(usage, argv, true, "", false)
+	if err != nil {
+		log.Error(err)
+		return errors.Trace(err)
+	}
+	log.Debug(args)
+
+	if args["list"].(bool) {
+		return errors.Trace(runCommandList())
+	}
+
+	if args["reset"].(bool) {
+		return errors.Trace(runSetCommands([]*models.Command{}))
+	}
+
+	if args["set"].(bool) {
+		data, err := ioutil.ReadFile(args["<json_file>"].(string))
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		var cmds []*models.Command
+		if err = json.Unmarshal(data, &cmds); err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(runSetCommands(cmds))
+	}
+
+	return nil
+}
+
+func runCommandList() error {
+	var v interface{}
+	err := callApi(METHOD_GET, "/api/commands", nil, &v)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Println(jsonify(v))
+	return nil
+}
+
+func runSetCommands(cmds []*models.Command) error {
+	var v interface{}
+	err := callApi(METHOD_PUT, "/api/commands", cmds, &v)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Println(jsonify(v))
+	return nil
+}
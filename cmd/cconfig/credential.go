@@ -0,0 +1,77 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/docopt/docopt-go"
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	"github.com/reborndb/reborn/pkg/models"
+)
+
+func cmdCredential(argv []string) (err error) {
+	usage := `usage:
+	reborn-config credential list
+	reborn-config credential set <json_file>
+	reborn-config credential reset
+
+options:
+	list	show the backend credentials, passwords are masked
+	set	replace the backend credentials with a json object in file
+	reset	remove all the backend credentials, store_auth is used
+`
+	args, err := docopt.Parse(usage, argv, true, "", false)
+	if err != nil {
+		log.Error(err)
+		return errors.Trace(err)
+	}
+	log.Debug(args)
+
+	if args["list"].(bool) {
+		return errors.Trace(runCredentialList())
+	}
+
+	if args["reset"].(bool) {
+		return errors.Trace(runSetCredentials(&models.Credentials{}))
+	}
+
+	if args["set"].(bool) {
+		data, err := ioutil.ReadFile(args["<json_file>"].(string))
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		creds := &models.Credentials{}
+		if err = json.Unmarshal(data, creds); err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(runSetCredentials(creds))
+	}
+
+	return nil
+}
+
+func runCredentialList() error {
+	var v interface{}
+	err := callApi(METHOD_GET, "/api/credentials", nil, &v)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Println(jsonify(v))
+	return nil
+}
+
+func runSetCredentials(creds *models.Credentials) error {
+	var v interface{}
+	err := callApi(METHOD_PUT, "/api/credentials", creds, &v)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Println(jsonify(v))
+	return nil
+}
@@ -278,12 +278,23 @@ func runDashboard(addr string, httpLogFile string) {
 	m.Get("/api/slot/list", apiGetSlots)
 	m.Get("/api/slot/:id", apiGetSingleSlot)
 	m.Post("/api/slots/init", apiInitSlots)
+	m.Get("/api/slots/config", apiGetSlotConfig)
+	m.Post("/api/slots/reshard", apiReshardSlots)
 	m.Get("/api/slots", apiGetSlots)
 	m.Post("/api/slot", binding.Json(RangeSetTask{}), apiSlotRangeSet)
 	m.Get("/api/proxy/list", apiGetProxyList)
 	m.Get("/api/proxy/debug/vars", apiGetProxyDebugVars)
 	m.Post("/api/proxy", binding.Json(models.ProxyInfo{}), apiSetProxyStatus)
 
+	m.Get("/api/commands", apiGetCommands)
+	m.Put("/api/commands", apiSetCommands)
+
+	m.Get("/api/credentials", apiGetCredentials)
+	m.Put("/api/credentials", apiSetCredentials)
+
+	m.Get("/api/users", apiGetUsers)
+	m.Put("/api/users", apiSetUsers)
+
 	m.Get("/api/action/gc", apiActionGC)
 	m.Get("/api/force_remove_locks", apiForceRemoveLocks)
 	m.Get("/api/remove_fence", apiRemoveFence)
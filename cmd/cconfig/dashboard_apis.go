@@ -55,12 +55,12 @@ func apiOverview() (int, string) {
 		return 500, err.Error()
 	}
 
-	var instances []string
+	var instances []*models.Server
 
 	for _, group := range groups {
 		for _, srv := range group.Servers {
 			if srv.Type == "master" {
-				instances = append(instances, srv.Addr)
+				instances = append(instances, srv)
 			}
 		}
 	}
@@ -71,9 +71,14 @@ func apiOverview() (int, string) {
 
 	redisInfos := make([]map[string]string, 0)
 
+	creds, err := getCredentials(conn)
+	if err != nil {
+		return 500, err.Error()
+	}
+
 	if len(instances) > 0 {
 		for _, instance := range instances {
-			info, err := utils.GetRedisStat(instance, globalEnv.StoreAuth())
+			info, err := utils.GetRedisStat(instance.Addr, creds.Get(instance.GroupId, instance.Addr))
 			if err != nil {
 				log.Error(err)
 			}
@@ -119,16 +124,81 @@ func apiInitSlots(r *http.Request) (int, string) {
 		}
 	}
 
-	if err := models.InitSlotSet(conn, globalEnv.ProductName(), models.DEFAULT_SLOT_NUM); err != nil {
+	// slot_num and hash change the slot layout, the current one is kept if not given
+	cfg, err := models.GetSlotConfig(conn, globalEnv.ProductName())
+	if err != nil {
+		log.Warning(err)
+		return 500, err.Error()
+	}
+
+	if val := r.FormValue("slot_num"); len(val) > 0 {
+		if cfg.Num, err = strconv.Atoi(val); err != nil {
+			return 500, err.Error()
+		}
+	}
+
+	if val := r.FormValue("hash"); len(val) > 0 {
+		cfg.Hash = val
+	}
+
+	// a new layout is refused while any proxy is running
+	if err := models.InitSlotLayout(conn, globalEnv.ProductName(), cfg); err != nil {
 		log.Warning(err)
 		return 500, err.Error()
 	}
 	return jsonRetSucc()
 }
 
+func apiGetSlotConfig() (int, string) {
+	conn := CreateCoordConn()
+	defer conn.Close()
+
+	cfg, err := models.GetSlotConfig(conn, globalEnv.ProductName())
+	if err != nil {
+		log.Warning(err)
+		return 500, err.Error()
+	}
+
+	b, err := json.MarshalIndent(cfg, " ", "  ")
+	return 200, string(b)
+}
+
+func apiReshardSlots(r *http.Request) (int, string) {
+	r.ParseForm()
+
+	n, err := strconv.Atoi(r.FormValue("factor"))
+	if err != nil {
+		return 500, err.Error()
+	}
+
+	conn := CreateCoordConn()
+	defer conn.Close()
+
+	lock := utils.GetCoordLock(conn, globalEnv.ProductName())
+	lock.Lock(fmt.Sprintf("reshard slots, factor %d", n))
+	defer func() {
+		err := lock.Unlock()
+		if err != nil {
+			log.Warning(err)
+		}
+	}()
+
+	if err := models.ReshardSlots(conn, globalEnv.ProductName(), n); err != nil {
+		log.Warning(errors.ErrorStack(err))
+		return 500, err.Error()
+	}
+
+	return jsonRetSucc()
+}
+
 func apiRedisStat(param martini.Params) (int, string) {
 	addr := param["addr"]
-	info, err := utils.GetRedisStat(addr, globalEnv.StoreAuth())
+	cred, err := getServerCredential(addr)
+	if err != nil {
+		return 500, err.Error()
+	}
+
+	info, err := utils.GetRedisStat(addr, cred)
 	if err != nil {
 		return 500, err.Error()
 	}
@@ -137,6 +207,14 @@ func apiRedisStat(param martini.Params) (int, string) {
 }
 
 func apiDoMigrate(taskForm MigrateTaskInfo, param martini.Params) (int, string) {
+	conn := CreateCoordConn()
+	defer conn.Close()
+
+	// refuse at once instead of failing the task later
+	if _, err := checkMigrateLayout(conn, globalEnv.ProductName()); err != nil {
+		return 500, err.Error()
+	}
+
 	// do migrate async
 	taskForm.Status = MIGRATE_TASK_PENDING
 	taskForm.CreateAt = strconv.FormatInt(time.Now().Unix(), 10)
@@ -182,6 +260,13 @@ func apiRebalance(param martini.Params) (int, string) {
 		return 500, "rebalancing..."
 	}
 
+	conn := CreateCoordConn()
+	defer conn.Close()
+
+	if _, err := checkMigrateLayout(conn, globalEnv.ProductName()); err != nil {
+		return 500, err.Error()
+	}
+
 	go func() {
 		changeRebalanceStat(true)
 		defer changeRebalanceStat(false)
@@ -266,7 +351,13 @@ func apiGetRedisSlotInfo(param martini.Params) (int, string) {
 		return 500, err.Error()
 	}
 
-	slotInfo, err := utils.SlotsInfo(addr, slotId, slotId, globalEnv.StoreAuth())
+	cred, err := getServerCredential(addr)
+	if err != nil {
+		log.Warning(err)
+		return 500, err.Error()
+	}
+
+	slotInfo, err := utils.SlotsInfo(addr, slotId, slotId, cred)
 	if err != nil {
 		log.Warning(err)
 		return 500, err.Error()
@@ -312,7 +403,13 @@ func apiGetRedisSlotInfoFromGroupId(param martini.Params) (int, string) {
 		return 500, "master not found"
 	}
 
-	slotInfo, err := utils.SlotsInfo(s.Addr, slotId, slotId, globalEnv.StoreAuth())
+	creds, err := getCredentials(conn)
+	if err != nil {
+		log.Warning(err)
+		return 500, err.Error()
+	}
+
+	slotInfo, err := utils.SlotsInfo(s.Addr, slotId, slotId, creds.Get(groupId, s.Addr))
 	if err != nil {
 		log.Warning(err)
 		return 500, err.Error()
@@ -420,7 +517,13 @@ func apiAddServerToGroup(server models.Server, param martini.Params) (int, strin
 		}
 	}
 
-	if err := serverGroup.AddServer(conn, &server, globalEnv.StoreAuth()); err != nil {
+	creds, err := getCredentials(conn)
+	if err != nil {
+		log.Warning(err)
+		return 500, err.Error()
+	}
+
+	if err := serverGroup.AddServer(conn, &server, creds); err != nil {
 		log.Warning(errors.ErrorStack(err))
 		return 500, err.Error()
 	}
@@ -446,7 +549,13 @@ func apiPromoteServer(server models.Server, param martini.Params) (int, string)
 		log.Warning(err)
 		return 500, err.Error()
 	}
-	err = group.Promote(conn, server.Addr, globalEnv.StoreAuth())
+	creds, err := getCredentials(conn)
+	if err != nil {
+		log.Warning(err)
+		return 500, err.Error()
+	}
+
+	err = group.Promote(conn, server.Addr, creds)
 	if err != nil {
 		log.Warning(errors.ErrorStack(err))
 		log.Warning(err)
@@ -626,3 +735,131 @@ func apiRemoveFence() (int, string) {
 	return jsonRetSucc()
 
 }
+
+func apiGetCommands() (int, string) {
+	conn := CreateCoordConn()
+	defer conn.Close()
+
+	cmds, err := models.GetCommands(conn, globalEnv.ProductName())
+	if err != nil {
+		log.Warning(errors.ErrorStack(err))
+		return 500, err.Error()
+	}
+
+	if cmds == nil {
+		cmds = []*models.Command{}
+	}
+
+	b, err := json.MarshalIndent(cmds, " ", "  ")
+	return 200, string(b)
+}
+
+func apiSetCommands(r *http.Request) (int, string) {
+	var cmds []*models.Command
+	if err := json.NewDecoder(r.Body).Decode(&cmds); err != nil {
+		return 500, err.Error()
+	}
+
+	conn := CreateCoordConn()
+	defer conn.Close()
+
+	lock := utils.GetCoordLock(conn, globalEnv.ProductName())
+	lock.Lock(fmt.Sprintf("set command overrides, %d commands", len(cmds)))
+	defer func() {
+		err := lock.Unlock()
+		if err != nil {
+			log.Warning(err)
+		}
+	}()
+
+	if err := models.SetCommands(conn, globalEnv.ProductName(), cmds); err != nil {
+		log.Warning(errors.ErrorStack(err))
+		return 500, err.Error()
+	}
+
+	return jsonRetSucc()
+}
+
+func apiGetCredentials() (int, string) {
+	conn := CreateCoordConn()
+	defer conn.Close()
+
+	creds, err := getCredentials(conn)
+	if err != nil {
+		log.Warning(errors.ErrorStack(err))
+		return 500, err.Error()
+	}
+
+	b, err := json.MarshalIndent(creds.Masked(), " ", "  ")
+	return 200, string(b)
+}
+
+func apiSetCredentials(r *http.Request) (int, string) {
+	var creds models.Credentials
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		return 500, err.Error()
+	}
+
+	conn := CreateCoordConn()
+	defer conn.Close()
+
+	lock := utils.GetCoordLock(conn, globalEnv.ProductName())
+	lock.Lock(fmt.Sprintf("set backend credentials, %v", &creds))
+	defer func() {
+		err := lock.Unlock()
+		if err != nil {
+			log.Warning(err)
+		}
+	}()
+
+	if err := models.SetCredentials(conn, globalEnv.ProductName(), &creds); err != nil {
+		log.Warning(errors.ErrorStack(err))
+		return 500, err.Error()
+	}
+
+	return jsonRetSucc()
+}
+
+func apiGetUsers() (int, string) {
+	conn := CreateCoordConn()
+	defer conn.Close()
+
+	users, err := models.GetUsers(conn, globalEnv.ProductName())
+	if err != nil {
+		log.Warning(errors.ErrorStack(err))
+		return 500, err.Error()
+	}
+
+	if users == nil {
+		users = []*models.User{}
+	}
+
+	b, err := json.MarshalIndent(users, " ", "  ")
+	return 200, string(b)
+}
+
+func apiSetUsers(r *http.Request) (int, string) {
+	var users []*models.User
+	if err := json.NewDecoder(r.Body).Decode(&users); err != nil {
+		return 500, err.Error()
+	}
+
+	conn := CreateCoordConn()
+	defer conn.Close()
+
+	lock := utils.GetCoordLock(conn, globalEnv.ProductName())
+	lock.Lock(fmt.Sprintf("set proxy users, %d users", len(users)))
+	defer func() {
+		err := lock.Unlock()
+		if err != nil {
+			log.Warning(err)
+		}
+	}()
+
+	if err := models.SetUsers(conn, globalEnv.ProductName(), users); err != nil {
+		log.Warning(errors.ErrorStack(err))
+		return 500, err.Error()
+	}
+
+	return jsonRetSucc()
+}
@@ -52,6 +52,9 @@ commands:
     dashboard
     action
     proxy
+    command
+    credential
+    user
 `
 
 func Fatal(msg interface{}) {
@@ -87,6 +90,12 @@ func runCommand(cmd string, args []string) (err error) {
 		return errors.Trace(cmdProxy(argv))
 	case "slot":
 		return errors.Trace(cmdSlot(argv))
+	case "command":
+		return errors.Trace(cmdCommand(argv))
+	case "credential":
+		return errors.Trace(cmdCredential(argv))
+	case "user":
+		return errors.Trace(cmdUser(argv))
 	}
 	return errors.Errorf("%s is not a valid command. See 'reborn-config -h'", cmd)
 }
@@ -42,8 +42,8 @@ func sendRedisMigrateCmd(c redis.Conn, slotId int, toAddr string) (int, int, err
 	return succ, remain, nil
 }
 
-func checkMaster(addr string) error {
-	if master, err := utils.GetRole(addr, ""); err != nil {
+func checkMaster(addr string, cred *utils.Credential) error {
+	if master, err := utils.GetRole(addr, cred); err != nil {
 		return errors.Trace(err)
 	} else if master != "master" {
 		return ErrServerIsNotMaster
@@ -79,15 +79,22 @@ func (m *RebornSlotMigrator) Migrate(slot *models.Slot, fromGroup, toGroup int,
 		return ErrGroupMasterNotFound
 	}
 
-	if err = checkMaster(fromMaster.Addr); err != nil {
+	creds, err := getCredentials(task.coordConn)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	fromCred := creds.Get(fromGroup, fromMaster.Addr)
+	if err = checkMaster(fromMaster.Addr, fromCred); err != nil {
 		return errors.Trace(err)
 	}
 
-	if err = checkMaster(toMaster.Addr); err != nil {
+	if err = checkMaster(toMaster.Addr, creds.Get(toGroup, toMaster.Addr)); err != nil {
 		return errors.Trace(err)
 	}
 
-	c, err := redis.Dial("tcp", fromMaster.Addr)
+	// no read timeout, migrating a slot may take a long time
+	c, err := utils.DialRedis(fromMaster.Addr, fromCred, utils.RedisConnConnectTimeout, 0, 0)
 	if err != nil {
 		return errors.Trace(err)
 	}
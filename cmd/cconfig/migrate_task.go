@@ -156,10 +156,34 @@ func (t *MigrateTask) run() error {
 	return nil
 }
 
+// checkMigrateLayout returns the slot layout of the product, or an error if
+// the slots can not be migrated. The backend migrates its own slots, which
+// are the same only in the default layout.
+func checkMigrateLayout(conn zkhelper.Conn, productName string) (*models.SlotConfig, error) {
+	cfg, err := models.GetSlotConfig(conn, productName)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if !cfg.IsBackendLayout() {
+		return nil, errors.Errorf("can not migrate slots of layout %s, backend slots are %s %d", cfg, models.SLOT_HASH_CRC32, models.DEFAULT_SLOT_NUM)
+	}
+
+	return cfg, nil
+}
+
 func preMigrateCheck(t *MigrateTask) (bool, error) {
 	conn := CreateCoordConn()
 	defer conn.Close()
 
+	cfg, err := checkMigrateLayout(conn, t.productName)
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+	if t.FromSlot < 0 || t.ToSlot >= cfg.Num || t.FromSlot > t.ToSlot {
+		return false, errors.Errorf("invalid slot range [%d, %d]", t.FromSlot, t.ToSlot)
+	}
+
 	slots, err := models.GetMigratingSlots(conn, t.productName)
 
 	if err != nil {
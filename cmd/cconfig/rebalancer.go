@@ -33,6 +33,11 @@ func getLivingNodeInfos(coordConn zkhelper.Conn) ([]*NodeInfo, error) {
 			slotMap[slot.GroupId] = append(slotMap[slot.GroupId], slot.Id)
 		}
 	}
+	creds, err := getCredentials(coordConn)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
 	var ret []*NodeInfo
 	for _, g := range groups {
 		master, err := g.Master(coordConn)
@@ -42,7 +47,7 @@ func getLivingNodeInfos(coordConn zkhelper.Conn) ([]*NodeInfo, error) {
 		if master == nil {
 			return nil, errors.Errorf("group %d has no master", g.Id)
 		}
-		out, err := utils.GetRedisConfig(master.Addr, "maxmemory", globalEnv.StoreAuth())
+		out, err := utils.GetRedisConfig(master.Addr, "maxmemory", creds.Get(g.Id, master.Addr))
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
@@ -60,11 +65,15 @@ func getLivingNodeInfos(coordConn zkhelper.Conn) ([]*NodeInfo, error) {
 		}
 		ret = append(ret, node)
 	}
+	cfg, err := models.GetSlotConfig(coordConn, globalEnv.ProductName())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
 	cnt := 0
 	for _, info := range ret {
 		cnt += len(info.CurSlots)
 	}
-	if cnt != models.DEFAULT_SLOT_NUM {
+	if cnt != cfg.Num {
 		return nil, errors.New("not all slots are online")
 	}
 	return ret, nil
@@ -76,6 +85,11 @@ func getQuotaMap(coordConn zkhelper.Conn) (map[int]int, error) {
 		return nil, errors.Trace(err)
 	}
 
+	cfg, err := models.GetSlotConfig(coordConn, globalEnv.ProductName())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
 	ret := make(map[int]int)
 	var totalMem int64
 	totalQuota := 0
@@ -84,15 +98,15 @@ func getQuotaMap(coordConn zkhelper.Conn) (map[int]int, error) {
 	}
 
 	for _, node := range nodes {
-		quota := int(models.DEFAULT_SLOT_NUM * node.MaxMemory * 1.0 / totalMem)
+		quota := int(int64(cfg.Num) * node.MaxMemory / totalMem)
 		ret[node.GroupId] = quota
 		totalQuota += quota
 	}
 
 	// round up
-	if totalQuota < models.DEFAULT_SLOT_NUM {
+	if totalQuota < cfg.Num {
 		for k, _ := range ret {
-			ret[k] += models.DEFAULT_SLOT_NUM - totalQuota
+			ret[k] += cfg.Num - totalQuota
 			break
 		}
 	}
@@ -102,6 +116,11 @@ func getQuotaMap(coordConn zkhelper.Conn) (map[int]int, error) {
 
 // experimental simple auto rebalance :)
 func Rebalance(coordConn zkhelper.Conn, delay int) error {
+	// no task can be run if the slots can not be migrated
+	if _, err := checkMigrateLayout(coordConn, globalEnv.ProductName()); err != nil {
+		return errors.Trace(err)
+	}
+
 	targetQuota, err := getQuotaMap(coordConn)
 	if err != nil {
 		return errors.Trace(err)
@@ -5,6 +5,7 @@ package main
 
 import (
 	"fmt"
+	"net/url"
 	"strconv"
 
 	"github.com/docopt/docopt-go"
@@ -14,7 +15,9 @@ import (
 
 func cmdSlot(argv []string) (err error) {
 	usage := `usage:
-	reborn-config slot init [-f]
+	reborn-config slot init [-f] [--num=<slot_num>] [--hash=<crc32|crc16>]
+	reborn-config slot config
+	reborn-config slot reshard <factor>
 	reborn-config slot info <slot_id>
 	reborn-config slot set <slot_id> <group_id> <status>
 	reborn-config slot range-set <slot_from> <slot_to> <group_id> <status>
@@ -68,7 +71,27 @@ func cmdSlot(argv []string) (err error) {
 
 	if args["init"].(bool) {
 		force := args["-f"].(bool)
-		return runSlotInit(force)
+		var num, hash string
+		if args["--num"] != nil {
+			num = args["--num"].(string)
+		}
+		if args["--hash"] != nil {
+			hash = args["--hash"].(string)
+		}
+		return runSlotInit(force, num, hash)
+	}
+
+	if args["config"].(bool) {
+		return runSlotConfig()
+	}
+
+	if args["reshard"].(bool) {
+		factor, err := strconv.Atoi(args["<factor>"].(string))
+		if err != nil {
+			log.Warning(err)
+			return errors.Trace(err)
+		}
+		return runSlotReshard(factor)
 	}
 
 	if args["info"].(bool) {
@@ -113,13 +136,39 @@ func cmdSlot(argv []string) (err error) {
 	return nil
 }
 
-func runSlotInit(isForce bool) error {
+func runSlotInit(isForce bool, num string, hash string) error {
 	var v interface{}
-	url := "/api/slots/init"
+	params := url.Values{}
 	if isForce {
-		url += "?is_force=1"
+		params.Set("is_force", "1")
+	}
+	if len(num) > 0 {
+		params.Set("slot_num", num)
+	}
+	if len(hash) > 0 {
+		params.Set("hash", hash)
+	}
+	err := callApi(METHOD_POST, "/api/slots/init?"+params.Encode(), nil, &v)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Println(jsonify(v))
+	return nil
+}
+
+func runSlotConfig() error {
+	var v interface{}
+	err := callApi(METHOD_GET, "/api/slots/config", nil, &v)
+	if err != nil {
+		return errors.Trace(err)
 	}
-	err := callApi(METHOD_POST, url, nil, &v)
+	fmt.Println(jsonify(v))
+	return nil
+}
+
+func runSlotReshard(factor int) error {
+	var v interface{}
+	err := callApi(METHOD_POST, fmt.Sprintf("/api/slots/reshard?factor=%d", factor), nil, &v)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -0,0 +1,77 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/docopt/docopt-go"
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	"github.com/reborndb/reborn/pkg/models"
+)
+
+func cmdUser(argv []string) (err error) {
+	usage := `usage:
+	reborn-config user list
+	reborn-config user set <json_file>
+	reborn-config user reset
+
+options:
+	list	show the proxy users, passwords are shown as sha256
+	set	replace the proxy users with a json array in file
+	reset	remove all the proxy users, only the proxy auth is used
+`
+	args, err := docopt.Parse(usage, argv, true, "", false)
+	if err != nil {
+		log.Error(err)
+		return errors.Trace(err)
+	}
+	log.Debug(args)
+
+	if args["list"].(bool) {
+		return errors.Trace(runUserList())
+	}
+
+	if args["reset"].(bool) {
+		return errors.Trace(runSetUsers([]*models.User{}))
+	}
+
+	if args["set"].(bool) {
+		data, err := ioutil.ReadFile(args["<json_file>"].(string))
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		var users []*models.User
+		if err = json.Unmarshal(data, &users); err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(runSetUsers(users))
+	}
+
+	return nil
+}
+
+func runUserList() error {
+	var v interface{}
+	err := callApi(METHOD_GET, "/api/users", nil, &v)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Println(jsonify(v))
+	return nil
+}
+
+func runSetUsers(users []*models.User) error {
+	var v interface{}
+	err := callApi(METHOD_PUT, "/api/users", users, &v)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	fmt.Println(jsonify(v))
+	return nil
+}
@@ -8,6 +8,9 @@ import (
 
 	"github.com/juju/errors"
 	"github.com/ngaut/log"
+	"github.com/ngaut/zkhelper"
+	"github.com/reborndb/reborn/pkg/models"
+	"github.com/reborndb/reborn/pkg/utils"
 )
 
 const (
@@ -63,3 +66,25 @@ func callApi(method HttpMethod, apiPath string, params interface{}, retVal inter
 
 	return errors.Errorf("http status code %d, %s", resp.StatusCode, string(body))
 }
+
+// getCredentials returns the credentials of the servers, the dashboard and
+// migrator read them in every use like the proxies, so the rotated ones are
+// used without restart.
+func getCredentials(coordConn zkhelper.Conn) (*models.Credentials, error) {
+	creds, err := models.GetCredentials(coordConn, globalEnv.ProductName(), globalEnv.StoreAuth())
+	return creds, errors.Trace(err)
+}
+
+// getServerCredential returns the credential of the server by its address.
+func getServerCredential(addr string) (*utils.Credential, error) {
+	conn := CreateCoordConn()
+	defer conn.Close()
+
+	creds, err := getCredentials(conn)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	cred, err := creds.GetServer(conn, globalEnv.ProductName(), addr)
+	return cred, errors.Trace(err)
+}
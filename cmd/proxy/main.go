@@ -163,6 +163,9 @@ func main() {
 	log.Info("running on ", addr)
 
 	s := router.NewServer(conf)
+	http.HandleFunc("/slowlog", s.ServeSlowlog)
+	http.HandleFunc("/metrics", s.ServeMetrics)
+	http.HandleFunc("/clients", s.ServeClients)
 	s.Run()
 	log.Warning("exit")
 }
@@ -29,6 +29,9 @@ const (
 	ACTION_TYPE_MULTI_SLOT_CHANGED   ActionType = "multi_slot_changed"
 	ACTION_TYPE_SLOT_MIGRATE         ActionType = "slot_migrate"
 	ACTION_TYPE_SLOT_PREMIGRATE      ActionType = "slot_premigrate"
+	ACTION_TYPE_COMMANDS_CHANGED     ActionType = "commands_changed"
+	ACTION_TYPE_CREDENTIALS_CHANGED  ActionType = "credentials_changed"
+	ACTION_TYPE_USERS_CHANGED        ActionType = "users_changed"
 
 	ActionTimeoutMs     = 30 * 1000
 	CheckTimeIntervalMs = 500
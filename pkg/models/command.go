@@ -0,0 +1,119 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/go-zookeeper/zk"
+	"github.com/ngaut/zkhelper"
+)
+
+// command flags
+const (
+	COMMAND_FLAG_READONLY = "readonly"
+	COMMAND_FLAG_WRITE    = "write"
+	COMMAND_FLAG_ADMIN    = "admin"
+	COMMAND_FLAG_BLOCKING = "blocking"
+	COMMAND_FLAG_DENIED   = "denied"
+)
+
+// Command overrides the proxy built-in command table entry with the same name.
+// The key positions are like redis COMMAND, 1 is the first argument after the
+// command name and a negative last key counts from the end. NumKeys is the
+// position of the argument giving the number of keys following it, and the
+// argument after any of KeyOptions, like STORE of SORT, is a key too.
+type Command struct {
+	Name       string   `json:"name"`
+	Arity      int      `json:"arity"`
+	Flags      []string `json:"flags"`
+	FirstKey   int      `json:"first_key"`
+	LastKey    int      `json:"last_key"`
+	Step       int      `json:"step"`
+	NumKeys    int      `json:"num_keys,omitempty"`
+	KeyOptions []string `json:"key_options,omitempty"`
+}
+
+func (c *Command) String() string {
+	if c == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("[Command](%+v)", *c)
+}
+
+func (c *Command) Validate() error {
+	if len(c.Name) == 0 {
+		return errors.NotValidf("empty command name")
+	}
+
+	for _, flag := range c.Flags {
+		switch flag {
+		case COMMAND_FLAG_READONLY, COMMAND_FLAG_WRITE, COMMAND_FLAG_ADMIN,
+			COMMAND_FLAG_BLOCKING, COMMAND_FLAG_DENIED:
+		default:
+			return errors.NotValidf("command %s flag %s", c.Name, flag)
+		}
+	}
+
+	if c.FirstKey < 0 || c.NumKeys < 0 || c.Step < 0 || (c.FirstKey > 0 && c.Step == 0) {
+		return errors.NotValidf("command %s key spec", c.Name)
+	}
+
+	return nil
+}
+
+func GetCommandsPath(productName string) string {
+	return fmt.Sprintf("/zk/reborn/db_%s/commands", productName)
+}
+
+// GetCommands returns the command overrides of the product, nil if not set.
+func GetCommands(coordConn zkhelper.Conn, productName string) ([]*Command, error) {
+	data, _, err := coordConn.Get(GetCommandsPath(productName))
+	if err != nil {
+		if zkhelper.ZkErrorEqual(err, zk.ErrNoNode) {
+			return nil, nil
+		}
+		return nil, errors.Trace(err)
+	}
+
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var cmds []*Command
+	if err := json.Unmarshal(data, &cmds); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return cmds, nil
+}
+
+// SetCommands replaces the command overrides of the product and notifies the proxies.
+func SetCommands(coordConn zkhelper.Conn, productName string, cmds []*Command) error {
+	for _, cmd := range cmds {
+		if err := cmd.Validate(); err != nil {
+			return errors.Trace(err)
+		}
+		cmd.Name = strings.ToUpper(cmd.Name)
+	}
+
+	if cmds == nil {
+		cmds = []*Command{}
+	}
+
+	data, err := json.Marshal(cmds)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	_, err = zkhelper.CreateOrUpdate(coordConn, GetCommandsPath(productName), string(data), 0, zkhelper.DefaultFileACLs(), true)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return errors.Trace(NewAction(coordConn, productName, ACTION_TYPE_COMMANDS_CHANGED, cmds, "", true))
+}
@@ -0,0 +1,43 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package models
+
+import (
+	"github.com/ngaut/zkhelper"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testModelSuite) TestCommands(c *C) {
+	fakeCoordConn := zkhelper.NewConn()
+	defer fakeCoordConn.Close()
+
+	cmds, err := GetCommands(fakeCoordConn, productName)
+	c.Assert(err, IsNil)
+	c.Assert(cmds, IsNil)
+
+	err = SetCommands(fakeCoordConn, productName, []*Command{
+		{Name: "keys", Arity: 2, Flags: []string{COMMAND_FLAG_READONLY}},
+		{Name: "get", Arity: 2, Flags: []string{COMMAND_FLAG_DENIED}, FirstKey: 1, LastKey: 1, Step: 1},
+	})
+	c.Assert(err, IsNil)
+
+	cmds, err = GetCommands(fakeCoordConn, productName)
+	c.Assert(err, IsNil)
+	c.Assert(cmds, HasLen, 2)
+	c.Assert(cmds[0].Name, Equals, "KEYS")
+	c.Assert(cmds[1].Flags, DeepEquals, []string{COMMAND_FLAG_DENIED})
+
+	err = SetCommands(fakeCoordConn, productName, []*Command{{Name: "get", Flags: []string{"bad"}}})
+	c.Assert(err, NotNil)
+
+	err = SetCommands(fakeCoordConn, productName, []*Command{{Name: "get", FirstKey: 1}})
+	c.Assert(err, NotNil)
+
+	err = SetCommands(fakeCoordConn, productName, nil)
+	c.Assert(err, IsNil)
+
+	cmds, err = GetCommands(fakeCoordConn, productName)
+	c.Assert(err, IsNil)
+	c.Assert(cmds, HasLen, 0)
+}
@@ -0,0 +1,178 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/go-zookeeper/zk"
+	"github.com/ngaut/zkhelper"
+	"github.com/reborndb/reborn/pkg/utils"
+)
+
+// Credentials are how to connect to the backend servers of a product, the
+// credential of a server overrides the one of its group, which overrides
+// the default one. The local store auth is the default if none is set.
+type Credentials struct {
+	Default *utils.Credential            `json:"default,omitempty"`
+	Groups  map[int]*utils.Credential    `json:"groups,omitempty"`
+	Servers map[string]*utils.Credential `json:"servers,omitempty"`
+}
+
+func (c *Credentials) String() string {
+	if c == nil {
+		return "<nil>"
+	}
+
+	// no password in logs
+	return fmt.Sprintf("[Credentials](default:%v, groups:%d, servers:%d)", c.Default != nil, len(c.Groups), len(c.Servers))
+}
+
+// Masked returns a copy of the credentials without passwords, for display.
+func (c *Credentials) Masked() *Credentials {
+	mask := func(cred *utils.Credential) *utils.Credential {
+		if cred == nil {
+			return nil
+		}
+
+		masked := *cred
+		if len(masked.Auth) > 0 {
+			masked.Auth = "******"
+		}
+		return &masked
+	}
+
+	m := &Credentials{Default: mask(c.Default)}
+	if len(c.Groups) > 0 {
+		m.Groups = make(map[int]*utils.Credential, len(c.Groups))
+		for id, cred := range c.Groups {
+			m.Groups[id] = mask(cred)
+		}
+	}
+
+	if len(c.Servers) > 0 {
+		m.Servers = make(map[string]*utils.Credential, len(c.Servers))
+		for addr, cred := range c.Servers {
+			m.Servers[addr] = mask(cred)
+		}
+	}
+
+	return m
+}
+
+func (c *Credentials) Validate() error {
+	if err := c.Default.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+
+	for id, cred := range c.Groups {
+		if err := cred.Validate(); err != nil {
+			return errors.Annotatef(err, "group %d", id)
+		}
+	}
+
+	for addr, cred := range c.Servers {
+		if err := cred.Validate(); err != nil {
+			return errors.Annotatef(err, "server %s", addr)
+		}
+	}
+
+	return nil
+}
+
+// Get returns the credential of the server in the group, nil if none.
+func (c *Credentials) Get(groupId int, addr string) *utils.Credential {
+	if c == nil {
+		return nil
+	}
+
+	if cred, ok := c.Servers[addr]; ok {
+		return cred
+	}
+
+	if cred, ok := c.Groups[groupId]; ok {
+		return cred
+	}
+
+	return c.Default
+}
+
+// GetServer returns the credential of the server, the group is found by
+// the server address.
+func (c *Credentials) GetServer(coordConn zkhelper.Conn, productName string, addr string) (*utils.Credential, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	if cred, ok := c.Servers[addr]; ok {
+		return cred, nil
+	}
+
+	if len(c.Groups) > 0 {
+		groups, err := ServerGroups(coordConn, productName)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		for _, g := range groups {
+			for _, s := range g.Servers {
+				if s.Addr == addr {
+					return c.Get(g.Id, addr), nil
+				}
+			}
+		}
+	}
+
+	return c.Default, nil
+}
+
+func GetCredentialsPath(productName string) string {
+	return fmt.Sprintf("/zk/reborn/db_%s/credentials", productName)
+}
+
+// GetCredentials returns the credentials of the product, the default one is
+// the local store auth if not set in coordinator.
+func GetCredentials(coordConn zkhelper.Conn, productName string, storeAuth string) (*Credentials, error) {
+	creds := &Credentials{}
+
+	data, _, err := coordConn.Get(GetCredentialsPath(productName))
+	if err != nil && !zkhelper.ZkErrorEqual(err, zk.ErrNoNode) {
+		return nil, errors.Trace(err)
+	}
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, creds); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	if creds.Default == nil && len(storeAuth) > 0 {
+		creds.Default = &utils.Credential{Auth: storeAuth}
+	}
+
+	return creds, nil
+}
+
+// SetCredentials replaces the credentials of the product and notifies the
+// proxies, which reconnect to the servers whose credential changed. The
+// action has no credential, the proxies read them from the coordinator.
+func SetCredentials(coordConn zkhelper.Conn, productName string, creds *Credentials) error {
+	if err := creds.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+
+	data, err := json.Marshal(creds)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	_, err = zkhelper.CreateOrUpdate(coordConn, GetCredentialsPath(productName), string(data), 0, zkhelper.DefaultFileACLs(), true)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return errors.Trace(NewAction(coordConn, productName, ACTION_TYPE_CREDENTIALS_CHANGED, nil, "", true))
+}
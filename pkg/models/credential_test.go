@@ -0,0 +1,50 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package models
+
+import (
+	"strings"
+
+	"github.com/ngaut/zkhelper"
+	"github.com/reborndb/reborn/pkg/utils"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testModelSuite) TestCredentials(c *C) {
+	fakeCoordConn := zkhelper.NewConn()
+	defer fakeCoordConn.Close()
+
+	// the local store auth is the default
+	creds, err := GetCredentials(fakeCoordConn, productName, "store")
+	c.Assert(err, IsNil)
+	c.Assert(creds.Get(1, "127.0.0.1:6379").GetAuth(), Equals, "store")
+
+	creds, err = GetCredentials(fakeCoordConn, productName, "")
+	c.Assert(err, IsNil)
+	c.Assert(creds.Get(1, "127.0.0.1:6379"), IsNil)
+
+	err = SetCredentials(fakeCoordConn, productName, &Credentials{
+		Groups:  map[int]*utils.Credential{1: {Auth: "group1"}},
+		Servers: map[string]*utils.Credential{"127.0.0.1:6380": {Auth: "server", TLS: true}},
+	})
+	c.Assert(err, IsNil)
+
+	creds, err = GetCredentials(fakeCoordConn, productName, "store")
+	c.Assert(err, IsNil)
+	c.Assert(creds.Get(1, "127.0.0.1:6379").GetAuth(), Equals, "group1")
+	c.Assert(creds.Get(1, "127.0.0.1:6380").GetAuth(), Equals, "server")
+	c.Assert(creds.Get(1, "127.0.0.1:6380").TLS, Equals, true)
+	c.Assert(creds.Get(2, "127.0.0.1:6381").GetAuth(), Equals, "store")
+
+	// no password in logs
+	c.Assert(strings.Contains(creds.String(), "group1"), Equals, false)
+
+	masked := creds.Masked()
+	c.Assert(masked.Groups[1].Auth, Equals, "******")
+	c.Assert(masked.Servers["127.0.0.1:6380"].TLS, Equals, true)
+	c.Assert(creds.Groups[1].Auth, Equals, "group1")
+
+	err = SetCredentials(fakeCoordConn, productName, &Credentials{Default: &utils.Credential{TLSCACert: "broken"}})
+	c.Assert(err, NotNil)
+}
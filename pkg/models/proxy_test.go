@@ -20,7 +20,7 @@ import (
 
 var (
 	productName = "unit_test"
-	auth        = ""
+	creds       = &Credentials{}
 )
 
 func TestT(t *testing.T) {
@@ -117,7 +117,7 @@ func (s *testModelSuite) TestProxy(c *C) {
 
 	s1 := NewServer(SERVER_TYPE_MASTER, "localhost:1111")
 
-	g.AddServer(fakeCoordConn, s1, auth)
+	g.AddServer(fakeCoordConn, s1, creds)
 
 	err = InitSlotSet(fakeCoordConn, productName, 1024)
 	c.Assert(err, IsNil)
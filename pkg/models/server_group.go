@@ -201,7 +201,7 @@ func (sg *ServerGroup) RemoveServer(coordConn zkhelper.Conn, addr string) error
 	return errors.Trace(err)
 }
 
-func (sg *ServerGroup) Promote(conn zkhelper.Conn, addr string, auth string) error {
+func (sg *ServerGroup) Promote(conn zkhelper.Conn, addr string, creds *Credentials) error {
 	var s *Server
 	exists := false
 	for i := 0; i < len(sg.Servers); i++ {
@@ -216,7 +216,7 @@ func (sg *ServerGroup) Promote(conn zkhelper.Conn, addr string, auth string) err
 		return errors.NotFoundf("no such addr %s", addr)
 	}
 
-	err := utils.SlaveNoOne(s.Addr, auth)
+	err := utils.SlaveNoOne(s.Addr, creds.Get(sg.Id, s.Addr))
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -230,7 +230,7 @@ func (sg *ServerGroup) Promote(conn zkhelper.Conn, addr string, auth string) err
 	// old master may be nil
 	if master != nil {
 		master.Type = SERVER_TYPE_OFFLINE
-		err = sg.AddServer(conn, master, auth)
+		err = sg.AddServer(conn, master, creds)
 		if err != nil {
 			return errors.Trace(err)
 		}
@@ -238,7 +238,7 @@ func (sg *ServerGroup) Promote(conn zkhelper.Conn, addr string, auth string) err
 
 	// promote new server to master
 	s.Type = SERVER_TYPE_MASTER
-	err = sg.AddServer(conn, s, auth)
+	err = sg.AddServer(conn, s, creds)
 	return errors.Trace(err)
 }
 
@@ -276,18 +276,20 @@ func (sg *ServerGroup) Exists(coordConn zkhelper.Conn) (bool, error) {
 
 var ErrNodeExists = errors.New("node already exists")
 
-func (sg *ServerGroup) AddServer(coordConn zkhelper.Conn, s *Server, auth string) error {
+func (sg *ServerGroup) AddServer(coordConn zkhelper.Conn, s *Server, creds *Credentials) error {
 	switch s.Type {
 	case SERVER_TYPE_MASTER, SERVER_TYPE_SLAVE, SERVER_TYPE_OFFLINE:
 	default:
 		return errors.NotSupportedf("server type %q", s.Type)
 	}
+	cred := creds.Get(sg.Id, s.Addr)
+
 	// if type is offline, the server may be down, so we cannot use store function
 	if s.Type != SERVER_TYPE_OFFLINE {
 		// we only support reborn-server and qdb-server
 		// origin redis has no slot_info command
 		// atm, we can use this command to check whether server is alive or not.
-		if _, err := utils.SlotsInfo(s.Addr, 0, 0, auth); err != nil {
+		if _, err := utils.SlotsInfo(s.Addr, 0, 0, cred); err != nil {
 			return errors.Trace(err)
 		}
 	}
@@ -317,7 +319,7 @@ func (sg *ServerGroup) AddServer(coordConn zkhelper.Conn, s *Server, auth string
 	}
 
 	if s.Type == SERVER_TYPE_MASTER {
-		if role, err := utils.GetRole(s.Addr, auth); err != nil {
+		if role, err := utils.GetRole(s.Addr, cred); err != nil {
 			return errors.Trace(err)
 		} else if role != "master" {
 			return errors.Errorf("we need master, but server %s is %s", s.Addr, role)
@@ -346,7 +348,7 @@ func (sg *ServerGroup) AddServer(coordConn zkhelper.Conn, s *Server, auth string
 		}
 	} else if s.Type == SERVER_TYPE_SLAVE && len(masterAddr) > 0 {
 		// send command slaveof to slave
-		err := utils.SlaveOf(s.Addr, masterAddr, auth)
+		err := utils.SlaveOf(s.Addr, masterAddr, cred)
 		if err != nil {
 			return errors.Trace(err)
 		}
@@ -9,6 +9,7 @@ import (
 	"path"
 
 	"github.com/juju/errors"
+	"github.com/ngaut/go-zookeeper/zk"
 	"github.com/ngaut/zkhelper"
 )
 
@@ -231,6 +232,59 @@ func SetSlotRange(coordConn zkhelper.Conn, productName string, fromSlot, toSlot,
 
 // danger operation !
 func InitSlotSet(coordConn zkhelper.Conn, productName string, totalSlotNum int) error {
+	cfg, err := GetSlotConfig(coordConn, productName)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	cfg.Num = totalSlotNum
+	return errors.Trace(InitSlotLayout(coordConn, productName, cfg))
+}
+
+// InitSlotLayout saves the slot layout and creates all the slots offline.
+// The running proxies can not change the layout, so a new layout is refused
+// if any proxy is registered, like ReshardSlots.
+func InitSlotLayout(coordConn zkhelper.Conn, productName string, cfg *SlotConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+
+	cur, err := GetSlotConfig(coordConn, productName)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if *cur != *cfg {
+		proxies, err := ProxyList(coordConn, productName, nil)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if len(proxies) > 0 {
+			return errors.Errorf("proxy %s is running, stop all proxies before changing slot layout", proxies[0].ID)
+		}
+	}
+
+	if err := SetSlotConfig(coordConn, productName, cfg); err != nil {
+		return errors.Trace(err)
+	}
+
+	totalSlotNum := cfg.Num
+
+	// remove the slots of a former larger layout
+	children, _, err := coordConn.Children(GetSlotBasePath(productName))
+	if err != nil && !zkhelper.ZkErrorEqual(err, zk.ErrNoNode) {
+		return errors.Trace(err)
+	}
+	for _, p := range children {
+		var id int
+		if _, err := fmt.Sscanf(p, "slot_%d", &id); err == nil && id < totalSlotNum {
+			continue
+		}
+		if err := coordConn.Delete(path.Join(GetSlotBasePath(productName), p), -1); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
 	for i := 0; i < totalSlotNum; i++ {
 		slot := NewSlot(productName, i)
 		if err := slot.Update(coordConn); err != nil {
@@ -240,6 +294,62 @@ func InitSlotSet(coordConn zkhelper.Conn, productName string, totalSlotNum int)
 	return nil
 }
 
+// ReshardSlots splits every slot into n child slots without moving any key.
+// The children of slot i are i + k * num for k in [0, n), because
+// hash % (num * n) % num == hash % num, they keep the group of slot i.
+// The running proxies can not change the layout, so it is refused if any
+// proxy is registered or any slot is migrating.
+func ReshardSlots(coordConn zkhelper.Conn, productName string, n int) error {
+	if n < 2 {
+		return errors.NotValidf("reshard factor %d", n)
+	}
+
+	cfg, err := GetSlotConfig(coordConn, productName)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if cfg.Num*n > MAX_SLOT_NUM {
+		return errors.NotValidf("slot num %d * %d", cfg.Num, n)
+	}
+
+	proxies, err := ProxyList(coordConn, productName, nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(proxies) > 0 {
+		return errors.Errorf("proxy %s is running, stop all proxies before resharding", proxies[0].ID)
+	}
+
+	slots, err := Slots(coordConn, productName)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if len(slots) != cfg.Num {
+		return errors.Errorf("there are %d slots, expect %d, init slots first", len(slots), cfg.Num)
+	}
+
+	for _, slot := range slots {
+		if slot.State.Status != SLOT_STATUS_ONLINE && slot.State.Status != SLOT_STATUS_OFFLINE {
+			return errors.Errorf("slot %d is %s, finish migration first", slot.Id, slot.State.Status)
+		}
+	}
+
+	for _, slot := range slots {
+		for k := 1; k < n; k++ {
+			child := NewSlot(productName, slot.Id+k*cfg.Num)
+			child.GroupId = slot.GroupId
+			child.State.Status = slot.State.Status
+			if err := child.Update(coordConn); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+
+	cfg.Num *= n
+	return errors.Trace(SetSlotConfig(coordConn, productName, cfg))
+}
+
 func (s *Slot) SetMigrateStatus(coordConn zkhelper.Conn, fromGroup, toGroup int) error {
 	if fromGroup < 0 || toGroup < 0 {
 		return errors.Errorf("invalid group id, from %d, to %d", fromGroup, toGroup)
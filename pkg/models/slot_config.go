@@ -0,0 +1,129 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/go-zookeeper/zk"
+	"github.com/ngaut/zkhelper"
+)
+
+// slot hash functions
+const (
+	SLOT_HASH_CRC32 = "crc32"
+	SLOT_HASH_CRC16 = "crc16"
+)
+
+const MAX_SLOT_NUM = 65536
+
+// SlotConfig is the slot layout of a product, keys are mapped to
+// hash(key) % Num. crc32 is IEEE like the backend slots, crc16 is
+// XMODEM like redis cluster.
+type SlotConfig struct {
+	Num  int    `json:"num"`
+	Hash string `json:"hash"`
+}
+
+func DefaultSlotConfig() *SlotConfig {
+	return &SlotConfig{
+		Num:  DEFAULT_SLOT_NUM,
+		Hash: SLOT_HASH_CRC32,
+	}
+}
+
+func (c *SlotConfig) String() string {
+	if c == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("[SlotConfig](%+v)", *c)
+}
+
+func (c *SlotConfig) Validate() error {
+	if c.Num <= 0 || c.Num > MAX_SLOT_NUM {
+		return errors.NotValidf("slot num %d", c.Num)
+	}
+
+	if c.Hash != SLOT_HASH_CRC32 && c.Hash != SLOT_HASH_CRC16 {
+		return errors.NotValidf("slot hash %s", c.Hash)
+	}
+
+	return nil
+}
+
+// IsBackendLayout returns whether the slots are the same as the backend
+// slots, the backend can migrate a slot only if so.
+func (c *SlotConfig) IsBackendLayout() bool {
+	return c.Num == DEFAULT_SLOT_NUM && c.Hash == SLOT_HASH_CRC32
+}
+
+// HashSlot maps the hash key to its slot, the caller handles hash tags.
+func (c *SlotConfig) HashSlot(key []byte) int {
+	if c.Hash == SLOT_HASH_CRC16 {
+		return int(crc16(key)) % c.Num
+	}
+	return int(crc32.ChecksumIEEE(key) % uint32(c.Num))
+}
+
+func GetSlotConfigPath(productName string) string {
+	return fmt.Sprintf("/zk/reborn/db_%s/slot_config", productName)
+}
+
+// GetSlotConfig returns the slot layout of the product, the default one if not set.
+func GetSlotConfig(coordConn zkhelper.Conn, productName string) (*SlotConfig, error) {
+	data, _, err := coordConn.Get(GetSlotConfigPath(productName))
+	if err != nil {
+		if zkhelper.ZkErrorEqual(err, zk.ErrNoNode) {
+			return DefaultSlotConfig(), nil
+		}
+		return nil, errors.Trace(err)
+	}
+
+	cfg := DefaultSlotConfig()
+	if len(data) == 0 {
+		return cfg, nil
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return cfg, errors.Trace(cfg.Validate())
+}
+
+// SetSlotConfig saves the slot layout of the product, the proxies only
+// read it when starting, so it must be done by InitSlotLayout or
+// ReshardSlots.
+func SetSlotConfig(coordConn zkhelper.Conn, productName string, cfg *SlotConfig) error {
+	if err := cfg.Validate(); err != nil {
+		return errors.Trace(err)
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	_, err = zkhelper.CreateOrUpdate(coordConn, GetSlotConfigPath(productName), string(data), 0, zkhelper.DefaultFileACLs(), true)
+	return errors.Trace(err)
+}
+
+// crc16 is CRC16-CCITT (XMODEM) used by redis cluster.
+func crc16(buf []byte) uint16 {
+	var crc uint16
+	for _, b := range buf {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,166 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package models
+
+import (
+	"github.com/ngaut/log"
+	"github.com/ngaut/zkhelper"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testModelSuite) TestSlotConfig(c *C) {
+	log.Info("[TestSlotConfig][start]")
+	fakeCoordConn := zkhelper.NewConn()
+
+	cfg, err := GetSlotConfig(fakeCoordConn, productName)
+	c.Assert(err, IsNil)
+	c.Assert(cfg, DeepEquals, DefaultSlotConfig())
+	c.Assert(cfg.IsBackendLayout(), Equals, true)
+
+	err = SetSlotConfig(fakeCoordConn, productName, &SlotConfig{Num: 0, Hash: SLOT_HASH_CRC32})
+	c.Assert(err, NotNil)
+
+	err = SetSlotConfig(fakeCoordConn, productName, &SlotConfig{Num: 1024, Hash: "md5"})
+	c.Assert(err, NotNil)
+
+	err = SetSlotConfig(fakeCoordConn, productName, &SlotConfig{Num: 16384, Hash: SLOT_HASH_CRC16})
+	c.Assert(err, IsNil)
+
+	cfg, err = GetSlotConfig(fakeCoordConn, productName)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Num, Equals, 16384)
+	c.Assert(cfg.Hash, Equals, SLOT_HASH_CRC16)
+	c.Assert(cfg.IsBackendLayout(), Equals, false)
+
+	// same as redis cluster
+	c.Assert(crc16([]byte("123456789")), Equals, uint16(0x31c3))
+	c.Assert(cfg.HashSlot([]byte("foo")), Equals, 12182)
+	c.Assert(DefaultSlotConfig().HashSlot([]byte("foo")), Equals, 289)
+
+	// init slots with a smaller layout removes the extra slots
+	err = InitSlotSet(fakeCoordConn, productName, 16)
+	c.Assert(err, IsNil)
+
+	children, _, err := fakeCoordConn.Children(GetSlotBasePath(productName))
+	c.Assert(err, IsNil)
+	c.Assert(len(children), Equals, 16)
+
+	cfg, err = GetSlotConfig(fakeCoordConn, productName)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Num, Equals, 16)
+	c.Assert(cfg.Hash, Equals, SLOT_HASH_CRC16)
+
+	err = InitSlotSet(fakeCoordConn, productName, 8)
+	c.Assert(err, IsNil)
+
+	children, _, err = fakeCoordConn.Children(GetSlotBasePath(productName))
+	c.Assert(err, IsNil)
+	c.Assert(len(children), Equals, 8)
+
+	// a new layout is refused when any proxy is running, the same one is not
+	pi := &ProxyInfo{ID: "proxy_1", Addr: "localhost:1234", State: PROXY_STATE_OFFLINE}
+	_, err = CreateProxyInfo(fakeCoordConn, productName, pi)
+	c.Assert(err, IsNil)
+
+	err = InitSlotLayout(fakeCoordConn, productName, &SlotConfig{Num: 8, Hash: SLOT_HASH_CRC32})
+	c.Assert(err, NotNil)
+	err = InitSlotSet(fakeCoordConn, productName, 16)
+	c.Assert(err, NotNil)
+	err = InitSlotSet(fakeCoordConn, productName, 8)
+	c.Assert(err, IsNil)
+
+	err = fakeCoordConn.Delete(GetProxyPath(productName)+"/"+pi.ID, -1)
+	c.Assert(err, IsNil)
+
+	err = InitSlotLayout(fakeCoordConn, productName, &SlotConfig{Num: 0, Hash: SLOT_HASH_CRC32})
+	c.Assert(err, NotNil)
+	err = InitSlotLayout(fakeCoordConn, productName, &SlotConfig{Num: 8, Hash: SLOT_HASH_CRC32})
+	c.Assert(err, IsNil)
+
+	cfg, err = GetSlotConfig(fakeCoordConn, productName)
+	c.Assert(err, IsNil)
+	c.Assert(cfg, DeepEquals, &SlotConfig{Num: 8, Hash: SLOT_HASH_CRC32})
+
+	fakeCoordConn.Close()
+	log.Info("[TestSlotConfig][end]")
+}
+
+func (s *testModelSuite) TestReshardSlots(c *C) {
+	log.Info("[TestReshardSlots][start]")
+	fakeCoordConn := zkhelper.NewConn()
+
+	err := ReshardSlots(fakeCoordConn, productName, 2)
+	c.Assert(err, NotNil)
+
+	err = InitSlotSet(fakeCoordConn, productName, 4)
+	c.Assert(err, IsNil)
+
+	g1 := NewServerGroup(productName, 1)
+	g1.Create(fakeCoordConn)
+	g2 := NewServerGroup(productName, 2)
+	g2.Create(fakeCoordConn)
+
+	err = SetSlotRange(fakeCoordConn, productName, 0, 1, 1, SLOT_STATUS_ONLINE)
+	c.Assert(err, IsNil)
+	err = SetSlotRange(fakeCoordConn, productName, 2, 3, 2, SLOT_STATUS_ONLINE)
+	c.Assert(err, IsNil)
+
+	err = ReshardSlots(fakeCoordConn, productName, 1)
+	c.Assert(err, NotNil)
+
+	// refused when any slot is migrating
+	sl, err := GetSlot(fakeCoordConn, productName, 1)
+	c.Assert(err, IsNil)
+	sl.State.Status = SLOT_STATUS_MIGRATE
+	c.Assert(sl.Update(fakeCoordConn), IsNil)
+
+	err = ReshardSlots(fakeCoordConn, productName, 2)
+	c.Assert(err, NotNil)
+
+	sl.State.Status = SLOT_STATUS_ONLINE
+	c.Assert(sl.Update(fakeCoordConn), IsNil)
+
+	// refused when any proxy is running
+	pi := &ProxyInfo{
+		ID:    "proxy_1",
+		Addr:  "localhost:1234",
+		State: PROXY_STATE_OFFLINE,
+	}
+	_, err = CreateProxyInfo(fakeCoordConn, productName, pi)
+	c.Assert(err, IsNil)
+
+	err = ReshardSlots(fakeCoordConn, productName, 2)
+	c.Assert(err, NotNil)
+
+	err = fakeCoordConn.Delete(GetProxyPath(productName)+"/"+pi.ID, -1)
+	c.Assert(err, IsNil)
+
+	err = ReshardSlots(fakeCoordConn, productName, 3)
+	c.Assert(err, IsNil)
+
+	cfg, err := GetSlotConfig(fakeCoordConn, productName)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Num, Equals, 12)
+
+	slots, err := Slots(fakeCoordConn, productName)
+	c.Assert(err, IsNil)
+	c.Assert(len(slots), Equals, 12)
+
+	for _, sl := range slots {
+		parent, err := GetSlot(fakeCoordConn, productName, sl.Id%4)
+		c.Assert(err, IsNil)
+		c.Assert(sl.GroupId, Equals, parent.GroupId)
+		c.Assert(sl.State.Status, Equals, SLOT_STATUS_ONLINE)
+	}
+
+	// a key stays in the same group
+	for _, key := range []string{"a", "b", "foo", "bar"} {
+		parent := DefaultSlotConfig()
+		parent.Num = 4
+		c.Assert(cfg.HashSlot([]byte(key))%4, Equals, parent.HashSlot([]byte(key)))
+	}
+
+	fakeCoordConn.Close()
+	log.Info("[TestReshardSlots][end]")
+}
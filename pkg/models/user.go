@@ -0,0 +1,142 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package models
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/go-zookeeper/zk"
+	"github.com/ngaut/zkhelper"
+)
+
+// user command categories, a command flagged readonly, write or admin
+// requires the category of the same name
+const (
+	USER_CATEGORY_READ  = "read"
+	USER_CATEGORY_WRITE = "write"
+	USER_CATEGORY_ADMIN = "admin"
+)
+
+// User is a proxy user authenticated by AUTH user password. The password is
+// only used to set the user, the coordinator stores its sha256. The user can
+// access the keys with any of KeyPrefixes, or all keys if it is empty.
+type User struct {
+	Name           string   `json:"name"`
+	Password       string   `json:"password,omitempty"`
+	PasswordSHA256 string   `json:"password_sha256,omitempty"`
+	Categories     []string `json:"categories"`
+	KeyPrefixes    []string `json:"key_prefixes,omitempty"`
+}
+
+func (u *User) String() string {
+	if u == nil {
+		return "<nil>"
+	}
+
+	// no password in logs
+	return fmt.Sprintf("[User](name:%s, categories:%v, key_prefixes:%q)", u.Name, u.Categories, u.KeyPrefixes)
+}
+
+func (u *User) Validate() error {
+	if len(u.Name) == 0 || strings.ContainsAny(u.Name, " \t\r\n") {
+		return errors.NotValidf("user name %q", u.Name)
+	}
+
+	if len(u.Password) == 0 {
+		if b, err := hex.DecodeString(u.PasswordSHA256); err != nil || len(b) != sha256.Size {
+			return errors.NotValidf("user %s password", u.Name)
+		}
+	}
+
+	for _, category := range u.Categories {
+		switch category {
+		case USER_CATEGORY_READ, USER_CATEGORY_WRITE, USER_CATEGORY_ADMIN:
+		default:
+			return errors.NotValidf("user %s category %s", u.Name, category)
+		}
+	}
+
+	return nil
+}
+
+func HashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckPassword returns whether the password is the one of the user.
+func (u *User) CheckPassword(password string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashPassword(password)), []byte(strings.ToLower(u.PasswordSHA256))) == 1
+}
+
+func GetUsersPath(productName string) string {
+	return fmt.Sprintf("/zk/reborn/db_%s/users", productName)
+}
+
+// GetUsers returns the proxy users of the product, nil if not set.
+func GetUsers(coordConn zkhelper.Conn, productName string) ([]*User, error) {
+	data, _, err := coordConn.Get(GetUsersPath(productName))
+	if err != nil {
+		if zkhelper.ZkErrorEqual(err, zk.ErrNoNode) {
+			return nil, nil
+		}
+		return nil, errors.Trace(err)
+	}
+
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var users []*User
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return users, nil
+}
+
+// SetUsers replaces the proxy users of the product and notifies the proxies,
+// the passwords are hashed before stored. The action has no user, the
+// proxies read them from the coordinator.
+func SetUsers(coordConn zkhelper.Conn, productName string, users []*User) error {
+	names := make(map[string]bool, len(users))
+	for _, u := range users {
+		if err := u.Validate(); err != nil {
+			return errors.Trace(err)
+		}
+
+		if names[u.Name] {
+			return errors.AlreadyExistsf("user %s", u.Name)
+		}
+		names[u.Name] = true
+
+		if len(u.Password) > 0 {
+			u.PasswordSHA256 = HashPassword(u.Password)
+			u.Password = ""
+		}
+		u.PasswordSHA256 = strings.ToLower(u.PasswordSHA256)
+	}
+
+	if users == nil {
+		users = []*User{}
+	}
+
+	data, err := json.Marshal(users)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	_, err = zkhelper.CreateOrUpdate(coordConn, GetUsersPath(productName), string(data), 0, zkhelper.DefaultFileACLs(), true)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return errors.Trace(NewAction(coordConn, productName, ACTION_TYPE_USERS_CHANGED, nil, "", true))
+}
@@ -0,0 +1,54 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package models
+
+import (
+	"strings"
+
+	"github.com/ngaut/zkhelper"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testModelSuite) TestUsers(c *C) {
+	fakeCoordConn := zkhelper.NewConn()
+	defer fakeCoordConn.Close()
+
+	users, err := GetUsers(fakeCoordConn, productName)
+	c.Assert(err, IsNil)
+	c.Assert(users, IsNil)
+
+	err = SetUsers(fakeCoordConn, productName, []*User{
+		{Name: "reader", Password: "pass1", Categories: []string{USER_CATEGORY_READ}, KeyPrefixes: []string{"a:"}},
+		{Name: "writer", PasswordSHA256: strings.ToUpper(HashPassword("pass2")), Categories: []string{USER_CATEGORY_READ, USER_CATEGORY_WRITE}},
+	})
+	c.Assert(err, IsNil)
+
+	users, err = GetUsers(fakeCoordConn, productName)
+	c.Assert(err, IsNil)
+	c.Assert(users, HasLen, 2)
+
+	// only the hash is stored
+	c.Assert(users[0].Password, Equals, "")
+	c.Assert(users[0].CheckPassword("pass1"), Equals, true)
+	c.Assert(users[0].CheckPassword("pass2"), Equals, false)
+	c.Assert(users[1].CheckPassword("pass2"), Equals, true)
+	c.Assert(users[0].KeyPrefixes, DeepEquals, []string{"a:"})
+	c.Assert(strings.Contains(users[1].String(), users[1].PasswordSHA256), Equals, false)
+
+	err = SetUsers(fakeCoordConn, productName, []*User{{Name: "bad", Password: "p", Categories: []string{"bad"}}})
+	c.Assert(err, NotNil)
+
+	err = SetUsers(fakeCoordConn, productName, []*User{{Name: "nopass"}})
+	c.Assert(err, NotNil)
+
+	err = SetUsers(fakeCoordConn, productName, []*User{{Name: "dup", Password: "p"}, {Name: "dup", Password: "q"}})
+	c.Assert(err, NotNil)
+
+	err = SetUsers(fakeCoordConn, productName, nil)
+	c.Assert(err, IsNil)
+
+	users, err = GetUsers(fakeCoordConn, productName)
+	c.Assert(err, IsNil)
+	c.Assert(users, HasLen, 0)
+}
@@ -4,30 +4,99 @@
 package group
 
 import (
+	"sort"
+	"sync/atomic"
+
 	"github.com/ngaut/log"
 	"github.com/reborndb/reborn/pkg/models"
 )
 
+// read policies decide which server of a group serves read-only commands
+const (
+	ReadPolicyMaster      = "master"
+	ReadPolicyPreferSlave = "prefer_slave"
+	ReadPolicyRoundRobin  = "round_robin"
+	ReadPolicyNearest     = "nearest"
+)
+
+func ValidReadPolicy(policy string) bool {
+	switch policy {
+	case ReadPolicyMaster, ReadPolicyPreferSlave, ReadPolicyRoundRobin, ReadPolicyNearest:
+		return true
+	}
+
+	return false
+}
+
 type Group struct {
 	master       string
+	slaves       []string
 	redisServers map[string]*models.Server
+
+	next uint32 // round robin cursor for read-only requests
 }
 
 func (g *Group) Master() string {
 	return g.master
 }
 
+// GetServer returns the server of this group, nil if not found.
+func (g *Group) GetServer(addr string) *models.Server {
+	return g.redisServers[addr]
+}
+
+// Slaves returns the online slaves of this group, offline servers are never included.
+func (g *Group) Slaves() []string {
+	return g.slaves
+}
+
+// Reader picks a server to handle a read-only request with the given policy.
+// isLocal is used by the nearest policy to check whether a server is on the same host.
+// It always falls back to master if no slave can be used.
+func (g *Group) Reader(policy string, isLocal func(addr string) bool) string {
+	switch policy {
+	case ReadPolicyPreferSlave:
+		if len(g.slaves) == 0 {
+			return g.master
+		}
+		n := atomic.AddUint32(&g.next, 1)
+		return g.slaves[int(n)%len(g.slaves)]
+	case ReadPolicyRoundRobin:
+		n := atomic.AddUint32(&g.next, 1)
+		i := int(n) % (len(g.slaves) + 1)
+		if i == len(g.slaves) {
+			return g.master
+		}
+		return g.slaves[i]
+	case ReadPolicyNearest:
+		if isLocal == nil || isLocal(g.master) {
+			return g.master
+		}
+		for _, addr := range g.slaves {
+			if isLocal(addr) {
+				return addr
+			}
+		}
+		return g.master
+	}
+
+	return g.master
+}
+
 func NewGroup(groupInfo models.ServerGroup) *Group {
 	g := &Group{
 		redisServers: make(map[string]*models.Server),
 	}
 
 	for _, server := range groupInfo.Servers {
-		if server.Type == models.SERVER_TYPE_MASTER {
+		switch server.Type {
+		case models.SERVER_TYPE_MASTER:
 			if len(g.master) > 0 {
 				log.Fatalf("two masters are not allowed: %+v", groupInfo)
 			}
 			g.master = server.Addr
+		case models.SERVER_TYPE_SLAVE:
+			g.slaves = append(g.slaves, server.Addr)
 		}
 		g.redisServers[server.Addr] = server
 	}
@@ -36,5 +105,8 @@ func NewGroup(groupInfo models.ServerGroup) *Group {
 		log.Fatalf("master not found: %+v", groupInfo)
 	}
 
+	// keep a stable order for round robin
+	sort.Strings(g.slaves)
+
 	return g
 }
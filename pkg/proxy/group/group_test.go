@@ -0,0 +1,85 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package group
+
+import (
+	"testing"
+
+	"github.com/reborndb/reborn/pkg/models"
+	. "gopkg.in/check.v1"
+)
+
+func TestT(t *testing.T) {
+	TestingT(t)
+}
+
+type testGroupSuite struct {
+}
+
+var _ = Suite(&testGroupSuite{})
+
+func (s *testGroupSuite) testNewGroup(c *C) *Group {
+	g := NewGroup(models.ServerGroup{
+		Id: 1,
+		Servers: []*models.Server{
+			&models.Server{Type: models.SERVER_TYPE_SLAVE, GroupId: 1, Addr: "10.0.0.2:6379"},
+			&models.Server{Type: models.SERVER_TYPE_MASTER, GroupId: 1, Addr: "10.0.0.1:6379"},
+			&models.Server{Type: models.SERVER_TYPE_OFFLINE, GroupId: 1, Addr: "10.0.0.4:6379"},
+			&models.Server{Type: models.SERVER_TYPE_SLAVE, GroupId: 1, Addr: "10.0.0.3:6379"},
+		},
+	})
+
+	c.Assert(g.Master(), Equals, "10.0.0.1:6379")
+	c.Assert(g.Slaves(), DeepEquals, []string{"10.0.0.2:6379", "10.0.0.3:6379"})
+	return g
+}
+
+func (s *testGroupSuite) TestReaderMaster(c *C) {
+	g := s.testNewGroup(c)
+	for i := 0; i < 4; i++ {
+		c.Assert(g.Reader(ReadPolicyMaster, nil), Equals, g.Master())
+	}
+
+	// unknown policy always reads from master
+	c.Assert(g.Reader("", nil), Equals, g.Master())
+}
+
+func (s *testGroupSuite) TestReaderPreferSlave(c *C) {
+	g := s.testNewGroup(c)
+	m := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		m[g.Reader(ReadPolicyPreferSlave, nil)]++
+	}
+	c.Assert(m, DeepEquals, map[string]int{"10.0.0.2:6379": 2, "10.0.0.3:6379": 2})
+
+	// no slaves, fallback to master
+	g = NewGroup(models.ServerGroup{
+		Id:      2,
+		Servers: []*models.Server{&models.Server{Type: models.SERVER_TYPE_MASTER, Addr: "10.0.0.1:6379"}},
+	})
+	c.Assert(g.Reader(ReadPolicyPreferSlave, nil), Equals, "10.0.0.1:6379")
+}
+
+func (s *testGroupSuite) TestReaderRoundRobin(c *C) {
+	g := s.testNewGroup(c)
+	m := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		m[g.Reader(ReadPolicyRoundRobin, nil)]++
+	}
+	c.Assert(m, DeepEquals, map[string]int{"10.0.0.1:6379": 2, "10.0.0.2:6379": 2, "10.0.0.3:6379": 2})
+}
+
+func (s *testGroupSuite) TestReaderNearest(c *C) {
+	g := s.testNewGroup(c)
+	isLocal := func(addr string) bool {
+		return addr == "10.0.0.3:6379"
+	}
+	c.Assert(g.Reader(ReadPolicyNearest, isLocal), Equals, "10.0.0.3:6379")
+
+	// the offline server must never be used
+	isLocal = func(addr string) bool {
+		return addr == "10.0.0.4:6379"
+	}
+	c.Assert(g.Reader(ReadPolicyNearest, isLocal), Equals, g.Master())
+}
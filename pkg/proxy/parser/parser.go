@@ -42,22 +42,9 @@ type Resp struct {
 	Multi []*Resp
 }
 
-var (
-	noKeyOps = map[string]string{
-		"PING":       "fakeKey",
-		"SLOTSNUM":   "fakeKey",
-		"SLOTSCHECK": "fakeKey",
-	}
-
-	keyFun       = make(map[string]funGetKeys)
-	mappingTable [][]byte
-)
+var mappingTable [][]byte
 
 func init() {
-	for _, v := range thridAsKeyTbl {
-		keyFun[v] = thridAsKey
-	}
-
 	mappingTable = make([][]byte, MappingTableNum)
 	for i := 0; i < MappingTableNum; i++ {
 		mappingTable[i] = []byte(strconv.Itoa(i))
@@ -136,6 +123,14 @@ func raw2Error(r *Resp) []byte {
 	return r.Raw[1 : len(r.Raw)-2] //skip type &&  \r\n
 }
 
+// Value returns the content of the response without type prefix and \r\n,
+// for bulk string, it is the string itself.
+func (r *Resp) Value() []byte {
+	return raw2Bulk(r)
+}
+
+// GetOpKeys returns the command name and all the arguments after it,
+// which of them are keys is decided by the command table of router.
 func (r *Resp) GetOpKeys() (op []byte, keys [][]byte, err error) {
 	if len(r.Multi) > 0 {
 		op = raw2Bulk(r.Multi[0])
@@ -144,31 +139,17 @@ func (r *Resp) GetOpKeys() (op []byte, keys [][]byte, err error) {
 		}
 	}
 
-	f, ok := keyFun[string(op)]
-	if !ok {
-		keys, err = defaultGetKeys(r)
-		return op, keys, errors.Trace(err)
+	count := len(r.Multi) - 1
+	if count <= 0 {
+		return op, nil, nil
 	}
 
-	keys, err = f(r)
-	return op, keys, errors.Trace(err)
-}
-
-type funGetKeys func(r *Resp) ([][]byte, error)
-
-func defaultGetKeys(r *Resp) ([][]byte, error) {
-	count := len(r.Multi[1:])
-	if count == 0 {
-		return nil, nil
-	}
-
-	keys := make([][]byte, 0, count)
+	keys = make([][]byte, 0, count)
 	for _, v := range r.Multi[1:] {
-		key := raw2Bulk(v)
-		keys = append(keys, key)
+		keys = append(keys, raw2Bulk(v))
 	}
 
-	return keys, nil
+	return op, keys, nil
 }
 
 func Parse(r *bufio.Reader) (*Resp, error) {
@@ -289,29 +270,6 @@ func ReadBulk(r *bufio.Reader, size int, raw *[]byte) error {
 	return nil
 }
 
-var thridAsKeyTbl = []string{"ZINTERSTORE", "ZUNIONSTORE", "EVAL", "EVALSHA"}
-
-func thridAsKey(r *Resp) ([][]byte, error) {
-	if len(r.Multi) < 4 { //if EVAL with no key
-		return [][]byte{[]byte("fakeKey")}, nil
-	}
-
-	numKeys, err := Btoi(raw2Bulk(r.Multi[2]))
-	if err != nil {
-		return nil, errors.Trace(err)
-	}
-
-	var keys [][]byte
-	for _, v := range r.Multi[3:] {
-		keys = append(keys, raw2Bulk(v))
-		if len(keys) == numKeys {
-			break
-		}
-	}
-
-	return keys, nil
-}
-
 func (r *Resp) WriteTo(w io.Writer) error {
 	switch r.Type {
 	case NoKey:
@@ -153,7 +153,8 @@ func (s *testProxyParserSuite) TestEval(c *C) {
 		c.Assert(err, IsNil)
 		c.Assert(string(op), Equals, "EVAL")
 		c.Assert(len(resp.Multi), Equals, 3)
-		c.Assert(len(keys), Equals, 1)
+		c.Assert(len(keys), Equals, 2)
+		c.Assert(string(keys[1]), Equals, "0")
 
 		_, err = resp.Bytes()
 		c.Assert(err, IsNil)
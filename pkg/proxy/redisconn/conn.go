@@ -20,7 +20,8 @@ type Conn struct {
 	closed     bool
 	r          *bufio.Reader
 	w          *bufio.Writer
-	netTimeout int //second
+	netTimeout int   //second
+	pool       *Pool // the pool it is got from, nil if not pooled
 }
 
 func NewConnection(addr string, netTimeout int) (*Conn, error) {
@@ -28,7 +29,18 @@ func NewConnection(addr string, netTimeout int) (*Conn, error) {
 }
 
 func NewConnectionWithSize(addr string, netTimeout int, readSize int, writeSize int) (*Conn, error) {
-	conn, err := net.DialTimeout("tcp", addr, time.Duration(netTimeout)*time.Second)
+	return NewConnectionWithDial(addr, netTimeout, readSize, writeSize, func(addr string, timeout time.Duration) (net.Conn, error) {
+		return net.DialTimeout("tcp", addr, timeout)
+	})
+}
+
+// DialFunc connects to the server, like net.DialTimeout of tcp.
+type DialFunc func(addr string, timeout time.Duration) (net.Conn, error)
+
+// NewConnectionWithDial creates the connection with the dial, the dial may
+// return a tls connection.
+func NewConnectionWithDial(addr string, netTimeout int, readSize int, writeSize int, dial DialFunc) (*Conn, error) {
+	conn, err := dial(addr, time.Duration(netTimeout)*time.Second)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -38,7 +38,9 @@ func (p *Pool) GetConn() (*Conn, error) {
 	if err != nil {
 		return nil, errors.Trace(err)
 	} else {
-		return conn.(*Conn), nil
+		c := conn.(*Conn)
+		c.pool = p
+		return c, nil
 	}
 }
 
@@ -97,11 +99,32 @@ func (p *Pools) PutConn(c *Conn) {
 	p.m.Unlock()
 	if !ok {
 		c.Close()
+		// the pool is closed by ClosePool, it waits for the connection
+		if c.pool != nil {
+			c.pool.PutConn(c)
+		}
+	} else if c.pool != nil && c.pool != pool {
+		c.Close()
+		c.pool.PutConn(c)
 	} else {
 		pool.PutConn(c)
 	}
 }
 
+// ClosePool closes the pool of the addr in background, the connections in
+// use are closed when they are put back, a new pool is created for the next
+// use.
+func (p *Pools) ClosePool(addr string) {
+	p.m.Lock()
+	pool, ok := p.mpools[addr]
+	delete(p.mpools, addr)
+	p.m.Unlock()
+
+	if ok {
+		go pool.Close()
+	}
+}
+
 func (p *Pools) Close() {
 	p.m.Lock()
 	defer p.m.Unlock()
@@ -85,3 +85,40 @@ func (s *testPoolSuite) TestPool(c *C) {
 	_, err = p.GetConn()
 	c.Assert(err, NotNil)
 }
+
+func (s *testPoolSuite) TestPoolsClosePool(c *C) {
+	count := 0
+	f := func(addr string) (*Conn, error) {
+		count++
+		return &Conn{addr: addr, closed: false, nc: &testDummyConn{}}, nil
+	}
+
+	addr := "127.0.0.1:6379"
+	p := NewPools(2, f)
+
+	conn1, err := p.GetConn(addr)
+	c.Assert(err, IsNil)
+	old := conn1.pool
+
+	// the connection in use is closed when it is put back
+	p.ClosePool(addr)
+
+	conn2, err := p.GetConn(addr)
+	c.Assert(err, IsNil)
+	c.Assert(conn2.pool, Not(Equals), old)
+	c.Assert(count, Equals, 2)
+
+	p.PutConn(conn1)
+	c.Assert(conn1.closed, Equals, true)
+
+	p.PutConn(conn2)
+	c.Assert(conn2.closed, Equals, false)
+
+	// the old pool is closed after all connections are put back
+	for i := 0; i < 50 && !old.p.IsClosed(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(old.p.IsClosed(), Equals, true)
+
+	p.Close()
+}
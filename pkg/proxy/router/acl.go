@@ -0,0 +1,174 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	"github.com/reborndb/reborn/pkg/models"
+)
+
+// aclUser is a proxy user, categories are the command flags it can run.
+type aclUser struct {
+	*models.User
+	categories int
+	prefixes   [][]byte // all keys if empty
+}
+
+var userCategoryFlags = map[string]int{
+	models.USER_CATEGORY_READ:  cmdReadOnly,
+	models.USER_CATEGORY_WRITE: cmdWrite,
+	models.USER_CATEGORY_ADMIN: cmdAdmin,
+}
+
+func newACLUser(u *models.User) *aclUser {
+	user := &aclUser{User: u}
+	for _, category := range u.Categories {
+		user.categories |= userCategoryFlags[category]
+	}
+
+	for _, prefix := range u.KeyPrefixes {
+		user.prefixes = append(user.prefixes, []byte(prefix))
+	}
+
+	return user
+}
+
+// canRun returns whether the user has all the categories of the command,
+// the commands unknown by proxy require write.
+func (u *aclUser) canRun(cmd *commandInfo) bool {
+	need := cmd.flags & (cmdReadOnly | cmdWrite | cmdAdmin)
+	if cmd.arity == 0 {
+		need |= cmdWrite
+	}
+
+	return need&^u.categories == 0
+}
+
+func (u *aclUser) canAccess(key []byte) bool {
+	if len(u.prefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range u.prefixes {
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// canAccessIndirect returns whether the user can run the command which may
+// access the keys not in its arguments. For the users with key prefixes,
+// RANDOMKEY, FLUSHALL, FLUSHDB and scripts are denied, the BY and GET patterns
+// of SORT must have the prefixes, SCAN is filtered by the prefixes instead.
+func (u *aclUser) canAccessIndirect(cmd *commandInfo, args [][]byte) bool {
+	if len(u.prefixes) == 0 {
+		return true
+	}
+
+	switch cmd.name {
+	case "RANDOMKEY", "FLUSHALL", "FLUSHDB", "EVAL", "EVALSHA", "SCRIPT":
+		return false
+	case "SORT":
+		for i := 1; i < len(args)-1; i++ {
+			opt := strings.ToUpper(string(args[i]))
+			if opt != "BY" && opt != "GET" {
+				continue
+			}
+
+			// a pattern without * accesses no key, e.g. GET # or BY nosort
+			i++
+			if bytes.IndexByte(args[i], '*') >= 0 && !u.canAccess(args[i]) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// loadUsers applies the proxy users of the product in coordinator, the
+// sessions check the commands with the new ones at once.
+func (s *Server) loadUsers() {
+	list, err := s.top.GetUsers()
+	if err != nil {
+		log.Warningf("get proxy users failed, %v", errors.ErrorStack(err))
+		return
+	}
+
+	users := make(map[string]*aclUser, len(list))
+	for _, u := range list {
+		if err = u.Validate(); err != nil {
+			log.Warningf("invalid proxy users, %v", errors.ErrorStack(err))
+			return
+		}
+		users[u.Name] = newACLUser(u)
+	}
+
+	log.Infof("load %d proxy users", len(users))
+
+	s.userMutex.Lock()
+	s.users = users
+	s.userMutex.Unlock()
+}
+
+func (s *Server) getUser(name string) *aclUser {
+	s.userMutex.RLock()
+	u := s.users[name]
+	s.userMutex.RUnlock()
+	return u
+}
+
+// authRequired returns whether the clients must AUTH before any command.
+func (s *Server) authRequired() bool {
+	s.userMutex.RLock()
+	n := len(s.users)
+	s.userMutex.RUnlock()
+
+	return len(s.conf.ProxyAuth) > 0 || n > 0
+}
+
+// checkACL returns the error reply if the user of the session cannot run
+// the command, nil if allowed. A session of a removed user needs to AUTH
+// again.
+func (s *Server) checkACL(c *session, cmd *commandInfo, args [][]byte) []byte {
+	if len(c.user) == 0 {
+		return nil
+	}
+
+	u := s.getUser(c.user)
+	if u == nil {
+		c.authenticated = false
+		c.user = ""
+		return []byte("-ERR NOAUTH Authentication required\r\n")
+	}
+
+	if !u.canRun(cmd) {
+		s.counter.Add("NoPerm", 1)
+		return []byte("-NOPERM this user has no permissions to run the '" + strings.ToLower(cmd.name) + "' command\r\n")
+	}
+
+	if len(u.prefixes) == 0 {
+		return nil
+	}
+
+	for _, key := range cmd.keys(args) {
+		if !u.canAccess(key) {
+			s.counter.Add("NoPerm", 1)
+			return []byte("-NOPERM this user has no permissions to access one of the keys used as arguments\r\n")
+		}
+	}
+
+	if !u.canAccessIndirect(cmd, args) {
+		s.counter.Add("NoPerm", 1)
+		return []byte("-NOPERM this user has no permissions to access the keys not in the arguments of the '" + strings.ToLower(cmd.name) + "' command\r\n")
+	}
+
+	return nil
+}
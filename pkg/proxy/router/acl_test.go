@@ -0,0 +1,166 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"github.com/garyburd/redigo/redis"
+	stats "github.com/ngaut/gostats"
+	"github.com/reborndb/reborn/pkg/models"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testProxyRouterSuite) TestAuthEmptyPassword(c *C) {
+	srv := &Server{
+		conf:    &Conf{},
+		counter: stats.NewCounters(""),
+		users:   map[string]*aclUser{"reader": newACLUser(&models.User{Name: "reader"})},
+	}
+	c.Assert(srv.authRequired(), Equals, true)
+
+	// only the users are set, AUTH "" must not pass as the proxy auth
+	sess := &session{}
+	_, err := srv.handleAuthCommand(sess, [][]byte{[]byte("")})
+	c.Assert(err, NotNil)
+	c.Assert(sess.authenticated, Equals, false)
+
+	srv.conf.ProxyAuth = "123"
+	buf, err := srv.handleAuthCommand(sess, [][]byte{[]byte("123")})
+	c.Assert(err, IsNil)
+	c.Assert(buf, DeepEquals, OK_BYTES)
+	c.Assert(sess.authenticated, Equals, true)
+}
+
+func (s *testProxyRouterSuite) TestACLIndirectKeys(c *C) {
+	u := newACLUser(&models.User{Name: "reader", KeyPrefixes: []string{"team1:"}})
+	sort := defaultCommands.lookup("SORT")
+
+	c.Assert(u.canAccessIndirect(defaultCommands.lookup("GET"), testBytesSlice("team1:a")), Equals, true)
+	c.Assert(u.canAccessIndirect(defaultCommands.lookup("RANDOMKEY"), nil), Equals, false)
+	c.Assert(u.canAccessIndirect(defaultCommands.lookup("EVAL"), testBytesSlice("return 1", "0")), Equals, false)
+	c.Assert(u.canAccessIndirect(defaultCommands.lookup("FLUSHALL"), nil), Equals, false)
+	c.Assert(u.canAccessIndirect(defaultCommands.lookup("FLUSHDB"), nil), Equals, false)
+	c.Assert(u.canAccessIndirect(sort, testBytesSlice("team1:l", "BY", "team1:w_*", "GET", "#", "GET", "team1:o_*->f")), Equals, true)
+	c.Assert(u.canAccessIndirect(sort, testBytesSlice("team1:l", "by", "nosort", "LIMIT", "0", "10")), Equals, true)
+	c.Assert(u.canAccessIndirect(sort, testBytesSlice("team1:l", "BY", "team2:w_*")), Equals, false)
+	c.Assert(u.canAccessIndirect(sort, testBytesSlice("team1:l", "get", "*")), Equals, false)
+
+	// no key prefix, all keys can be accessed
+	all := newACLUser(&models.User{Name: "all"})
+	c.Assert(all.canAccessIndirect(defaultCommands.lookup("RANDOMKEY"), nil), Equals, true)
+	c.Assert(all.canAccessIndirect(defaultCommands.lookup("FLUSHALL"), nil), Equals, true)
+
+	// PUBLISH requires write
+	reader := newACLUser(&models.User{Name: "reader", Categories: []string{models.USER_CATEGORY_READ}})
+	c.Assert(reader.canRun(defaultCommands.lookup("PUBLISH")), Equals, false)
+	c.Assert(reader.canRun(defaultCommands.lookup("SUBSCRIBE")), Equals, true)
+
+	// SCAN returns the keys with the prefixes only
+	keys := testBytesSlice("team1:a", "team2:b", "team1:c")
+	t := &scanTopo{owners: make([]int, slotNum()), from: make([]int, slotNum())}
+	c.Assert(t.filterKeys(0, keys, u), DeepEquals, []interface{}{keys[0], keys[2]})
+	c.Assert(t.filterKeys(0, keys, nil), DeepEquals, []interface{}{keys[0], keys[1], keys[2]})
+	c.Assert(t.filterKeys(1, keys, nil), HasLen, 0)
+}
+
+func (s *testProxyRouterSuite) TestACLUsers(c *C) {
+	err := models.SetUsers(conn, conf.ProductName, []*models.User{
+		{Name: "reader", Password: "r", Categories: []string{models.USER_CATEGORY_READ}, KeyPrefixes: []string{"team1:"}},
+		{Name: "writer", Password: "w", Categories: []string{models.USER_CATEGORY_READ, models.USER_CATEGORY_WRITE}},
+	})
+	c.Assert(err, IsNil)
+	defer func() {
+		err := models.SetUsers(conn, conf.ProductName, nil)
+		c.Assert(err, IsNil)
+
+		s.s1.store.Reset()
+		s.s2.store.Reset()
+	}()
+
+	cc, err := redis.Dial("tcp", proxyAddr)
+	c.Assert(err, IsNil)
+	defer cc.Close()
+
+	// the users require AUTH even without the proxy auth
+	_, err = cc.Do("GET", "team1:a")
+	c.Assert(err, ErrorMatches, "ERR NOAUTH Authentication required")
+
+	cc, err = redis.Dial("tcp", proxyAddr)
+	c.Assert(err, IsNil)
+	defer cc.Close()
+
+	_, err = cc.Do("AUTH", "reader", "bad")
+	c.Assert(err, ErrorMatches, "ERR invalid auth")
+
+	cc, err = redis.Dial("tcp", proxyAddr)
+	c.Assert(err, IsNil)
+	defer cc.Close()
+
+	ok, err := redis.String(cc.Do("AUTH", "writer", "w"))
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, "OK")
+
+	_, err = cc.Do("MSET", "team1:a", "1", "team2:b", "2")
+	c.Assert(err, IsNil)
+
+	_, err = cc.Do("FLUSHALL")
+	c.Assert(err, ErrorMatches, "NOPERM .*'flushall' command")
+
+	_, err = cc.Do("SLOWLOG", "LEN")
+	c.Assert(err, ErrorMatches, "NOPERM .*")
+
+	rc, err := redis.Dial("tcp", proxyAddr)
+	c.Assert(err, IsNil)
+	defer rc.Close()
+
+	_, err = rc.Do("AUTH", "reader", "r")
+	c.Assert(err, IsNil)
+
+	v, err := redis.String(rc.Do("GET", "team1:a"))
+	c.Assert(err, IsNil)
+	c.Assert(v, Equals, "1")
+
+	_, err = rc.Do("GET", "team2:b")
+	c.Assert(err, ErrorMatches, "NOPERM .*keys.*")
+
+	_, err = rc.Do("MGET", "team1:a", "team2:b")
+	c.Assert(err, ErrorMatches, "NOPERM .*keys.*")
+
+	_, err = rc.Do("SET", "team1:a", "2")
+	c.Assert(err, ErrorMatches, "NOPERM .*'set' command")
+
+	// keys of other teams can not be found by keyless commands
+	_, err = rc.Do("RANDOMKEY")
+	c.Assert(err, ErrorMatches, "NOPERM .*'randomkey' command")
+
+	// the queued commands are checked too
+	_, err = rc.Do("MULTI")
+	c.Assert(err, IsNil)
+	_, err = rc.Do("DEL", "team1:a")
+	c.Assert(err, ErrorMatches, "NOPERM .*")
+	_, err = rc.Do("DISCARD")
+	c.Assert(err, IsNil)
+
+	// the changes apply to the authenticated sessions
+	err = models.SetUsers(conn, conf.ProductName, []*models.User{
+		{Name: "reader", Password: "r", Categories: []string{models.USER_CATEGORY_READ, models.USER_CATEGORY_WRITE}, KeyPrefixes: []string{"team1:"}},
+	})
+	c.Assert(err, IsNil)
+
+	_, err = rc.Do("SET", "team1:a", "2")
+	c.Assert(err, IsNil)
+
+	_, err = rc.Do("SORT", "team1:l", "BY", "team2:*")
+	c.Assert(err, ErrorMatches, "NOPERM .*access the keys not in the arguments.*")
+
+	_, err = cc.Do("GET", "team1:a")
+	c.Assert(err, ErrorMatches, "ERR NOAUTH Authentication required")
+
+	// the proxy auth still works
+	pc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer pc.Close()
+
+	v, err = redis.String(pc.Do("GET", "team2:b"))
+	c.Assert(err, IsNil)
+	c.Assert(v, Equals, "2")
+}
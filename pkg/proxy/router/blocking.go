@@ -0,0 +1,178 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/reborndb/reborn/pkg/proxy/parser"
+	"github.com/reborndb/reborn/pkg/proxy/redisconn"
+)
+
+const BlockingPoolCapability = 1024
+
+var (
+	errBlockingCanceled = errors.New("blocking command canceled, client closed")
+)
+
+// parseBlockingArgs returns the keys and the timeout of a blocking command,
+// args are all the arguments after the command name.
+// If the arguments are invalid, an error reply is returned.
+func parseBlockingArgs(op string, args [][]byte) ([][]byte, time.Duration, []byte) {
+	if len(args) < 2 || (op == "BRPOPLPUSH" && len(args) != 3) {
+		return nil, 0, []byte("-ERR wrong number of arguments for '" + strings.ToLower(op) + "' command\r\n")
+	}
+
+	n, err := strconv.ParseFloat(string(args[len(args)-1]), 64)
+	if err != nil {
+		return nil, 0, []byte("-ERR timeout is not a float or out of range\r\n")
+	}
+	if n < 0 {
+		return nil, 0, []byte("-ERR timeout is negative\r\n")
+	}
+
+	return args[:len(args)-1], time.Duration(n * float64(time.Second)), nil
+}
+
+// handleBlockingCommand sends a blocking command to the backend server with a
+// dedicated connection, so other clients sharing the task runner are not stalled.
+func (s *Server) handleBlockingCommand(c *session, opstr string, op []byte, keys [][]byte, resp *parser.Resp) error {
+	bkeys, timeout, errBuf := parseBlockingArgs(opstr, keys)
+	if errBuf != nil {
+		s.sendBack(c, op, keys, resp, errBuf)
+		return nil
+	}
+
+	if !isTheSameSlot(bkeys) {
+		s.sendBack(c, op, keys, resp, CROSSSLOT_BYTES)
+		return nil
+	}
+
+	cancel := make(chan struct{})
+
+	c.pipelineSeq++
+	pr := &PipelineRequest{
+		slotIdx: mapKey2Slot(bkeys[0]),
+		op:      op,
+		keys:    bkeys,
+		seq:     c.pipelineSeq,
+		backQ:   c.backQ,
+		req:     resp,
+		wg:      &sync.WaitGroup{},
+	}
+	pr.direct = func(addr string) {
+		s.doBlocking(pr, addr, timeout, cancel)
+	}
+	pr.wg.Add(1)
+
+	s.counter.Add("BlockedClients", 1)
+	defer s.counter.Add("BlockedClients", -1)
+
+	c.setBlocked(true)
+	defer c.setBlocked(false)
+
+	s.sendRequest(pr)
+	s.waitBlocking(c, pr, cancel)
+
+	return nil
+}
+
+// waitBlocking waits for the blocking request to finish, and watches the client
+// connection at the same time, the request is canceled if the client is closed.
+func (s *Server) waitBlocking(c *session, pr *PipelineRequest, cancel chan struct{}) {
+	done := make(chan struct{})
+	go func() {
+		pr.wg.Wait()
+		close(done)
+	}()
+
+	// the idle timeout does not apply to the blocked client
+	c.SetReadDeadline(time.Time{})
+
+	closed := make(chan error, 1)
+	go func() {
+		// the next request is kept in the buffer if the client sends one
+		_, err := c.r.Peek(1)
+		closed <- err
+	}()
+
+	select {
+	case <-done:
+		// interrupt the watching goroutine, then the reader can be used again
+		c.SetReadDeadline(time.Now())
+		<-closed
+		c.SetReadDeadline(time.Time{})
+	case err := <-closed:
+		if err != nil {
+			close(cancel)
+		}
+		<-done
+	}
+}
+
+func (s *Server) doBlocking(pr *PipelineRequest, addr string, timeout time.Duration, cancel chan struct{}) {
+	conn, err := s.blockPools.GetConn(addr)
+	if err != nil {
+		pr.backQ <- &PipelineResponse{ctx: pr, err: errors.Trace(err)}
+		return
+	}
+
+	resp, err := s.doBlockingRoundTrip(conn, pr.req, timeout, cancel)
+	if err != nil {
+		conn.Close()
+	}
+	s.blockPools.PutConn(conn)
+
+	pr.backQ <- &PipelineResponse{ctx: pr, resp: resp, err: err}
+}
+
+func (s *Server) doBlockingRoundTrip(conn *redisconn.Conn, req *parser.Resp, timeout time.Duration, cancel chan struct{}) (*parser.Resp, error) {
+	if err := req.WriteTo(conn); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err := conn.Flush(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// timeout 0 blocks forever
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout + time.Duration(s.conf.NetTimeout)*time.Second)
+	}
+
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	canceled := make(chan struct{})
+	go func() {
+		select {
+		case <-cancel:
+			// wake up the reader below
+			close(canceled)
+			conn.SetReadDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	resp, err := parser.Parse(conn.BufioReader())
+	if err != nil {
+		select {
+		case <-canceled:
+			return nil, errors.Trace(errBlockingCanceled)
+		default:
+		}
+		return nil, errors.Trace(err)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/reborndb/reborn/pkg/proxy/parser"
+	"github.com/reborndb/reborn/pkg/proxy/redisconn"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *testProxyRouterSuite) TestParseBlockingArgs(c *C) {
+	keys, timeout, errBuf := parseBlockingArgs("BLPOP", [][]byte{[]byte("k1"), []byte("k2"), []byte("1.5")})
+	c.Assert(errBuf, IsNil)
+	c.Assert(keys, DeepEquals, [][]byte{[]byte("k1"), []byte("k2")})
+	c.Assert(timeout, Equals, 1500*time.Millisecond)
+
+	_, _, errBuf = parseBlockingArgs("BLPOP", [][]byte{[]byte("k1")})
+	c.Assert(string(errBuf), Matches, "-ERR wrong number of arguments.*\r\n")
+
+	_, _, errBuf = parseBlockingArgs("BRPOPLPUSH", [][]byte{[]byte("k1"), []byte("k2"), []byte("k3"), []byte("0")})
+	c.Assert(string(errBuf), Matches, "-ERR wrong number of arguments.*\r\n")
+
+	_, _, errBuf = parseBlockingArgs("BRPOP", [][]byte{[]byte("k1"), []byte("abc")})
+	c.Assert(string(errBuf), Matches, "-ERR timeout is not a float.*\r\n")
+
+	_, _, errBuf = parseBlockingArgs("BRPOP", [][]byte{[]byte("k1"), []byte("-1")})
+	c.Assert(string(errBuf), Matches, "-ERR timeout is negative\r\n")
+}
+
+func (s *testProxyRouterSuite) TestBlockingCommands(c *C) {
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	keys := s.testGenKeysInSlot(c, (mapKey2Slot([]byte("foo"))+1)%1024, 1)
+	_, err := cc.Do("BLPOP", "foo", keys[0], 1)
+	c.Assert(err, ErrorMatches, "CROSSSLOT.*")
+
+	_, err = cc.Do("BRPOPLPUSH", "foo", keys[0], 1)
+	c.Assert(err, ErrorMatches, "CROSSSLOT.*")
+
+	_, err = cc.Do("BLPOP", "foo", -1)
+	c.Assert(err, ErrorMatches, "ERR timeout is negative")
+
+	// connection still works
+	_, err = cc.Do("SET", "foo", "bar")
+	c.Assert(err, IsNil)
+}
+
+func (s *testProxyRouterSuite) TestBlockingCancel(c *C) {
+	// a backend which never replies
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	conn, err := redisconn.NewConnection(l.Addr().String(), 1)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	req, err := parser.Parse(bufio.NewReader(bytes.NewBufferString("*3\r\n$5\r\nBLPOP\r\n$3\r\nfoo\r\n$1\r\n0\r\n")))
+	c.Assert(err, IsNil)
+
+	cancel := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(cancel) })
+
+	ss := &Server{conf: &Conf{NetTimeout: 1}}
+	_, err = ss.doBlockingRoundTrip(conn, req, 0, cancel)
+	c.Assert(errors.Cause(err), Equals, errBlockingCanceled)
+}
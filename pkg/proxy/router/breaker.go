@@ -0,0 +1,161 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	stats "github.com/ngaut/gostats"
+	"github.com/ngaut/log"
+)
+
+const (
+	BreakerMaxFailures   = 3               // consecutive failures to open the breaker
+	BreakerRetryInterval = 1 * time.Second // interval of probing an open backend
+)
+
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker fails the requests of a backend fast after it failed
+// several times in a row, a request is let through every retry interval
+// to probe the backend, and the breaker is closed once a probe succeeds or
+// a task runner reconnected to the backend.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    int
+	failures int
+	probeAt  time.Time // the latest request let through while not closed
+
+	name          string
+	counter       *stats.Counters
+	maxFailures   int
+	retryInterval time.Duration
+}
+
+func newCircuitBreaker(name string, counter *stats.Counters) *circuitBreaker {
+	return &circuitBreaker{
+		name:          name,
+		counter:       counter,
+		maxFailures:   BreakerMaxFailures,
+		retryInterval: BreakerRetryInterval,
+	}
+}
+
+func (b *circuitBreaker) setState(state int) {
+	b.state = state
+	b.counter.Set(b.name+".breaker", int64(state))
+}
+
+// allow returns whether a request can be sent to the backend.
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerClosed {
+		return true
+	}
+
+	if time.Since(b.probeAt) < b.retryInterval {
+		return false
+	}
+
+	b.probeAt = time.Now()
+	if b.state == breakerOpen {
+		log.Infof("probe backend %s", b.name)
+		b.setState(breakerHalfOpen)
+	}
+
+	return true
+}
+
+func (b *circuitBreaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	if b.state != breakerClosed {
+		log.Warningf("backend %s recovered, close breaker", b.name)
+		b.setState(breakerClosed)
+	}
+}
+
+// reconnected closes the breaker after a task runner of the backend
+// reconnected, the failures are kept if it is closed, so a backend which
+// accepts connections but fails the requests still opens it.
+func (b *circuitBreaker) reconnected() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != breakerClosed {
+		log.Warningf("backend %s reconnected, close breaker", b.name)
+		b.failures = 0
+		b.setState(breakerClosed)
+	}
+}
+
+func (b *circuitBreaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	switch b.state {
+	case breakerClosed:
+		if b.failures < b.maxFailures {
+			return
+		}
+	case breakerOpen:
+		return
+	}
+
+	log.Warningf("backend %s failed %d times, open breaker", b.name, b.failures)
+	b.probeAt = time.Now()
+	b.setState(breakerOpen)
+	b.counter.Add(b.name+".breaker_opened", 1)
+}
+
+// getBreaker returns the circuit breaker of the backend, creates it if not
+// exists.
+func (s *Server) getBreaker(addr string) *circuitBreaker {
+	s.breakerMutex.Lock()
+	defer s.breakerMutex.Unlock()
+
+	b, ok := s.breakers[addr]
+	if !ok {
+		b = newCircuitBreaker(addr, s.backendCounter)
+		s.breakers[addr] = b
+	}
+
+	return b
+}
+
+// removeBreakers removes the circuit breakers of the backends not used any
+// more, it is called after their task runners are stopped.
+func (s *Server) removeBreakers(used map[string]bool) {
+	s.breakerMutex.Lock()
+	defer s.breakerMutex.Unlock()
+
+	for addr := range s.breakers {
+		if !used[addr] {
+			delete(s.breakers, addr)
+		}
+	}
+}
+
+// sendToBackend sends the request to the task runner i of the backend, it
+// fails at once if the breaker of the backend is open.
+func (s *Server) sendToBackend(addr string, i int, r *PipelineRequest) error {
+	b := s.getBreaker(addr)
+	if !b.allow() {
+		s.counter.Add("BreakerRejected", 1)
+		return errors.Errorf("backend %s is down", addr)
+	}
+
+	return s.sendToTaskRunner(addr, i, r)
+}
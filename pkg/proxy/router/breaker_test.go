@@ -0,0 +1,66 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"time"
+
+	stats "github.com/ngaut/gostats"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testProxyRouterSuite) TestCircuitBreaker(c *C) {
+	counter := stats.NewCounters("")
+	b := newCircuitBreaker("127.0.0.1:6379", counter)
+	b.retryInterval = 100 * time.Millisecond
+
+	// a success resets the failures
+	b.failure()
+	b.failure()
+	b.success()
+	b.failure()
+	c.Assert(b.allow(), Equals, true)
+
+	b.failure()
+	b.failure()
+	c.Assert(b.allow(), Equals, false)
+	c.Assert(counter.Counts()["127.0.0.1:6379.breaker"], Equals, int64(breakerOpen))
+	c.Assert(counter.Counts()["127.0.0.1:6379.breaker_opened"], Equals, int64(1))
+
+	// only one probe every retry interval
+	time.Sleep(b.retryInterval)
+	c.Assert(b.allow(), Equals, true)
+	c.Assert(b.allow(), Equals, false)
+
+	// the failed probe opens it again
+	b.failure()
+	c.Assert(b.allow(), Equals, false)
+	c.Assert(counter.Counts()["127.0.0.1:6379.breaker_opened"], Equals, int64(2))
+
+	time.Sleep(b.retryInterval)
+	c.Assert(b.allow(), Equals, true)
+	b.success()
+	c.Assert(b.allow(), Equals, true)
+	c.Assert(b.allow(), Equals, true)
+	c.Assert(counter.Counts()["127.0.0.1:6379.breaker"], Equals, int64(breakerClosed))
+
+	// a reconnected task runner closes the open breaker at once
+	for i := 0; i < BreakerMaxFailures; i++ {
+		b.failure()
+	}
+	c.Assert(b.allow(), Equals, false)
+	b.reconnected()
+	c.Assert(b.allow(), Equals, true)
+}
+
+func (s *testProxyRouterSuite) TestRemoveBreakers(c *C) {
+	srv := &Server{breakers: make(map[string]*circuitBreaker), backendCounter: stats.NewCounters("")}
+	b := srv.getBreaker("127.0.0.1:6379")
+	srv.getBreaker("127.0.0.1:6380")
+
+	// the breaker of the backend left is removed, the one in use is kept
+	srv.removeBreakers(map[string]bool{"127.0.0.1:6379": true})
+	c.Assert(srv.breakers, HasLen, 1)
+	c.Assert(srv.getBreaker("127.0.0.1:6379"), Equals, b)
+}
@@ -0,0 +1,354 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	respcoding "github.com/ngaut/resp"
+	"github.com/reborndb/reborn/pkg/proxy/parser"
+)
+
+// clientInfo is a session shown by CLIENT LIST, like redis.
+type clientInfo struct {
+	Id        int64  `json:"id"`
+	Addr      string `json:"addr"`
+	LocalAddr string `json:"laddr"`
+	Name      string `json:"name"`
+	Age       int64  `json:"age"`  // seconds
+	Idle      int64  `json:"idle"` // seconds
+	Flags     string `json:"flags"`
+	Sub       int    `json:"sub"`
+	Psub      int    `json:"psub"`
+	Multi     int    `json:"multi"` // commands queued in MULTI, -1 if not in MULTI
+	Ops       int64  `json:"ops"`
+	User      string `json:"user"`
+	Cmd       string `json:"cmd"` // the last command
+}
+
+func (ci *clientInfo) String() string {
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=0 sub=%d psub=%d multi=%d ops=%d user=%s cmd=%s",
+		ci.Id, ci.Addr, ci.LocalAddr, ci.Name, ci.Age, ci.Idle, ci.Flags, ci.Sub, ci.Psub, ci.Multi, ci.Ops, ci.User, ci.Cmd)
+}
+
+// touch records the command being run by the session.
+func (c *session) touch(cmd string) {
+	c.infoMutex.Lock()
+	c.lastCmd = cmd
+	c.activeAt = time.Now()
+	c.infoMutex.Unlock()
+}
+
+func (c *session) setBlocked(blocked bool) {
+	c.infoMutex.Lock()
+	c.blocked = blocked
+	c.infoMutex.Unlock()
+}
+
+// updateInfo publishes the state of the session after a command, the state
+// is owned by the reading goroutine.
+func (c *session) updateInfo() {
+	subs, psubs, multi := 0, 0, -1
+	if c.sub != nil {
+		c.sub.m.Lock()
+		subs, psubs = len(c.sub.channels), len(c.sub.patterns)
+		c.sub.m.Unlock()
+	}
+
+	if c.txn != nil && c.txn.multi {
+		multi = len(c.txn.cmds)
+	}
+
+	c.infoMutex.Lock()
+	c.subs, c.psubs, c.multi = subs, psubs, multi
+	c.userName = c.user
+	c.infoMutex.Unlock()
+}
+
+func (c *session) info(now time.Time) *clientInfo {
+	c.infoMutex.Lock()
+	defer c.infoMutex.Unlock()
+
+	ci := &clientInfo{
+		Id:        c.id,
+		Addr:      c.RemoteAddr().String(),
+		LocalAddr: c.LocalAddr().String(),
+		Name:      c.name,
+		Age:       int64(now.Sub(c.CreateAt) / time.Second),
+		Idle:      int64(now.Sub(c.activeAt) / time.Second),
+		Sub:       c.subs,
+		Psub:      c.psubs,
+		Multi:     c.multi,
+		Ops:       atomic.LoadInt64(&c.Ops),
+		User:      c.userName,
+		Cmd:       c.lastCmd,
+	}
+
+	if c.subs+c.psubs > 0 {
+		ci.Flags += "P"
+	}
+	if c.multi >= 0 {
+		ci.Flags += "x"
+	}
+	if c.blocked {
+		ci.Flags += "b"
+	}
+	if len(ci.Flags) == 0 {
+		ci.Flags = "N"
+	}
+
+	return ci
+}
+
+func (s *Server) registerSession(c *session) {
+	s.sessionMutex.Lock()
+	s.sessions[c.id] = c
+	s.sessionMutex.Unlock()
+}
+
+func (s *Server) unregisterSession(c *session) {
+	s.sessionMutex.Lock()
+	delete(s.sessions, c.id)
+	s.sessionMutex.Unlock()
+}
+
+// listClients returns the live sessions ordered by id.
+func (s *Server) listClients() []*clientInfo {
+	s.sessionMutex.RLock()
+	sessions := make([]*session, 0, len(s.sessions))
+	for _, c := range s.sessions {
+		sessions = append(sessions, c)
+	}
+	s.sessionMutex.RUnlock()
+
+	sort.Sort(sessionsById(sessions))
+
+	now := time.Now()
+	infos := make([]*clientInfo, 0, len(sessions))
+	for _, c := range sessions {
+		infos = append(infos, c.info(now))
+	}
+
+	return infos
+}
+
+type sessionsById []*session
+
+func (p sessionsById) Len() int           { return len(p) }
+func (p sessionsById) Less(i, j int) bool { return p[i].id < p[j].id }
+func (p sessionsById) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// killFilter matches the sessions to kill, the empty fields match all.
+type killFilter struct {
+	id     int64 // 0 matches all
+	addr   string
+	user   string
+	skipMe bool
+}
+
+func parseKillFilter(args [][]byte) (*killFilter, error) {
+	f := &killFilter{skipMe: true}
+	if len(args)%2 != 0 {
+		return nil, errors.New("syntax error")
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		v := string(args[i+1])
+		switch strings.ToUpper(string(args[i])) {
+		case "ID":
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || id <= 0 {
+				return nil, errors.New("client-id should be greater than 0")
+			}
+			f.id = id
+		case "ADDR":
+			f.addr = v
+		case "USER":
+			f.user = v
+		case "SKIPME":
+			switch strings.ToLower(v) {
+			case "yes":
+				f.skipMe = true
+			case "no":
+				f.skipMe = false
+			default:
+				return nil, errors.New("syntax error")
+			}
+		default:
+			return nil, errors.New("syntax error")
+		}
+	}
+
+	return f, nil
+}
+
+// killClients closes the sessions matched by the filter except c, and
+// returns the number of them, it returns whether c is matched too.
+func (s *Server) killClients(c *session, f *killFilter) (int, bool) {
+	s.sessionMutex.RLock()
+	var matched []*session
+	for _, target := range s.sessions {
+		if f.id > 0 && target.id != f.id {
+			continue
+		}
+		if len(f.addr) > 0 && target.RemoteAddr().String() != f.addr {
+			continue
+		}
+		if len(f.user) > 0 {
+			target.infoMutex.Lock()
+			user := target.userName
+			target.infoMutex.Unlock()
+			if user != f.user {
+				continue
+			}
+		}
+		if target == c && f.skipMe {
+			continue
+		}
+		matched = append(matched, target)
+	}
+	s.sessionMutex.RUnlock()
+
+	self := false
+	for _, target := range matched {
+		s.counter.Add("ClientKilled", 1)
+		if target == c {
+			// closed after the reply is sent
+			self = true
+			continue
+		}
+
+		log.Warningf("kill client %v", target)
+
+		// the reading goroutine exits and cleans up the session
+		target.Conn.Close()
+	}
+
+	return len(matched), self
+}
+
+func validClientName(name []byte) bool {
+	for _, b := range name {
+		if b < '!' || b > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// handleClientCommand replies CLIENT ID, INFO, GETNAME, SETNAME, LIST and
+// KILL from the sessions of this proxy, LIST and KILL are admin commands.
+func (s *Server) handleClientCommand(c *session, op []byte, keys [][]byte, resp *parser.Resp) error {
+	sub := strings.ToUpper(string(keys[0]))
+
+	var v interface{}
+	switch {
+	case sub == "ID" && len(keys) == 1:
+		v = int(c.id)
+	case sub == "INFO" && len(keys) == 1:
+		v = []byte(c.info(time.Now()).String() + "\n")
+	case sub == "GETNAME" && len(keys) == 1:
+		c.infoMutex.Lock()
+		if len(c.name) > 0 {
+			v = []byte(c.name)
+		}
+		c.infoMutex.Unlock()
+	case sub == "SETNAME" && len(keys) == 2:
+		if !validClientName(keys[1]) {
+			s.sendBack(c, op, keys, resp, []byte("-ERR Client names cannot contain spaces, newlines or special characters.\r\n"))
+			return nil
+		}
+
+		c.infoMutex.Lock()
+		c.name = string(keys[1])
+		c.infoMutex.Unlock()
+		s.sendBack(c, op, keys, resp, OK_BYTES)
+		return nil
+	case sub == "LIST" && len(keys) == 1:
+		if buf := s.checkAdmin(c, "CLIENT LIST"); buf != nil {
+			s.sendBack(c, op, keys, resp, buf)
+			return nil
+		}
+
+		var b bytes.Buffer
+		for _, ci := range s.listClients() {
+			b.WriteString(ci.String())
+			b.WriteByte('\n')
+		}
+		v = b.Bytes()
+	case sub == "KILL" && len(keys) >= 2:
+		if buf := s.checkAdmin(c, "CLIENT KILL"); buf != nil {
+			s.sendBack(c, op, keys, resp, buf)
+			return nil
+		}
+
+		// CLIENT KILL addr
+		if len(keys) == 2 {
+			n, self := s.killClients(c, &killFilter{addr: string(keys[1])})
+			if n == 0 {
+				s.sendBack(c, op, keys, resp, []byte("-ERR No such client\r\n"))
+				return nil
+			}
+
+			s.sendBack(c, op, keys, resp, OK_BYTES)
+			if self {
+				return errors.Trace(io.EOF)
+			}
+			return nil
+		}
+
+		f, err := parseKillFilter(keys[1:])
+		if err != nil {
+			s.sendBack(c, op, keys, resp, []byte("-ERR "+err.Error()+"\r\n"))
+			return nil
+		}
+
+		n, self := s.killClients(c, f)
+		buf, err := respcoding.Marshal(n)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		s.sendBack(c, op, keys, resp, buf)
+		if self {
+			return errors.Trace(io.EOF)
+		}
+		return nil
+	default:
+		s.sendBack(c, op, keys, resp, []byte("-ERR Unknown subcommand or wrong number of arguments for '"+string(keys[0])+"'\r\n"))
+		return nil
+	}
+
+	buf, err := respcoding.Marshal(v)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	s.sendBack(c, op, keys, resp, buf)
+	return nil
+}
+
+// ServeClients serves the sessions of CLIENT LIST in json for the debug
+// http server.
+func (s *Server) ServeClients(w http.ResponseWriter, r *http.Request) {
+	b, err := json.MarshalIndent(s.listClients(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,119 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testProxyRouterSuite) TestClientCommand(c *C) {
+	conf.AdminAuth = "admin"
+	defer func() {
+		conf.AdminAuth = ""
+	}()
+
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	id, err := redis.Int64(cc.Do("CLIENT", "ID"))
+	c.Assert(err, IsNil)
+
+	name, err := cc.Do("CLIENT", "GETNAME")
+	c.Assert(err, IsNil)
+	c.Assert(name, IsNil)
+
+	_, err = cc.Do("CLIENT", "SETNAME", "bad name")
+	c.Assert(err, NotNil)
+
+	ok, err := redis.String(cc.Do("CLIENT", "SETNAME", "client_test"))
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, "OK")
+
+	name, err = redis.String(cc.Do("CLIENT", "GETNAME"))
+	c.Assert(err, IsNil)
+	c.Assert(name, Equals, "client_test")
+
+	info, err := redis.String(cc.Do("CLIENT", "INFO"))
+	c.Assert(err, IsNil)
+	c.Assert(info, Matches, fmt.Sprintf("id=%d addr=.* name=client_test .*flags=N .*cmd=client\n", id))
+
+	// LIST and KILL are admin commands
+	_, err = cc.Do("CLIENT", "LIST")
+	c.Assert(err, ErrorMatches, "ERR CLIENT LIST requires admin auth")
+
+	victim := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer victim.Close()
+
+	victimId, err := redis.Int64(victim.Do("CLIENT", "ID"))
+	c.Assert(err, IsNil)
+	_, err = victim.Do("MULTI")
+	c.Assert(err, IsNil)
+
+	_, err = cc.Do("AUTH", "admin")
+	c.Assert(err, IsNil)
+
+	list, err := redis.String(cc.Do("CLIENT", "LIST"))
+	c.Assert(err, IsNil)
+	c.Assert(list, Matches, fmt.Sprintf("(?s).*id=%d .*name=client_test .*", id))
+	c.Assert(list, Matches, fmt.Sprintf("(?s).*id=%d .*flags=x .*multi=0 .*cmd=multi\n.*", victimId))
+
+	// the same list in json
+	w := httptest.NewRecorder()
+	ss.ServeClients(w, &http.Request{})
+	c.Assert(w.Code, Equals, http.StatusOK)
+
+	var infos []*clientInfo
+	err = json.Unmarshal(w.Body.Bytes(), &infos)
+	c.Assert(err, IsNil)
+
+	found := false
+	for _, ci := range infos {
+		if ci.Id == id {
+			found = true
+			c.Assert(ci.Name, Equals, "client_test")
+		}
+	}
+	c.Assert(found, Equals, true)
+
+	n, err := redis.Int(cc.Do("CLIENT", "KILL", "ID", fmt.Sprint(victimId)))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+
+	_, err = victim.Do("PING")
+	c.Assert(err, NotNil)
+
+	// the killed session is removed from the registry
+	for i := 0; i < 50; i++ {
+		list, err = redis.String(cc.Do("CLIENT", "LIST"))
+		c.Assert(err, IsNil)
+		if !strings.Contains(list, fmt.Sprintf("id=%d ", victimId)) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(strings.Contains(list, fmt.Sprintf("id=%d ", victimId)), Equals, false)
+
+	_, err = cc.Do("CLIENT", "KILL", "127.0.0.1:1")
+	c.Assert(err, ErrorMatches, "ERR No such client")
+
+	// skip itself by default
+	n, err = redis.Int(cc.Do("CLIENT", "KILL", "ID", fmt.Sprint(id)))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+
+	n, err = redis.Int(cc.Do("CLIENT", "KILL", "ID", fmt.Sprint(id), "SKIPME", "no"))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+
+	_, err = cc.Do("PING")
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,250 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/go-zookeeper/zk"
+	"github.com/ngaut/log"
+	respcoding "github.com/ngaut/resp"
+	"github.com/ngaut/zkhelper"
+	"github.com/reborndb/reborn/pkg/models"
+	"github.com/reborndb/reborn/pkg/proxy/parser"
+)
+
+// ClusterSlotNum is the number of hash slots of redis cluster. Cluster clients
+// hash keys with crc16 into these slots only to pick a proxy, every proxy can
+// serve all keys and maps them to slots with mapKey2Slot itself, so the slots
+// are split evenly between the online proxies. The reborn slots are not used
+// here on purpose, the clients can not hash keys into them.
+const ClusterSlotNum = 16384
+
+// clusterSlotConfig maps keys to the cluster slots like redis cluster.
+var clusterSlotConfig = &models.SlotConfig{Num: ClusterSlotNum, Hash: models.SLOT_HASH_CRC16}
+
+// clusterKeySlot returns the cluster slot of the key, which is replied by
+// CLUSTER KEYSLOT, an empty hash tag is a part of the key like redis.
+func clusterKeySlot(key []byte) int {
+	h := hashKey(key)
+	if len(h) == 0 {
+		h = key
+	}
+
+	return clusterSlotConfig.HashSlot(h)
+}
+
+// clusterNode is an online proxy presented as a redis cluster master.
+type clusterNode struct {
+	id    string
+	host  string
+	port  int
+	self  bool
+	start int
+	end   int
+}
+
+// clusterNodeId returns a 40 characters node id like redis cluster, it never
+// changes for the same proxy.
+func clusterNodeId(proxyId string) string {
+	h := sha1.Sum([]byte(proxyId))
+	return hex.EncodeToString(h[:])
+}
+
+type proxyInfoSorter []models.ProxyInfo
+
+func (s proxyInfoSorter) Len() int           { return len(s) }
+func (s proxyInfoSorter) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s proxyInfoSorter) Less(i, j int) bool { return s[i].ID < s[j].ID }
+
+// clusterNodes splits the cluster slots between the proxies in order of id.
+func clusterNodes(proxies []models.ProxyInfo, self string) []*clusterNode {
+	sort.Sort(proxyInfoSorter(proxies))
+
+	nodes := make([]*clusterNode, 0, len(proxies))
+	for _, p := range proxies {
+		host, port, err := net.SplitHostPort(p.Addr)
+		if err != nil {
+			log.Warningf("invalid proxy %s addr %s, %v", p.ID, p.Addr, err)
+			continue
+		}
+
+		n, err := strconv.Atoi(port)
+		if err != nil {
+			log.Warningf("invalid proxy %s addr %s, %v", p.ID, p.Addr, err)
+			continue
+		}
+
+		nodes = append(nodes, &clusterNode{
+			id:   clusterNodeId(p.ID),
+			host: host,
+			port: n,
+			self: p.ID == self,
+		})
+	}
+
+	for i, node := range nodes {
+		node.start = ClusterSlotNum * i / len(nodes)
+		node.end = ClusterSlotNum*(i+1)/len(nodes) - 1
+	}
+
+	return nodes
+}
+
+// getClusterNodes returns the cluster nodes of the cached online proxies,
+// the cluster commands never read the coordinator.
+func (s *Server) getClusterNodes() []*clusterNode {
+	s.proxyMutex.RLock()
+	proxies := append([]models.ProxyInfo(nil), s.proxies...)
+	s.proxyMutex.RUnlock()
+
+	return clusterNodes(proxies, s.pi.ID)
+}
+
+// watchProxies reloads the online proxies and watches the proxy list and every
+// proxy node not watched yet, so the list is reloaded when a proxy registers,
+// goes away or changes state. It runs in the topology loop, the node of this
+// proxy is watched by handleProxyCommand.
+func (s *Server) watchProxies() error {
+	dir := models.GetProxyPath(s.top.ProductName)
+	if !s.proxyWatches[dir] {
+		if _, err := s.top.WatchChildren(dir, s.evtbus); err != nil {
+			return errors.Trace(err)
+		}
+		s.proxyWatches[dir] = true
+	}
+
+	all, err := s.top.GetProxyList(nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var proxies []models.ProxyInfo
+	for _, pi := range all {
+		p := path.Join(dir, pi.ID)
+		if pi.ID != s.pi.ID && !s.proxyWatches[p] {
+			_, err = s.top.WatchNode(p, s.evtbus)
+			if err != nil && !zkhelper.ZkErrorEqual(err, zk.ErrNoNode) {
+				return errors.Trace(err)
+			}
+			s.proxyWatches[p] = err == nil
+		}
+
+		if pi.State == models.PROXY_STATE_ONLINE {
+			proxies = append(proxies, pi)
+		}
+	}
+
+	s.proxyMutex.Lock()
+	s.proxies = proxies
+	s.proxyMutex.Unlock()
+	return nil
+}
+
+// handleProxyEvent handles the watch event of a proxy node or the proxy list.
+func (s *Server) handleProxyEvent(e interface{}) error {
+	dir := models.GetProxyPath(s.top.ProductName)
+	switch evtPath := GetEventPath(e); {
+	case s.top.IsChildrenChangedEvent(e):
+		delete(s.proxyWatches, dir)
+	case evtPath == path.Join(dir, s.pi.ID):
+		// should be order for me to suicide
+		if err := s.handleProxyCommand(); err != nil {
+			return errors.Trace(err)
+		}
+	default:
+		delete(s.proxyWatches, evtPath)
+	}
+
+	return errors.Trace(s.watchProxies())
+}
+
+func clusterSlotsReply(nodes []*clusterNode) []interface{} {
+	slots := make([]interface{}, 0, len(nodes))
+	for _, node := range nodes {
+		slots = append(slots, []interface{}{
+			node.start, node.end,
+			[]interface{}{[]byte(node.host), node.port, []byte(node.id)},
+		})
+	}
+
+	return slots
+}
+
+func clusterNodesReply(nodes []*clusterNode) []byte {
+	var b bytes.Buffer
+	for _, node := range nodes {
+		flags := "master"
+		if node.self {
+			flags = "myself,master"
+		}
+		fmt.Fprintf(&b, "%s %s:%d %s - 0 0 0 connected %d-%d\n", node.id, node.host, node.port, flags, node.start, node.end)
+	}
+
+	return b.Bytes()
+}
+
+func clusterInfoReply(nodes []*clusterNode) []byte {
+	state := "ok"
+	assigned := ClusterSlotNum
+	if len(nodes) == 0 {
+		state = "fail"
+		assigned = 0
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "cluster_state:%s\r\n", state)
+	fmt.Fprintf(&b, "cluster_slots_assigned:%d\r\n", assigned)
+	fmt.Fprintf(&b, "cluster_slots_ok:%d\r\n", assigned)
+	b.WriteString("cluster_slots_pfail:0\r\n")
+	b.WriteString("cluster_slots_fail:0\r\n")
+	fmt.Fprintf(&b, "cluster_known_nodes:%d\r\n", len(nodes))
+	fmt.Fprintf(&b, "cluster_size:%d\r\n", len(nodes))
+	b.WriteString("cluster_current_epoch:0\r\n")
+	b.WriteString("cluster_my_epoch:0\r\n")
+
+	return b.Bytes()
+}
+
+// handleClusterCommand emulates the redis cluster commands which cluster
+// clients use to discover the nodes, the online proxies are the nodes.
+func (s *Server) handleClusterCommand(c *session, op []byte, keys [][]byte, resp *parser.Resp) error {
+	sub := strings.ToUpper(string(keys[0]))
+
+	var v interface{}
+	switch {
+	case sub == "KEYSLOT" && len(keys) == 2:
+		v = clusterKeySlot(keys[1])
+	case (sub == "SLOTS" || sub == "NODES" || sub == "INFO") && len(keys) == 1:
+		nodes := s.getClusterNodes()
+		switch sub {
+		case "SLOTS":
+			v = clusterSlotsReply(nodes)
+		case "NODES":
+			v = clusterNodesReply(nodes)
+		default:
+			v = clusterInfoReply(nodes)
+		}
+	default:
+		s.sendBack(c, op, keys, resp, []byte("-ERR Unknown subcommand or wrong number of arguments for '"+string(keys[0])+"'\r\n"))
+		return nil
+	}
+
+	buf, err := respcoding.Marshal(v)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	s.sendBack(c, op, keys, resp, buf)
+	return nil
+}
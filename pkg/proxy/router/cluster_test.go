@@ -0,0 +1,122 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/reborndb/reborn/pkg/models"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testProxyRouterSuite) TestClusterNodes(c *C) {
+	proxies := []models.ProxyInfo{
+		{ID: "proxy_3", Addr: "host3:19000"},
+		{ID: "proxy_1", Addr: "host1:19000"},
+		{ID: "proxy_bad", Addr: "host4"},
+		{ID: "proxy_2", Addr: "host2:19001"},
+	}
+
+	nodes := clusterNodes(proxies, "proxy_2")
+	c.Assert(nodes, HasLen, 3)
+
+	c.Assert(nodes[0].host, Equals, "host1")
+	c.Assert(nodes[0].start, Equals, 0)
+	c.Assert(nodes[0].end, Equals, 5460)
+	c.Assert(nodes[1].port, Equals, 19001)
+	c.Assert(nodes[1].self, Equals, true)
+	c.Assert(nodes[1].start, Equals, 5461)
+	c.Assert(nodes[2].end, Equals, ClusterSlotNum-1)
+
+	c.Assert(nodes[0].id, HasLen, 40)
+	c.Assert(nodes[0].id, Equals, clusterNodeId("proxy_1"))
+	c.Assert(nodes[0].id, Not(Equals), nodes[1].id)
+
+	c.Assert(clusterNodes(nil, "proxy_1"), HasLen, 0)
+	c.Assert(string(clusterInfoReply(nil)), Matches, "(?s)cluster_state:fail.*")
+}
+
+func (s *testProxyRouterSuite) TestClusterKeySlot(c *C) {
+	// the same as redis cluster
+	c.Assert(clusterKeySlot([]byte("foo")), Equals, 12182)
+	c.Assert(clusterKeySlot([]byte("somekey")), Equals, 11058)
+	c.Assert(clusterKeySlot([]byte("{foo}bar")), Equals, 12182)
+	c.Assert(clusterKeySlot([]byte("{}foo")), Equals, clusterSlotConfig.HashSlot([]byte("{}foo")))
+}
+
+func (s *testProxyRouterSuite) TestClusterCommands(c *C) {
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	hname, err := os.Hostname()
+	c.Assert(err, IsNil)
+
+	slot, err := redis.Int(cc.Do("CLUSTER", "KEYSLOT", "{a}1"))
+	c.Assert(err, IsNil)
+	c.Assert(slot, Equals, clusterKeySlot([]byte("a")))
+
+	slots, err := redis.Values(cc.Do("CLUSTER", "SLOTS"))
+	c.Assert(err, IsNil)
+	c.Assert(slots, HasLen, 1)
+
+	r, err := redis.Values(slots[0], nil)
+	c.Assert(err, IsNil)
+	c.Assert(r[0], Equals, int64(0))
+	c.Assert(r[1], Equals, int64(ClusterSlotNum-1))
+
+	node, err := redis.Values(r[2], nil)
+	c.Assert(err, IsNil)
+	c.Assert(string(node[0].([]byte)), Equals, hname)
+	c.Assert(node[1], Equals, int64(19000))
+	c.Assert(string(node[2].([]byte)), Equals, clusterNodeId(conf.ProxyID))
+
+	nodes, err := redis.String(cc.Do("CLUSTER", "NODES"))
+	c.Assert(err, IsNil)
+	c.Assert(strings.Fields(nodes), DeepEquals, []string{clusterNodeId(conf.ProxyID), hname + ":19000",
+		"myself,master", "-", "0", "0", "0", "connected", "0-16383"})
+
+	info, err := redis.String(cc.Do("CLUSTER", "INFO"))
+	c.Assert(err, IsNil)
+	c.Assert(info, Matches, "(?s)cluster_state:ok\r\n.*cluster_known_nodes:1\r\n.*")
+
+	_, err = cc.Do("CLUSTER", "FAILOVER")
+	c.Assert(err, ErrorMatches, "ERR Unknown subcommand.*")
+}
+
+func (s *testProxyRouterSuite) TestClusterNodesWatch(c *C) {
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	waitNodes := func(n int) {
+		var info string
+		var err error
+		for i := 0; i < 50; i++ {
+			info, err = redis.String(cc.Do("CLUSTER", "INFO"))
+			c.Assert(err, IsNil)
+			if strings.Contains(info, fmt.Sprintf("cluster_known_nodes:%d\r\n", n)) {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		c.Fatalf("cluster nodes not %d, %s", n, info)
+	}
+
+	// the cached proxy list follows the proxies registered and changing state
+	pi := &models.ProxyInfo{ID: "proxy_watch", Addr: "127.0.0.1:19100", State: models.PROXY_STATE_OFFLINE}
+	_, err := models.CreateProxyInfo(conn, conf.ProductName, pi)
+	c.Assert(err, IsNil)
+	waitNodes(1)
+
+	err = models.SetProxyStatus(conn, conf.ProductName, pi.ID, models.PROXY_STATE_ONLINE)
+	c.Assert(err, IsNil)
+	waitNodes(2)
+
+	err = models.SetProxyStatus(conn, conf.ProductName, pi.ID, models.PROXY_STATE_OFFLINE)
+	c.Assert(err, IsNil)
+	waitNodes(1)
+}
@@ -0,0 +1,530 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	respcoding "github.com/ngaut/resp"
+	"github.com/reborndb/reborn/pkg/models"
+	"github.com/reborndb/reborn/pkg/proxy/parser"
+)
+
+const (
+	cmdReadOnly = 1 << iota // never modify data, can be sent to slaves by read policy
+	cmdWrite
+	cmdAdmin    // requires admin auth
+	cmdBlocking // sent to backend server with a dedicated connection
+	cmdDenied   // not allowed in proxy
+)
+
+var commandFlagNames = []struct {
+	flag int
+	name string
+}{
+	{cmdReadOnly, models.COMMAND_FLAG_READONLY},
+	{cmdWrite, models.COMMAND_FLAG_WRITE},
+	{cmdAdmin, models.COMMAND_FLAG_ADMIN},
+	{cmdBlocking, models.COMMAND_FLAG_BLOCKING},
+	{cmdDenied, models.COMMAND_FLAG_DENIED},
+}
+
+// keySpec describes where the keys are in the arguments of a command, like
+// the first key, last key and step of redis COMMAND, position 1 is the first
+// argument after the command name, a negative last key counts from the end.
+// If numKeys is not 0, it is the position of the argument which gives the
+// number of keys following it, e.g. EVAL script numkeys key [key ...].
+// The argument after any of keyOptions is a key too, e.g. SORT ... STORE key.
+type keySpec struct {
+	first      int
+	last       int
+	step       int
+	numKeys    int
+	keyOptions []string
+}
+
+type commandInfo struct {
+	name  string
+	arity int // like redis, -N means at least N, including the command name
+	flags int
+	keySpec
+}
+
+var (
+	noKey     = keySpec{}
+	singleKey = keySpec{first: 1, last: 1, step: 1}
+	allKeys   = keySpec{first: 1, last: -1, step: 1}
+	twoKeys   = keySpec{first: 1, last: 2, step: 1}
+	numKeys   = keySpec{numKeys: 2}
+	destKey   = keySpec{first: 1, last: 1, step: 1, numKeys: 2}
+)
+
+var defaultCommandList = []*commandInfo{
+	// connection, handled by proxy
+	{"AUTH", -2, 0, noKey},
+	{"PING", -1, 0, noKey},
+	{"ECHO", 2, 0, noKey},
+	{"SELECT", 2, 0, noKey},
+	{"QUIT", 1, 0, noKey},
+	{"COMMAND", -1, 0, noKey},
+	{"CLUSTER", -2, 0, noKey},
+
+	// keys
+	{"DEL", -2, cmdWrite, allKeys},
+	{"EXISTS", -2, cmdReadOnly, allKeys},
+	{"TYPE", 2, cmdReadOnly, singleKey},
+	{"TTL", 2, cmdReadOnly, singleKey},
+	{"PTTL", 2, cmdReadOnly, singleKey},
+	{"EXPIRE", 3, cmdWrite, singleKey},
+	{"PEXPIRE", 3, cmdWrite, singleKey},
+	{"EXPIREAT", 3, cmdWrite, singleKey},
+	{"PEXPIREAT", 3, cmdWrite, singleKey},
+	{"PERSIST", 2, cmdWrite, singleKey},
+	{"DUMP", 2, cmdReadOnly, singleKey},
+	{"RESTORE", -4, cmdWrite, singleKey},
+	{"RENAME", 3, cmdWrite, twoKeys},
+	{"RENAMENX", 3, cmdWrite, twoKeys},
+	{"OBJECT", -2, cmdReadOnly, keySpec{first: 2, last: 2, step: 1}},
+	{"SORT", -2, cmdWrite, keySpec{first: 1, last: 1, step: 1, keyOptions: []string{"STORE"}}},
+	{"SCAN", -2, cmdReadOnly, noKey},
+	{"RANDOMKEY", 1, cmdReadOnly, noKey},
+	{"KEYS", 2, cmdReadOnly | cmdDenied, noKey},
+	{"MOVE", 3, cmdWrite | cmdDenied, singleKey},
+	{"MIGRATE", -6, cmdWrite | cmdDenied, noKey},
+
+	// strings
+	{"GET", 2, cmdReadOnly, singleKey},
+	{"SET", -3, cmdWrite, singleKey},
+	{"SETNX", 3, cmdWrite, singleKey},
+	{"SETEX", 4, cmdWrite, singleKey},
+	{"PSETEX", 4, cmdWrite, singleKey},
+	{"GETSET", 3, cmdWrite, singleKey},
+	{"APPEND", 3, cmdWrite, singleKey},
+	{"STRLEN", 2, cmdReadOnly, singleKey},
+	{"GETRANGE", 4, cmdReadOnly, singleKey},
+	{"SUBSTR", 4, cmdReadOnly, singleKey},
+	{"SETRANGE", 4, cmdWrite, singleKey},
+	{"INCR", 2, cmdWrite, singleKey},
+	{"DECR", 2, cmdWrite, singleKey},
+	{"INCRBY", 3, cmdWrite, singleKey},
+	{"DECRBY", 3, cmdWrite, singleKey},
+	{"INCRBYFLOAT", 3, cmdWrite, singleKey},
+	{"GETBIT", 3, cmdReadOnly, singleKey},
+	{"SETBIT", 4, cmdWrite, singleKey},
+	{"BITCOUNT", -2, cmdReadOnly, singleKey},
+	{"BITPOS", -3, cmdReadOnly, singleKey},
+	{"BITOP", -4, cmdWrite, keySpec{first: 2, last: -1, step: 1}},
+	{"MGET", -2, cmdReadOnly, allKeys},
+	{"MSET", -3, cmdWrite, keySpec{first: 1, last: -1, step: 2}},
+	{"MSETNX", -3, cmdWrite, keySpec{first: 1, last: -1, step: 2}},
+
+	// lists
+	{"LPUSH", -3, cmdWrite, singleKey},
+	{"RPUSH", -3, cmdWrite, singleKey},
+	{"LPUSHX", -3, cmdWrite, singleKey},
+	{"RPUSHX", -3, cmdWrite, singleKey},
+	{"LINSERT", 5, cmdWrite, singleKey},
+	{"LPOP", 2, cmdWrite, singleKey},
+	{"RPOP", 2, cmdWrite, singleKey},
+	{"LLEN", 2, cmdReadOnly, singleKey},
+	{"LINDEX", 3, cmdReadOnly, singleKey},
+	{"LSET", 4, cmdWrite, singleKey},
+	{"LRANGE", 4, cmdReadOnly, singleKey},
+	{"LTRIM", 4, cmdWrite, singleKey},
+	{"LREM", 4, cmdWrite, singleKey},
+	{"RPOPLPUSH", 3, cmdWrite, twoKeys},
+	{"BLPOP", -3, cmdWrite | cmdBlocking, keySpec{first: 1, last: -2, step: 1}},
+	{"BRPOP", -3, cmdWrite | cmdBlocking, keySpec{first: 1, last: -2, step: 1}},
+	{"BRPOPLPUSH", 4, cmdWrite | cmdBlocking, twoKeys},
+
+	// hashes
+	{"HSET", -4, cmdWrite, singleKey},
+	{"HSETNX", 4, cmdWrite, singleKey},
+	{"HGET", 3, cmdReadOnly, singleKey},
+	{"HMSET", -4, cmdWrite, singleKey},
+	{"HMGET", -3, cmdReadOnly, singleKey},
+	{"HINCRBY", 4, cmdWrite, singleKey},
+	{"HINCRBYFLOAT", 4, cmdWrite, singleKey},
+	{"HDEL", -3, cmdWrite, singleKey},
+	{"HLEN", 2, cmdReadOnly, singleKey},
+	{"HSTRLEN", 3, cmdReadOnly, singleKey},
+	{"HKEYS", 2, cmdReadOnly, singleKey},
+	{"HVALS", 2, cmdReadOnly, singleKey},
+	{"HGETALL", 2, cmdReadOnly, singleKey},
+	{"HEXISTS", 3, cmdReadOnly, singleKey},
+	{"HSCAN", -3, cmdReadOnly, singleKey},
+
+	// sets
+	{"SADD", -3, cmdWrite, singleKey},
+	{"SREM", -3, cmdWrite, singleKey},
+	{"SPOP", -2, cmdWrite, singleKey},
+	{"SRANDMEMBER", -2, cmdReadOnly, singleKey},
+	{"SISMEMBER", 3, cmdReadOnly, singleKey},
+	{"SCARD", 2, cmdReadOnly, singleKey},
+	{"SMEMBERS", 2, cmdReadOnly, singleKey},
+	{"SSCAN", -3, cmdReadOnly, singleKey},
+	{"SMOVE", 4, cmdWrite, twoKeys},
+	{"SINTER", -2, cmdReadOnly, allKeys},
+	{"SINTERSTORE", -3, cmdWrite, allKeys},
+	{"SUNION", -2, cmdReadOnly, allKeys},
+	{"SUNIONSTORE", -3, cmdWrite, allKeys},
+	{"SDIFF", -2, cmdReadOnly, allKeys},
+	{"SDIFFSTORE", -3, cmdWrite, allKeys},
+
+	// sorted sets
+	{"ZADD", -4, cmdWrite, singleKey},
+	{"ZINCRBY", 4, cmdWrite, singleKey},
+	{"ZREM", -3, cmdWrite, singleKey},
+	{"ZREMRANGEBYSCORE", 4, cmdWrite, singleKey},
+	{"ZREMRANGEBYRANK", 4, cmdWrite, singleKey},
+	{"ZREMRANGEBYLEX", 4, cmdWrite, singleKey},
+	{"ZCARD", 2, cmdReadOnly, singleKey},
+	{"ZCOUNT", 4, cmdReadOnly, singleKey},
+	{"ZLEXCOUNT", 4, cmdReadOnly, singleKey},
+	{"ZSCORE", 3, cmdReadOnly, singleKey},
+	{"ZRANK", 3, cmdReadOnly, singleKey},
+	{"ZREVRANK", 3, cmdReadOnly, singleKey},
+	{"ZRANGE", -4, cmdReadOnly, singleKey},
+	{"ZREVRANGE", -4, cmdReadOnly, singleKey},
+	{"ZRANGEBYSCORE", -4, cmdReadOnly, singleKey},
+	{"ZREVRANGEBYSCORE", -4, cmdReadOnly, singleKey},
+	{"ZRANGEBYLEX", -4, cmdReadOnly, singleKey},
+	{"ZREVRANGEBYLEX", -4, cmdReadOnly, singleKey},
+	{"ZSCAN", -3, cmdReadOnly, singleKey},
+	{"ZGETALL", 2, cmdReadOnly, singleKey},
+	{"ZUNIONSTORE", -4, cmdWrite, destKey},
+	{"ZINTERSTORE", -4, cmdWrite, destKey},
+
+	// hyperloglog
+	{"PFADD", -2, cmdWrite, singleKey},
+	{"PFCOUNT", -2, cmdReadOnly, allKeys},
+	{"PFMERGE", -2, cmdWrite, allKeys},
+
+	// geo
+	{"GEOADD", -5, cmdWrite, singleKey},
+	{"GEOHASH", -2, cmdReadOnly, singleKey},
+	{"GEOPOS", -2, cmdReadOnly, singleKey},
+	{"GEODIST", -4, cmdReadOnly, singleKey},
+	{"GEORADIUS", -6, cmdWrite, keySpec{first: 1, last: 1, step: 1, keyOptions: []string{"STORE", "STOREDIST"}}},
+	{"GEORADIUSBYMEMBER", -5, cmdWrite, keySpec{first: 1, last: 1, step: 1, keyOptions: []string{"STORE", "STOREDIST"}}},
+
+	// scripting
+	{"EVAL", -3, cmdWrite, numKeys},
+	{"EVALSHA", -3, cmdWrite, numKeys},
+	{"SCRIPT", -2, cmdWrite, noKey}, // sent to the slot of the subcommand as before, not all groups
+
+	// transactions
+	{"MULTI", 1, 0, noKey},
+	{"EXEC", 1, 0, noKey},
+	{"DISCARD", 1, 0, noKey},
+	{"WATCH", -2, 0, allKeys},
+	{"UNWATCH", 1, 0, noKey},
+
+	// pub/sub, channels are routed like keys, PUBLISH requires write
+	{"PUBLISH", 3, cmdWrite, singleKey},
+	{"SUBSCRIBE", -2, 0, noKey},
+	{"PSUBSCRIBE", -2, 0, noKey},
+	{"UNSUBSCRIBE", -1, 0, noKey},
+	{"PUNSUBSCRIBE", -1, 0, noKey},
+
+	// server, sent to all group masters
+	{"DBSIZE", 1, cmdReadOnly, noKey},
+	{"INFO", -1, cmdReadOnly, noKey},
+	{"FLUSHALL", -1, cmdWrite | cmdAdmin, noKey},
+	{"FLUSHDB", -1, cmdWrite | cmdAdmin, noKey},
+
+	// server, not allowed
+	{"BGREWRITEAOF", 1, cmdAdmin | cmdDenied, noKey},
+	{"BGSAVE", 1, cmdAdmin | cmdDenied, noKey},
+	{"SAVE", 1, cmdAdmin | cmdDenied, noKey},
+	{"LASTSAVE", 1, cmdDenied, noKey},
+	{"CLIENT", -2, 0, noKey},
+	{"CONFIG", -2, cmdAdmin | cmdDenied, noKey},
+	{"DEBUG", -2, cmdAdmin | cmdDenied, noKey},
+	{"MONITOR", 1, cmdAdmin | cmdDenied, noKey},
+	{"SHUTDOWN", -1, cmdAdmin | cmdDenied, noKey},
+	{"SLAVEOF", 3, cmdAdmin | cmdDenied, noKey},
+	{"SLOWLOG", -2, cmdAdmin, noKey},
+	{"SYNC", 1, cmdAdmin | cmdDenied, noKey},
+	{"PSYNC", 3, cmdAdmin | cmdDenied, noKey},
+	{"TIME", 1, cmdDenied, noKey},
+	{"SLOTSMGRTONE", 5, cmdAdmin | cmdDenied, noKey},
+	{"SLOTSMGRT", -5, cmdAdmin | cmdDenied, noKey},
+	{"SLOTSDEL", -2, cmdAdmin | cmdDenied, noKey},
+}
+
+// commandTable is read only after created, it is replaced as a whole when
+// the overrides in coordinator are changed.
+type commandTable map[string]*commandInfo
+
+var defaultCommands = make(commandTable)
+
+func init() {
+	for _, cmd := range defaultCommandList {
+		defaultCommands[cmd.name] = cmd
+	}
+}
+
+func parseCommandFlags(names []string) (int, error) {
+	flags := 0
+	for _, name := range names {
+		found := false
+		for _, f := range commandFlagNames {
+			if f.name == name {
+				flags |= f.flag
+				found = true
+			}
+		}
+
+		if !found {
+			return 0, errors.NotValidf("command flag %s", name)
+		}
+	}
+
+	return flags, nil
+}
+
+// newCommandTable returns the default commands with the overrides applied.
+func newCommandTable(overrides []*models.Command) (commandTable, error) {
+	t := make(commandTable, len(defaultCommands)+len(overrides))
+	for name, cmd := range defaultCommands {
+		t[name] = cmd
+	}
+
+	for _, o := range overrides {
+		if err := o.Validate(); err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		flags, err := parseCommandFlags(o.Flags)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		cmd := &commandInfo{
+			name:  strings.ToUpper(o.Name),
+			arity: o.Arity,
+			flags: flags,
+			keySpec: keySpec{
+				first:   o.FirstKey,
+				last:    o.LastKey,
+				step:    o.Step,
+				numKeys: o.NumKeys,
+			},
+		}
+		for _, opt := range o.KeyOptions {
+			cmd.keyOptions = append(cmd.keyOptions, strings.ToUpper(opt))
+		}
+
+		t[cmd.name] = cmd
+	}
+
+	return t, nil
+}
+
+// lookup returns the command, commands not in the table are allowed and
+// use the first argument as key.
+func (t commandTable) lookup(op string) *commandInfo {
+	if cmd, ok := t[op]; ok {
+		return cmd
+	}
+
+	return &commandInfo{name: op, keySpec: singleKey}
+}
+
+func (cmd *commandInfo) readOnly() bool {
+	return cmd.flags&cmdReadOnly != 0
+}
+
+func (cmd *commandInfo) admin() bool {
+	return cmd.flags&cmdAdmin != 0
+}
+
+func (cmd *commandInfo) blocking() bool {
+	return cmd.flags&cmdBlocking != 0
+}
+
+func (cmd *commandInfo) denied() bool {
+	return cmd.flags&cmdDenied != 0
+}
+
+// multiKey returns whether the command may have keys in different slots.
+func (cmd *commandInfo) multiKey() bool {
+	return cmd.last != cmd.first || cmd.numKeys > 0 || len(cmd.keyOptions) > 0
+}
+
+// checkArity checks the number of arguments, n includes the command name,
+// arity 0 is not checked.
+func (cmd *commandInfo) checkArity(n int) bool {
+	if cmd.arity > 0 {
+		return n == cmd.arity
+	}
+
+	return n >= -cmd.arity
+}
+
+func (cmd *commandInfo) flagNames() []interface{} {
+	var names []interface{}
+	for _, f := range commandFlagNames {
+		if cmd.flags&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+
+	if cmd.numKeys > 0 || len(cmd.keyOptions) > 0 {
+		names = append(names, "movablekeys")
+	}
+
+	return names
+}
+
+// keys returns the keys that decide which slot the command belongs to,
+// other arguments, like the values of MSET, are skipped, args are all the
+// arguments after the command name.
+func (cmd *commandInfo) keys(args [][]byte) [][]byte {
+	var keys [][]byte
+	if cmd.first > 0 {
+		last := cmd.last
+		if last < 0 {
+			last += len(args) + 1
+		}
+		if last > len(args) {
+			last = len(args)
+		}
+
+		for i := cmd.first; i <= last; i += cmd.step {
+			keys = append(keys, args[i-1])
+		}
+	}
+
+	if cmd.numKeys > 0 && cmd.numKeys <= len(args) {
+		// invalid numkeys is left to backend server to reply error
+		n, err := parser.Btoi(args[cmd.numKeys-1])
+		if err == nil && n > 0 {
+			for i := cmd.numKeys; i < len(args) && i < cmd.numKeys+n; i++ {
+				keys = append(keys, args[i])
+			}
+		}
+	}
+
+	if len(cmd.keyOptions) > 0 {
+		for i := cmd.first; i < len(args)-1; i++ {
+			for _, opt := range cmd.keyOptions {
+				if strings.EqualFold(string(args[i]), opt) {
+					keys = append(keys, args[i+1])
+					break
+				}
+			}
+		}
+	}
+
+	return keys
+}
+
+func (s *Server) getCommand(op string) *commandInfo {
+	s.cmdMutex.RLock()
+	cmd := s.cmds.lookup(op)
+	s.cmdMutex.RUnlock()
+	return cmd
+}
+
+// loadCommands applies the command overrides of the product in coordinator,
+// the current table is kept if the overrides are invalid.
+func (s *Server) loadCommands() {
+	overrides, err := s.top.GetCommands()
+	if err != nil {
+		log.Warningf("get command overrides failed, %v", errors.ErrorStack(err))
+		return
+	}
+
+	t, err := newCommandTable(overrides)
+	if err != nil {
+		log.Warningf("invalid command overrides, %v", errors.ErrorStack(err))
+		return
+	}
+
+	log.Infof("load %d command overrides", len(overrides))
+
+	s.cmdMutex.Lock()
+	s.cmds = t
+	s.cmdMutex.Unlock()
+}
+
+func (cmd *commandInfo) reply() []interface{} {
+	return []interface{}{
+		[]byte(strings.ToLower(cmd.name)), cmd.arity, cmd.flagNames(),
+		cmd.first, cmd.last, cmd.step,
+	}
+}
+
+// handleCommandCommand replies COMMAND, COMMAND COUNT, COMMAND INFO and
+// COMMAND GETKEYS from the command table.
+func (s *Server) handleCommandCommand(c *session, op []byte, keys [][]byte, resp *parser.Resp) error {
+	s.cmdMutex.RLock()
+	t := s.cmds
+	s.cmdMutex.RUnlock()
+
+	var v interface{}
+	if len(resp.Multi) < 2 {
+		names := make([]string, 0, len(t))
+		for name, _ := range t {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		cmds := make([]interface{}, 0, len(names))
+		for _, name := range names {
+			cmds = append(cmds, t[name].reply())
+		}
+		v = cmds
+	} else {
+		sub := strings.ToUpper(string(keys[0]))
+		switch {
+		case sub == "COUNT" && len(keys) == 1:
+			v = len(t)
+		case sub == "INFO":
+			cmds := make([]interface{}, 0, len(keys)-1)
+			for _, name := range keys[1:] {
+				if cmd, ok := t[strings.ToUpper(string(name))]; ok {
+					cmds = append(cmds, cmd.reply())
+				} else {
+					cmds = append(cmds, nil)
+				}
+			}
+			v = cmds
+		case sub == "GETKEYS" && len(keys) > 1:
+			cmd, ok := t[strings.ToUpper(string(keys[1]))]
+			if !ok || !cmd.checkArity(len(keys)-1) {
+				s.sendBack(c, op, keys, resp, []byte("-ERR Invalid command specified\r\n"))
+				return nil
+			}
+
+			found := cmd.keys(keys[2:])
+			if len(found) == 0 {
+				s.sendBack(c, op, keys, resp, []byte("-ERR The command has no key arguments\r\n"))
+				return nil
+			}
+
+			ks := make([]interface{}, 0, len(found))
+			for _, k := range found {
+				ks = append(ks, k)
+			}
+			v = ks
+		default:
+			s.sendBack(c, op, keys, resp, []byte("-ERR Unknown subcommand or wrong number of arguments for '"+string(keys[0])+"'\r\n"))
+			return nil
+		}
+	}
+
+	buf, err := respcoding.Marshal(v)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	s.sendBack(c, op, keys, resp, buf)
+	return nil
+}
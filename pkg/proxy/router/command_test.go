@@ -0,0 +1,198 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"github.com/garyburd/redigo/redis"
+	"github.com/reborndb/reborn/pkg/models"
+	. "gopkg.in/check.v1"
+)
+
+func testBytesSlice(args ...string) [][]byte {
+	b := make([][]byte, 0, len(args))
+	for _, arg := range args {
+		b = append(b, []byte(arg))
+	}
+	return b
+}
+
+func testRouteKeys(op string, args [][]byte) [][]byte {
+	return defaultCommands.lookup(op).keys(args)
+}
+
+func (s *testProxyRouterSuite) TestRouteKeys(c *C) {
+	keys := testBytesSlice("k1", "v1", "k2", "v2")
+	c.Assert(testRouteKeys("MSET", keys), DeepEquals, testBytesSlice("k1", "k2"))
+	c.Assert(testRouteKeys("DEL", keys), DeepEquals, keys)
+	c.Assert(testRouteKeys("SET", keys[:2]), DeepEquals, keys[:1])
+	c.Assert(testRouteKeys("PING", nil), HasLen, 0)
+
+	c.Assert(testRouteKeys("SMOVE", testBytesSlice("s1", "s2", "m")), DeepEquals, testBytesSlice("s1", "s2"))
+	c.Assert(testRouteKeys("BLPOP", testBytesSlice("l1", "l2", "0")), DeepEquals, testBytesSlice("l1", "l2"))
+	c.Assert(testRouteKeys("BITOP", testBytesSlice("AND", "d", "s1", "s2")), DeepEquals, testBytesSlice("d", "s1", "s2"))
+
+	c.Assert(testRouteKeys("ZUNIONSTORE", testBytesSlice("d", "2", "z1", "z2", "WEIGHTS", "1", "2")),
+		DeepEquals, testBytesSlice("d", "z1", "z2"))
+	c.Assert(testRouteKeys("EVAL", testBytesSlice("return 1", "2", "k1", "k2", "a1")), DeepEquals, testBytesSlice("k1", "k2"))
+	c.Assert(testRouteKeys("EVAL", testBytesSlice("return 1", "0", "a1")), HasLen, 0)
+
+	// bad numkeys is replied by backend server
+	c.Assert(testRouteKeys("EVAL", testBytesSlice("return 1", "x", "k1")), HasLen, 0)
+	c.Assert(testRouteKeys("EVAL", testBytesSlice("return 1", "3", "k1")), DeepEquals, testBytesSlice("k1"))
+	c.Assert(testRouteKeys("ZUNIONSTORE", testBytesSlice("d")), DeepEquals, testBytesSlice("d"))
+
+	c.Assert(testRouteKeys("OBJECT", testBytesSlice("ENCODING", "k")), DeepEquals, testBytesSlice("k"))
+	c.Assert(testRouteKeys("SORT", testBytesSlice("k", "LIMIT", "0", "5", "store", "d")), DeepEquals, testBytesSlice("k", "d"))
+	c.Assert(testRouteKeys("GEORADIUS", testBytesSlice("k", "15", "37", "200", "km", "STORE", "d1", "STOREDIST", "d2")),
+		DeepEquals, testBytesSlice("k", "d1", "d2"))
+	c.Assert(testRouteKeys("UNKNOWNCMD", testBytesSlice("k", "v")), DeepEquals, testBytesSlice("k"))
+}
+
+func (s *testProxyRouterSuite) TestCrossSlotCommands(c *C) {
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	k1 := s.testGenKeysInSlot(c, 1, 1)[0]
+	k2 := s.testGenKeysInSlot(c, 1000, 1)[0]
+
+	_, err := cc.Do("RPUSH", k1, "a")
+	c.Assert(err, IsNil)
+
+	_, err = cc.Do("SUNIONSTORE", k1, k2)
+	c.Assert(err, ErrorMatches, "CROSSSLOT.*")
+
+	_, err = cc.Do("SMOVE", k1, k2, "a")
+	c.Assert(err, ErrorMatches, "CROSSSLOT.*")
+
+	_, err = cc.Do("RPOPLPUSH", k1, k2)
+	c.Assert(err, ErrorMatches, "CROSSSLOT.*")
+
+	_, err = cc.Do("ZUNIONSTORE", k1, 2, k1, k2)
+	c.Assert(err, ErrorMatches, "CROSSSLOT.*")
+
+	// the source list is not touched
+	n, err := redis.Int(cc.Do("LLEN", k1))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+
+	// a single key is always in one slot
+	n, err = redis.Int(cc.Do("EXISTS", k1))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+
+	_, err = cc.Do("EXISTS", k1, k2)
+	c.Assert(err, ErrorMatches, "CROSSSLOT.*")
+
+	_, err = cc.Do("DEL", k1)
+	c.Assert(err, IsNil)
+}
+
+func (s *testProxyRouterSuite) TestCommandTable(c *C) {
+	cmd := defaultCommands.lookup("GET")
+	c.Assert(cmd.readOnly(), Equals, true)
+	c.Assert(cmd.checkArity(2), Equals, true)
+	c.Assert(cmd.checkArity(3), Equals, false)
+
+	cmd = defaultCommands.lookup("MSET")
+	c.Assert(cmd.multiKey(), Equals, true)
+	c.Assert(cmd.checkArity(2), Equals, false)
+	c.Assert(cmd.checkArity(5), Equals, true)
+
+	c.Assert(defaultCommands.lookup("FLUSHALL").admin(), Equals, true)
+	c.Assert(defaultCommands.lookup("BLPOP").blocking(), Equals, true)
+	c.Assert(defaultCommands.lookup("KEYS").denied(), Equals, true)
+	c.Assert(defaultCommands.lookup("SORT").denied(), Equals, false)
+	c.Assert(defaultCommands.lookup("SCRIPT").denied(), Equals, false)
+
+	// unknown commands are allowed and not checked
+	cmd = defaultCommands.lookup("SLOTSINFO")
+	c.Assert(cmd.denied(), Equals, false)
+	c.Assert(cmd.readOnly(), Equals, false)
+	c.Assert(cmd.checkArity(1), Equals, true)
+
+	t, err := newCommandTable([]*models.Command{
+		{Name: "keys", Arity: 2, Flags: []string{models.COMMAND_FLAG_READONLY}},
+		{Name: "MYGET", Arity: 2, Flags: []string{models.COMMAND_FLAG_READONLY}, FirstKey: 1, LastKey: 1, Step: 1},
+		{Name: "GET", Arity: 2, Flags: []string{models.COMMAND_FLAG_DENIED}, FirstKey: 1, LastKey: 1, Step: 1},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(t.lookup("KEYS").denied(), Equals, false)
+	c.Assert(t.lookup("MYGET").readOnly(), Equals, true)
+	c.Assert(t.lookup("GET").denied(), Equals, true)
+
+	// the default table is not changed
+	c.Assert(defaultCommands.lookup("GET").denied(), Equals, false)
+
+	_, err = newCommandTable([]*models.Command{{Name: "GET", Flags: []string{"bad"}}})
+	c.Assert(err, NotNil)
+
+	_, err = newCommandTable([]*models.Command{{Name: "GET", FirstKey: 1, LastKey: 1}})
+	c.Assert(err, NotNil)
+}
+
+func (s *testProxyRouterSuite) TestCommandCommand(c *C) {
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	n, err := redis.Int(cc.Do("COMMAND", "COUNT"))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, len(defaultCommands))
+
+	cmds, err := redis.Values(cc.Do("COMMAND"))
+	c.Assert(err, IsNil)
+	c.Assert(cmds, HasLen, len(defaultCommands))
+
+	infos, err := redis.Values(cc.Do("COMMAND", "INFO", "get", "nosuchcommand"))
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 2)
+	c.Assert(infos[1], IsNil)
+
+	info, err := redis.Values(infos[0], nil)
+	c.Assert(err, IsNil)
+	c.Assert(info, HasLen, 6)
+	c.Assert(string(info[0].([]byte)), Equals, "get")
+	c.Assert(info[1], Equals, int64(2))
+	c.Assert(info[2], DeepEquals, []interface{}{"readonly"})
+	c.Assert(info[3:], DeepEquals, []interface{}{int64(1), int64(1), int64(1)})
+
+	keys, err := redis.Strings(cc.Do("COMMAND", "GETKEYS", "EVAL", "return 1", 2, "k1", "k2", "a1"))
+	c.Assert(err, IsNil)
+	c.Assert(keys, DeepEquals, []string{"k1", "k2"})
+
+	_, err = cc.Do("COMMAND", "GETKEYS", "GET")
+	c.Assert(err, ErrorMatches, "ERR Invalid command specified")
+
+	_, err = cc.Do("GET")
+	c.Assert(err, ErrorMatches, "ERR wrong number of arguments for 'get' command")
+}
+
+func (s *testProxyRouterSuite) TestCommandOverrides(c *C) {
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	_, err := cc.Do("SET", "foo", "bar")
+	c.Assert(err, IsNil)
+
+	err = models.SetCommands(conn, conf.ProductName, []*models.Command{
+		{Name: "get", Arity: 2, Flags: []string{models.COMMAND_FLAG_DENIED}, FirstKey: 1, LastKey: 1, Step: 1},
+	})
+	c.Assert(err, IsNil)
+
+	// denied command closes the connection
+	_, err = cc.Do("GET", "foo")
+	c.Assert(err, ErrorMatches, "GET not allowed")
+
+	err = models.SetCommands(conn, conf.ProductName, nil)
+	c.Assert(err, IsNil)
+
+	cc2 := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc2.Close()
+
+	v, err := redis.String(cc2.Do("GET", "foo"))
+	c.Assert(err, IsNil)
+	c.Assert(v, Equals, "bar")
+
+	_, err = cc2.Do("DEL", "foo")
+	c.Assert(err, IsNil)
+}
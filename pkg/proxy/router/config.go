@@ -8,10 +8,18 @@ import (
 	"strings"
 
 	"github.com/ngaut/log"
+	"github.com/reborndb/reborn/pkg/proxy/group"
 	"github.com/reborndb/reborn/pkg/proxy/router/topology"
 	"github.com/reborndb/reborn/pkg/utils"
 )
 
+// backend connection policies, requests of the same slot keep their order
+// with slot policy, and requests of the same client with session policy.
+const (
+	BackendConnPolicySlot    = "slot"
+	BackendConnPolicySession = "session"
+)
+
 type Conf struct {
 	ProductName     string
 	NetTimeout      int    //seconds
@@ -27,11 +35,50 @@ type Conf struct {
 	// for client <-> proxy
 	ProxyAuth string
 
+	// tls of the client listener, disabled if the certificate is empty,
+	// the client certificates are verified if the client CA is set
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+
+	// for destructive admin commands like FLUSHALL and FLUSHDB,
+	// these commands are disabled if empty
+	AdminAuth string
+
 	// for proxy <-> server(redis/qdb)
 	// if you want to use auth, you must be sure that
 	// all the backend servers have the same auth
 	StoreAuth string
 
+	// which server in a group serves read-only commands,
+	// can be master, prefer_slave, round_robin or nearest
+	ReadPolicy string
+
+	// pipelined connections to every backend server, requests are
+	// assigned to them by slot or by session, see BackendConnPolicy
+	BackendConns      int
+	BackendConnPolicy string
+
+	// requests of a pre_migrate slot are buffered until the slot changes,
+	// the client gets TRYAGAIN if the buffer of the slot is full or the
+	// request is buffered longer than the timeout
+	PreMigrateBufferSize int
+	PreMigrateTimeoutMs  int
+
+	// requests slower than the threshold are kept in the slow log of at
+	// most SlowlogMaxLen entries, the slow log is disabled if negative
+	SlowlogSlowerThanUs int
+	SlowlogMaxLen       int
+
+	// client connections over MaxClients in total or MaxClientsPerIP from
+	// an ip are rejected, sessions idle for IdleTimeoutSec are closed, and
+	// requests are shed if the queue of their dispatcher or task runner is
+	// longer than OverloadQueueLen, 0 means no limit for all of them
+	MaxClients       int
+	MaxClientsPerIP  int
+	IdleTimeoutSec   int
+	OverloadQueueLen int
+
 	// unexport
 	f topology.CoordFactory
 }
@@ -61,6 +108,42 @@ func LoadConf(configFile string) (*Conf, error) {
 	srvConf.CoordinatorAddr = strings.TrimSpace(srvConf.CoordinatorAddr)
 	srvConf.Coordinator, _ = conf.ReadString("coordinator", "zookeeper")
 	srvConf.StoreAuth, _ = conf.ReadString("store_auth", "")
+	srvConf.ReadPolicy, _ = conf.ReadString("read_policy", group.ReadPolicyMaster)
+	if !group.ValidReadPolicy(srvConf.ReadPolicy) {
+		log.Fatalf("invalid config: unknown read_policy %s in %s", srvConf.ReadPolicy, configFile)
+	}
+
+	srvConf.BackendConns, _ = conf.ReadInt("backend_conns", 1)
+	if srvConf.BackendConns <= 0 {
+		log.Fatalf("invalid config: backend_conns %d in %s", srvConf.BackendConns, configFile)
+	}
+	srvConf.BackendConnPolicy, _ = conf.ReadString("backend_conn_policy", BackendConnPolicySlot)
+	if srvConf.BackendConnPolicy != BackendConnPolicySlot && srvConf.BackendConnPolicy != BackendConnPolicySession {
+		log.Fatalf("invalid config: unknown backend_conn_policy %s in %s", srvConf.BackendConnPolicy, configFile)
+	}
+
+	srvConf.PreMigrateBufferSize, _ = conf.ReadInt("premigrate_buffer_size", DefaultPreMigrateBufferSize)
+	srvConf.PreMigrateTimeoutMs, _ = conf.ReadInt("premigrate_timeout_ms", DefaultPreMigrateTimeoutMs)
+	if srvConf.PreMigrateBufferSize <= 0 || srvConf.PreMigrateTimeoutMs <= 0 {
+		log.Fatalf("invalid config: premigrate_buffer_size %d, premigrate_timeout_ms %d in %s",
+			srvConf.PreMigrateBufferSize, srvConf.PreMigrateTimeoutMs, configFile)
+	}
+
+	srvConf.SlowlogSlowerThanUs, _ = conf.ReadInt("slowlog_log_slower_than", DefaultSlowlogSlowerThanUs)
+	srvConf.SlowlogMaxLen, _ = conf.ReadInt("slowlog_max_len", DefaultSlowlogMaxLen)
+	if srvConf.SlowlogMaxLen <= 0 {
+		log.Fatalf("invalid config: slowlog_max_len %d in %s", srvConf.SlowlogMaxLen, configFile)
+	}
+
+	srvConf.MaxClients, _ = conf.ReadInt("max_clients", 0)
+	srvConf.MaxClientsPerIP, _ = conf.ReadInt("max_clients_per_ip", 0)
+	srvConf.IdleTimeoutSec, _ = conf.ReadInt("idle_timeout", 0)
+	srvConf.OverloadQueueLen, _ = conf.ReadInt("overload_queue_len", 0)
+	if srvConf.MaxClients < 0 || srvConf.MaxClientsPerIP < 0 || srvConf.IdleTimeoutSec < 0 ||
+		srvConf.OverloadQueueLen < 0 {
+		log.Fatalf("invalid config: max_clients %d, max_clients_per_ip %d, idle_timeout %d, overload_queue_len %d in %s",
+			srvConf.MaxClients, srvConf.MaxClientsPerIP, srvConf.IdleTimeoutSec, srvConf.OverloadQueueLen, configFile)
+	}
 
 	// below configs should be set from command flag. We will remove below code later.
 	srvConf.NetTimeout, _ = conf.ReadInt("net_timeout", 5)
@@ -71,7 +154,17 @@ func LoadConf(configFile string) (*Conf, error) {
 	srvConf.ProxyID, _ = conf.ReadString("proxy_id", "")
 	srvConf.PidFile, _ = conf.ReadString("pidfile", "")
 
+	srvConf.TLSCertFile, _ = conf.ReadString("tls_cert_file", "")
+	srvConf.TLSKeyFile, _ = conf.ReadString("tls_key_file", "")
+	srvConf.TLSClientCAFile, _ = conf.ReadString("tls_client_ca_file", "")
+	if (len(srvConf.TLSCertFile) == 0) != (len(srvConf.TLSKeyFile) == 0) ||
+		(len(srvConf.TLSClientCAFile) > 0 && len(srvConf.TLSCertFile) == 0) {
+		log.Fatalf("invalid config: tls_cert_file %q, tls_key_file %q, tls_client_ca_file %q in %s",
+			srvConf.TLSCertFile, srvConf.TLSKeyFile, srvConf.TLSClientCAFile, configFile)
+	}
+
 	srvConf.ProxyAuth, _ = conf.ReadString("proxy_auth", "")
+	srvConf.AdminAuth, _ = conf.ReadString("admin_auth", "")
 
 	return srvConf, nil
 }
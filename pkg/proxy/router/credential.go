@@ -0,0 +1,140 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	"github.com/reborndb/reborn/pkg/models"
+	"github.com/reborndb/reborn/pkg/proxy/group"
+	"github.com/reborndb/reborn/pkg/proxy/redisconn"
+	"github.com/reborndb/reborn/pkg/utils"
+)
+
+// groupOf returns the group of the backend in the current route table,
+// -1 if not found.
+func (s *Server) groupOf(addr string) int {
+	t, ok := s.route.Load().(*routeTable)
+	if !ok {
+		return -1
+	}
+
+	for _, slot := range t.slots {
+		if slot == nil {
+			continue
+		}
+
+		if slot.dst != nil {
+			if server := slot.dst.GetServer(addr); server != nil {
+				return server.GroupId
+			}
+		}
+
+		if slot.migrateFrom != nil {
+			if server := slot.migrateFrom.GetServer(addr); server != nil {
+				return server.GroupId
+			}
+		}
+	}
+
+	return -1
+}
+
+func (s *Server) getCredentials() *models.Credentials {
+	s.credMutex.RLock()
+	creds := s.creds
+	s.credMutex.RUnlock()
+	return creds
+}
+
+// getCredential returns the credential to connect to the backend, the store
+// auth is used before the credentials are loaded.
+func (s *Server) getCredential(addr string) *utils.Credential {
+	creds := s.getCredentials()
+	if creds == nil {
+		return &utils.Credential{Auth: s.conf.StoreAuth}
+	}
+
+	groupId := -1
+	if len(creds.Groups) > 0 {
+		groupId = s.groupOf(addr)
+	}
+
+	return creds.Get(groupId, addr)
+}
+
+func (s *Server) newPoolConn(addr string) (*redisconn.Conn, error) {
+	return newRedisConn(addr, s.conf.NetTimeout, RedisConnReaderSize, RedisConnWiterSize, s.getCredential(addr))
+}
+
+// loadCredentials applies the backend credentials of the product in
+// coordinator, the task runners and pooled connections of the backends whose
+// credential changed are closed, they reconnect with the new one on demand.
+// The current credentials are kept if failed.
+func (s *Server) loadCredentials() {
+	creds, err := s.top.GetCredentials(s.conf.StoreAuth)
+	if err != nil {
+		log.Warningf("get backend credentials failed, %v", errors.ErrorStack(err))
+		return
+	}
+
+	if err = creds.Validate(); err != nil {
+		log.Warningf("invalid backend credentials, %v", errors.ErrorStack(err))
+		return
+	}
+
+	// the backends connected now
+	addrs := make(map[string]bool)
+	s.pipeMutex.RLock()
+	for addr := range s.pipeConns {
+		addrs[addr] = true
+	}
+	s.pipeMutex.RUnlock()
+
+	if t, ok := s.route.Load().(*routeTable); ok {
+		for _, slot := range t.slots {
+			if slot == nil {
+				continue
+			}
+			for _, g := range []*group.Group{slot.dst, slot.migrateFrom} {
+				if g != nil {
+					addrs[g.Master()] = true
+					for _, addr := range g.Slaves() {
+						addrs[addr] = true
+					}
+				}
+			}
+		}
+	}
+
+	changed := make(map[string]bool)
+	for addr := range addrs {
+		old := s.getCredential(addr)
+
+		groupId := s.groupOf(addr)
+		if !old.Equal(creds.Get(groupId, addr)) {
+			changed[addr] = true
+		}
+	}
+
+	s.credMutex.Lock()
+	s.creds = creds
+	s.credMutex.Unlock()
+
+	log.Infof("load backend credentials %v, %d backends changed", creds, len(changed))
+	if len(changed) == 0 {
+		return
+	}
+
+	s.counter.Add("CredentialsChanged", 1)
+
+	s.stopTaskRunners(changed)
+	for addr := range changed {
+		s.pools.ClosePool(addr)
+		s.blockPools.ClosePool(addr)
+
+		// the failures with the former credential do not count
+		s.getBreaker(addr).reconnected()
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"github.com/garyburd/redigo/redis"
+	"github.com/reborndb/reborn/pkg/models"
+	"github.com/reborndb/reborn/pkg/utils"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testProxyRouterSuite) TestBackendCredentials(c *C) {
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	key1 := s.testGenKeysInSlot(c, 1, 1)[0]
+	key2 := s.testGenKeysInSlot(c, 600, 1)[0]
+
+	_, err := cc.Do("SET", key1, "a")
+	c.Assert(err, IsNil)
+
+	c.Assert(ss.groupOf(s.s1.addr), Equals, 1)
+	c.Assert(ss.groupOf(s.s2.addr), Equals, 2)
+
+	changed := ss.counter.Counts()["CredentialsChanged"]
+
+	// the proxy answers the action after the credentials are applied
+	creds := &models.Credentials{
+		Default: &utils.Credential{Auth: storeAuth},
+		Groups:  map[int]*utils.Credential{1: {Auth: storeAuth, TLS: true, TLSSkipVerify: true}},
+	}
+	err = models.SetCredentials(conn, conf.ProductName, creds)
+	c.Assert(err, IsNil)
+	c.Assert(ss.counter.Counts()["CredentialsChanged"], Equals, changed+1)
+	c.Assert(ss.getCredential(s.s1.addr).TLS, Equals, true)
+	c.Assert(ss.getCredential(s.s2.addr).TLS, Equals, false)
+
+	// server1 is not tls, only the requests to it fail
+	_, err = cc.Do("GET", key1)
+	c.Assert(err, NotNil)
+	_, ok := err.(redis.Error)
+	c.Assert(ok, Equals, true)
+
+	_, err = cc.Do("SET", key2, "b")
+	c.Assert(err, IsNil)
+
+	// the server credential overrides the group one
+	creds.Servers = map[string]*utils.Credential{s.s1.addr: {Auth: storeAuth}}
+	err = models.SetCredentials(conn, conf.ProductName, creds)
+	c.Assert(err, IsNil)
+	c.Assert(ss.counter.Counts()["CredentialsChanged"], Equals, changed+2)
+
+	v, err := redis.String(cc.Do("GET", key1))
+	c.Assert(err, IsNil)
+	c.Assert(v, Equals, "a")
+
+	// nothing changed for the connected backends
+	creds.Groups = nil
+	err = models.SetCredentials(conn, conf.ProductName, creds)
+	c.Assert(err, IsNil)
+	c.Assert(ss.counter.Counts()["CredentialsChanged"], Equals, changed+2)
+
+	err = models.SetCredentials(conn, conf.ProductName, &models.Credentials{})
+	c.Assert(err, IsNil)
+
+	s.s1.store.Reset()
+	s.s2.store.Reset()
+}
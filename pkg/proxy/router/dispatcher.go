@@ -0,0 +1,397 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	"github.com/reborndb/reborn/pkg/models"
+	"github.com/reborndb/reborn/pkg/proxy/parser"
+)
+
+const (
+	DefaultPreMigrateBufferSize = 1000
+	DefaultPreMigrateTimeoutMs  = 3000
+
+	// how often the dispatcher checks the deadlines of the buffered requests
+	preMigrateCheckInterval = 100 * time.Millisecond
+)
+
+// errRouteChanged is replied to a batched request whose slots are not online
+// in one group any more.
+var errRouteChanged = errors.New("route of batched slots changed")
+
+// routeTable is an immutable snapshot of the slots. The topology loop changes
+// its own copy of the slots, then publishes a new table with the next epoch.
+type routeTable struct {
+	epoch int64
+	slots []*Slot
+}
+
+// epochEvent asks a dispatcher to move to the current route table,
+// wg is done after all requests received before are dispatched.
+type epochEvent struct {
+	wg *sync.WaitGroup
+}
+
+// bufferedRequest is a request of a pre_migrate slot waiting for the slot
+// to change, the client gets TRYAGAIN after the deadline.
+type bufferedRequest struct {
+	r        *PipelineRequest
+	deadline time.Time
+}
+
+// dispatcher routes the requests of the slots which are id modulo the
+// number of dispatchers. The requests of a slot are always dispatched by
+// the same dispatcher in order, and the requests of a pre_migrate slot are
+// buffered until the slot changes.
+type dispatcher struct {
+	s     *Server
+	id    int
+	reqCh chan interface{} // *PipelineRequest or *epochEvent
+
+	bufferedReq map[int]*list.List // slot -> *bufferedRequest in order
+	bufferedNum int
+	ticker      *time.Ticker // only runs while any request is buffered
+
+	// requests waiting for their keys migrated by the migrating slot, they
+	// must be sent before the dispatcher moves to a table the slot changed in
+	migrating map[*Slot]*sync.WaitGroup
+}
+
+func newDispatcher(s *Server, id int) *dispatcher {
+	return &dispatcher{
+		s:           s,
+		id:          id,
+		reqCh:       make(chan interface{}, PipelineRequestNum),
+		bufferedReq: make(map[int]*list.List),
+		migrating:   make(map[*Slot]*sync.WaitGroup),
+	}
+}
+
+// tryAgain replies TRYAGAIN to the client, which can retry the request later.
+func tryAgain(r *PipelineRequest, reason string) {
+	resp, err := parser.Parse(bufio.NewReader(bytes.NewReader([]byte("-TRYAGAIN " + reason + "\r\n"))))
+	r.backQ <- &PipelineResponse{ctx: r, resp: resp, err: err}
+}
+
+func (d *dispatcher) dispatch(t *routeTable, r *PipelineRequest) (success bool) {
+	s := d.s
+	slot := t.slots[r.slotIdx]
+	if slot == nil {
+		r.backQ <- &PipelineResponse{ctx: r, resp: nil, err: errors.Errorf("slot %d is not ready", r.slotIdx)}
+		return true
+	}
+
+	slotStatus := slot.slotInfo.State.Status
+	if slotStatus != models.SLOT_STATUS_ONLINE && slotStatus != models.SLOT_STATUS_MIGRATE {
+		return false
+	}
+
+	if slotStatus == models.SLOT_STATUS_MIGRATE && len(r.keys) > 0 {
+		calls := s.migrateKeys(slot, r.keys)
+		wg, ok := d.migrating[slot]
+		if !ok {
+			wg = &sync.WaitGroup{}
+			d.migrating[slot] = wg
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, call := range calls {
+				<-call.done
+				if call.err != nil {
+					r.backQ <- &PipelineResponse{ctx: r, resp: nil, err: call.err}
+					return
+				}
+			}
+			s.forward(slot, r)
+		}()
+		return true
+	}
+
+	s.forward(slot, r)
+	return true
+}
+
+// forward sends the request to the backend of the slot.
+func (s *Server) forward(slot *Slot, r *PipelineRequest) {
+	if r.direct != nil {
+		go r.direct(slot.dst.Master())
+		return
+	}
+
+	addr := s.getBackend(slot, r)
+	i := s.taskRunnerIndex(r)
+	err := s.sendToBackend(addr, i, r)
+	if err != nil && addr != slot.dst.Master() {
+		log.Warningf("read from slave %s failed, fallback to master %s, %v", addr, slot.dst.Master(), err)
+		s.counter.Add("SlaveFallback", 1)
+		err = s.sendToBackend(slot.dst.Master(), i, r)
+	}
+
+	if err != nil {
+		r.backQ <- &PipelineResponse{ctx: r, resp: nil, err: err}
+	}
+}
+
+// buffer keeps the request of a pre_migrate slot, the client gets TRYAGAIN
+// at once if the buffer of the slot is full.
+func (d *dispatcher) buffer(r *PipelineRequest) {
+	s := d.s
+	l, ok := d.bufferedReq[r.slotIdx]
+	if !ok {
+		l = list.New()
+		d.bufferedReq[r.slotIdx] = l
+	}
+
+	if l.Len() >= s.conf.PreMigrateBufferSize {
+		s.counter.Add("PreMigrateOverflow", 1)
+		tryAgain(r, "slot is migrating, buffer is full")
+		return
+	}
+
+	timeout := time.Duration(s.conf.PreMigrateTimeoutMs) * time.Millisecond
+	l.PushBack(&bufferedRequest{r: r, deadline: time.Now().Add(timeout)})
+	d.bufferedNum++
+	s.counter.Add("PreMigrateBuffered", 1)
+	s.counter.Add("PreMigrateBuffering", 1)
+
+	if d.ticker == nil {
+		d.ticker = time.NewTicker(preMigrateCheckInterval)
+	}
+}
+
+// removeBuffered removes the buffered request e of the slot.
+func (d *dispatcher) removeBuffered(slot int, l *list.List, e *list.Element) {
+	l.Remove(e)
+	if l.Len() == 0 {
+		delete(d.bufferedReq, slot)
+	}
+
+	d.bufferedNum--
+	d.s.counter.Add("PreMigrateBuffering", -1)
+
+	if d.bufferedNum == 0 && d.ticker != nil {
+		d.ticker.Stop()
+		d.ticker = nil
+	}
+}
+
+// dispatchSlotBuffered retries the buffered requests of the slot in order,
+// they are all kept if the slot is still not ready.
+func (d *dispatcher) dispatchSlotBuffered(t *routeTable, slot int) {
+	l, ok := d.bufferedReq[slot]
+	if !ok {
+		return
+	}
+
+	for e := l.Front(); e != nil; {
+		next := e.Next()
+		if !d.dispatch(t, e.Value.(*bufferedRequest).r) {
+			return
+		}
+		d.removeBuffered(slot, l, e)
+		e = next
+	}
+}
+
+// dispatchBuffered retries the buffered requests of all slots.
+func (d *dispatcher) dispatchBuffered(t *routeTable) {
+	for slot, _ := range d.bufferedReq {
+		d.dispatchSlotBuffered(t, slot)
+	}
+}
+
+// expireBuffered replies TRYAGAIN to the requests buffered too long.
+func (d *dispatcher) expireBuffered(now time.Time) {
+	for slot, l := range d.bufferedReq {
+		for e := l.Front(); e != nil; {
+			next := e.Next()
+			br := e.Value.(*bufferedRequest)
+			if br.deadline.After(now) {
+				break
+			}
+
+			d.removeBuffered(slot, l, e)
+			d.s.counter.Add("PreMigrateTimeout", 1)
+			tryAgain(br.r, "slot is migrating, buffer timeout")
+			e = next
+		}
+	}
+}
+
+// isBatchRoutable returns whether all the batched slots are online in the same
+// group, otherwise the request must be split by slots, e.g. some are migrating.
+func isBatchRoutable(t *routeTable, slots []int) bool {
+	first := t.slots[slots[0]]
+	for _, i := range slots {
+		slot := t.slots[i]
+		if slot == nil || slot.slotInfo.State.Status != models.SLOT_STATUS_ONLINE {
+			return false
+		}
+
+		if slot.slotInfo.GroupId != first.slotInfo.GroupId || slot.dst.Master() != first.dst.Master() {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (d *dispatcher) handleRequest(r *PipelineRequest) {
+	t := d.s.getRouteTable()
+	if len(r.batchSlots) > 0 && !isBatchRoutable(t, r.batchSlots) {
+		d.s.counter.Add("BatchSplit", 1)
+		r.backQ <- &PipelineResponse{ctx: r, resp: nil, err: errors.Trace(errRouteChanged)}
+		return
+	}
+
+	if slot := t.slots[r.slotIdx]; slot != nil && slot.slotInfo.State.Status == models.SLOT_STATUS_PRE_MIGRATE {
+		d.buffer(r)
+		return
+	}
+
+	// the table may be changed before the epoch event arrives
+	d.dispatchSlotBuffered(t, r.slotIdx)
+	if _, ok := d.bufferedReq[r.slotIdx]; ok {
+		d.buffer(r)
+		return
+	}
+
+	if !d.dispatch(t, r) {
+		log.Fatalf("should never happend, %+v, %+v", r, t.slots[r.slotIdx].slotInfo)
+	}
+}
+
+func (d *dispatcher) run() {
+	defer func() {
+		if d.ticker != nil {
+			d.ticker.Stop()
+		}
+	}()
+
+	for {
+		var tick <-chan time.Time
+		if d.ticker != nil {
+			tick = d.ticker.C
+		}
+
+		select {
+		case e, ok := <-d.reqCh:
+			if !ok {
+				return
+			}
+
+			switch e.(type) {
+			case *PipelineRequest:
+				d.handleRequest(e.(*PipelineRequest))
+			case *epochEvent:
+				t := d.s.getRouteTable()
+				d.dispatchBuffered(t)
+				d.waitMigrating(t)
+				e.(*epochEvent).wg.Done()
+			}
+		case now := <-tick:
+			d.expireBuffered(now)
+		}
+	}
+}
+
+// waitMigrating waits for the requests of the migrating slots changed in the
+// table, the requests of the slots not changed keep waiting for their keys.
+func (d *dispatcher) waitMigrating(t *routeTable) {
+	for slot, wg := range d.migrating {
+		if t.slots[slot.slotInfo.Id] != slot {
+			wg.Wait()
+			delete(d.migrating, slot)
+		}
+	}
+}
+
+// sendRequest sends the request to the dispatcher of its slot.
+func (s *Server) sendRequest(r *PipelineRequest) {
+	s.dispatchers[r.slotIdx%len(s.dispatchers)].reqCh <- r
+}
+
+func (s *Server) getRouteTable() *routeTable {
+	return s.route.Load().(*routeTable)
+}
+
+// swapRouteTable publishes the slots as the route table of the next epoch,
+// and returns after every dispatcher has moved to it, so no request is
+// dispatched with the former table, which is returned, any more.
+func (s *Server) swapRouteTable() *routeTable {
+	slots := make([]*Slot, len(s.slots))
+	copy(slots, s.slots)
+
+	t := &routeTable{slots: slots}
+	old, ok := s.route.Load().(*routeTable)
+	if ok {
+		t.epoch = old.epoch + 1
+	}
+	s.route.Store(t)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(len(s.dispatchers))
+	for _, d := range s.dispatchers {
+		d.reqCh <- &epochEvent{wg: wg}
+	}
+	wg.Wait()
+
+	log.Infof("route table epoch %d", t.epoch)
+	return old
+}
+
+// slotBackends adds the servers which may serve the slot to addrs.
+func slotBackends(slot *Slot, addrs map[string]bool) {
+	addrs[slot.dst.Master()] = true
+	for _, addr := range slot.dst.Slaves() {
+		addrs[addr] = true
+	}
+}
+
+// backends returns the servers which may serve any slot of the table.
+func (t *routeTable) backends() map[string]bool {
+	addrs := make(map[string]bool)
+	for _, slot := range t.slots {
+		if slot != nil {
+			slotBackends(slot, addrs)
+		}
+	}
+
+	return addrs
+}
+
+// staleBackends returns the servers whose task runners must be drained after
+// the route table changed from old to cur. They are the servers of the slots
+// changed, since requests of these slots dispatched with the old table may be
+// still in flight, and the servers no longer used by any slot.
+func (s *Server) staleBackends(old *routeTable, cur *routeTable) map[string]bool {
+	addrs := make(map[string]bool)
+	if old != nil {
+		for i, slot := range old.slots {
+			if slot != nil && (i >= len(cur.slots) || cur.slots[i] != slot) {
+				slotBackends(slot, addrs)
+			}
+		}
+	}
+
+	used := cur.backends()
+	s.pipeMutex.RLock()
+	for addr, _ := range s.pipeConns {
+		if !used[addr] {
+			addrs[addr] = true
+		}
+	}
+	s.pipeMutex.RUnlock()
+
+	return addrs
+}
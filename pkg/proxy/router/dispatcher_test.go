@@ -0,0 +1,220 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"time"
+
+	stats "github.com/ngaut/gostats"
+	"github.com/reborndb/reborn/pkg/models"
+	"github.com/reborndb/reborn/pkg/proxy/group"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testProxyRouterSuite) testNewSlot(id int, status models.SlotStatus, master string) *Slot {
+	info := models.NewSlot(conf.ProductName, id)
+	info.GroupId = 1
+	info.State.Status = status
+
+	g := models.ServerGroup{
+		Id:      1,
+		Servers: []*models.Server{{Type: models.SERVER_TYPE_MASTER, GroupId: 1, Addr: master}},
+	}
+
+	return &Slot{slotInfo: info, dst: group.NewGroup(g), groupInfo: &g}
+}
+
+func (s *testProxyRouterSuite) TestDispatcherEpoch(c *C) {
+	srv := &Server{conf: &Conf{PreMigrateBufferSize: 10, PreMigrateTimeoutMs: 10000}, counter: stats.NewCounters("")}
+	for i := 0; i < 2; i++ {
+		d := newDispatcher(srv, i)
+		srv.dispatchers = append(srv.dispatchers, d)
+		go d.run()
+		defer close(d.reqCh)
+	}
+
+	srv.slots = []*Slot{
+		s.testNewSlot(0, models.SLOT_STATUS_PRE_MIGRATE, "127.0.0.1:6379"),
+		s.testNewSlot(1, models.SLOT_STATUS_ONLINE, "127.0.0.1:6380"),
+	}
+	srv.swapRouteTable()
+	c.Assert(srv.getRouteTable().epoch, Equals, int64(0))
+
+	ch := make(chan string, 2)
+	direct := func(addr string) {
+		ch <- addr
+	}
+
+	// slot 1 is not blocked by the pre_migrate slot 0 of the other dispatcher
+	srv.sendRequest(&PipelineRequest{slotIdx: 0, direct: direct})
+	srv.sendRequest(&PipelineRequest{slotIdx: 1, direct: direct})
+	c.Assert(<-ch, Equals, "127.0.0.1:6380")
+
+	select {
+	case addr := <-ch:
+		c.Fatalf("request of pre_migrate slot is dispatched to %s", addr)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the buffered request is dispatched with the new table
+	srv.slots[0] = s.testNewSlot(0, models.SLOT_STATUS_ONLINE, "127.0.0.1:6381")
+	srv.swapRouteTable()
+	c.Assert(srv.getRouteTable().epoch, Equals, int64(1))
+	c.Assert(<-ch, Equals, "127.0.0.1:6381")
+}
+
+func (s *testProxyRouterSuite) TestDispatcherMigrating(c *C) {
+	srv := &Server{conf: &Conf{}, counter: stats.NewCounters(""), migrateCounter: stats.NewCounters("")}
+	d := newDispatcher(srv, 0)
+	srv.dispatchers = append(srv.dispatchers, d)
+	go d.run()
+	defer close(d.reqCh)
+
+	// the migrator never runs, so the key is migrating until the call is done
+	m := &keyMigrator{s: srv, from: "127.0.0.1:6380", queue: make(chan *migrateCall, 1), pending: make(map[string]*migrateCall)}
+	srv.migrators = map[string]*keyMigrator{m.from: m}
+
+	slot0 := s.testNewSlot(0, models.SLOT_STATUS_MIGRATE, "127.0.0.1:6379")
+	slot0.migrateFrom = s.testNewSlot(0, models.SLOT_STATUS_ONLINE, m.from).dst
+	srv.slots = []*Slot{slot0, s.testNewSlot(1, models.SLOT_STATUS_ONLINE, "127.0.0.1:6379")}
+	srv.swapRouteTable()
+
+	ch := make(chan string, 1)
+	srv.sendRequest(&PipelineRequest{slotIdx: 0, keys: [][]byte{[]byte("foo")}, direct: func(addr string) {
+		ch <- addr
+	}})
+	call := <-m.queue
+
+	swapped := make(chan struct{})
+	swap := func() {
+		srv.swapRouteTable()
+		swapped <- struct{}{}
+	}
+
+	// slot 0 is not changed, the table is swapped without waiting for it
+	srv.slots[1] = s.testNewSlot(1, models.SLOT_STATUS_ONLINE, "127.0.0.1:6381")
+	go swap()
+	select {
+	case <-swapped:
+	case <-time.After(time.Second):
+		c.Fatalf("swap waits for the migrating slot not changed")
+	}
+
+	// slot 0 is changed, the table is swapped after the request is sent
+	srv.slots[0] = s.testNewSlot(0, models.SLOT_STATUS_ONLINE, "127.0.0.1:6379")
+	go swap()
+	select {
+	case <-swapped:
+		c.Fatalf("swap does not wait for the migrating slot changed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(call.done)
+	c.Assert(<-ch, Equals, "127.0.0.1:6379")
+	<-swapped
+}
+
+func (s *testProxyRouterSuite) TestPreMigrateBuffer(c *C) {
+	srv := &Server{conf: &Conf{PreMigrateBufferSize: 2, PreMigrateTimeoutMs: 200}, counter: stats.NewCounters("")}
+	d := newDispatcher(srv, 0)
+	srv.dispatchers = append(srv.dispatchers, d)
+	go d.run()
+	defer close(d.reqCh)
+
+	srv.slots = []*Slot{s.testNewSlot(0, models.SLOT_STATUS_PRE_MIGRATE, "127.0.0.1:6379")}
+	srv.swapRouteTable()
+
+	backQ := make(chan *PipelineResponse, 3)
+	for i := 1; i <= 3; i++ {
+		srv.sendRequest(&PipelineRequest{slotIdx: 0, seq: int64(i), backQ: backQ})
+	}
+
+	checkTryAgain := func(seq int64) {
+		select {
+		case r := <-backQ:
+			c.Assert(r.err, IsNil)
+			c.Assert(r.ctx.seq, Equals, seq)
+			c.Assert(string(r.resp.Raw), Matches, "-TRYAGAIN .*\r\n")
+		case <-time.After(time.Second):
+			c.Fatalf("request %d is not replied", seq)
+		}
+	}
+
+	// the buffer is full
+	checkTryAgain(3)
+
+	select {
+	case r := <-backQ:
+		c.Fatalf("request %d is replied before timeout", r.ctx.seq)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// buffered requests time out in order
+	checkTryAgain(1)
+	checkTryAgain(2)
+
+	counts := srv.counter.Counts()
+	c.Assert(counts["PreMigrateBuffered"], Equals, int64(2))
+	c.Assert(counts["PreMigrateBuffering"], Equals, int64(0))
+	c.Assert(counts["PreMigrateOverflow"], Equals, int64(1))
+	c.Assert(counts["PreMigrateTimeout"], Equals, int64(2))
+}
+
+func (s *testProxyRouterSuite) TestStaleBackends(c *C) {
+	srv := &Server{pipeConns: make(map[string][]*taskRunner)}
+	srv.pipeConns["127.0.0.1:6379"] = nil
+	srv.pipeConns["127.0.0.1:6380"] = nil
+	srv.pipeConns["127.0.0.1:6390"] = nil
+
+	slot0 := s.testNewSlot(0, models.SLOT_STATUS_ONLINE, "127.0.0.1:6379")
+	slot1 := s.testNewSlot(1, models.SLOT_STATUS_ONLINE, "127.0.0.1:6380")
+	old := &routeTable{slots: []*Slot{slot0, slot1}}
+
+	// nothing changed, only the unused server is stale
+	cur := &routeTable{epoch: 1, slots: []*Slot{slot0, slot1}}
+	c.Assert(srv.staleBackends(old, cur), DeepEquals, map[string]bool{"127.0.0.1:6390": true})
+
+	// slot 1 moves to 6379, the requests to 6380 must be drained
+	cur = &routeTable{epoch: 1, slots: []*Slot{slot0, s.testNewSlot(1, models.SLOT_STATUS_ONLINE, "127.0.0.1:6379")}}
+	c.Assert(srv.staleBackends(old, cur), DeepEquals, map[string]bool{"127.0.0.1:6380": true, "127.0.0.1:6390": true})
+}
+
+func (s *testProxyRouterSuite) TestTopoChangeKeepsTaskRunners(c *C) {
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	getTaskRunner := func(addr string, i int) *taskRunner {
+		ss.pipeMutex.RLock()
+		defer ss.pipeMutex.RUnlock()
+		if trs := ss.pipeConns[addr]; len(trs) > i {
+			return trs[i]
+		}
+		return nil
+	}
+
+	key := s.testGenKeysInSlot(c, 0, 1)[0]
+	_, err := cc.Do("SET", key, "bar")
+	c.Assert(err, IsNil)
+
+	_, err = cc.Do("SET", s.testGenKeysInSlot(c, 1023, 1)[0], "bar")
+	c.Assert(err, IsNil)
+
+	tr1 := getTaskRunner(s.s1.addr, 0)
+	c.Assert(tr1, NotNil)
+	tr2 := getTaskRunner(s.s2.addr, 1023%conf.BackendConns)
+	c.Assert(tr2, NotNil)
+
+	// slot 1023 of group 2 changes, task runners of group 1 keep working
+	err = models.SetSlotRange(conn, conf.ProductName, 1023, 1023, 2, models.SLOT_STATUS_ONLINE)
+	c.Assert(err, IsNil)
+
+	c.Assert(getTaskRunner(s.s1.addr, 0), Equals, tr1)
+	c.Assert(getTaskRunner(s.s2.addr, 1023%conf.BackendConns), Not(Equals), tr2)
+
+	_, err = cc.Do("SET", key, "bar")
+	c.Assert(err, IsNil)
+
+	s.s1.store.Reset()
+	s.s2.store.Reset()
+}
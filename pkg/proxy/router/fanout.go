@@ -0,0 +1,221 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	respcoding "github.com/ngaut/resp"
+	"github.com/reborndb/reborn/pkg/proxy/parser"
+)
+
+func isFanoutOp(op string) bool {
+	switch op {
+	case "DBSIZE", "INFO", "FLUSHALL", "FLUSHDB", "RANDOMKEY":
+		return true
+	}
+
+	return false
+}
+
+func (s *Server) isAdminAuth(auth []byte) bool {
+	return len(s.conf.AdminAuth) > 0 && string(auth) == s.conf.AdminAuth
+}
+
+// groupReply is the reply of a group master for a fan-out command.
+type groupReply struct {
+	id   int
+	addr string
+	resp *parser.Resp
+	err  error
+}
+
+func (r *groupReply) String() string {
+	if r.err != nil {
+		return fmt.Sprintf("group %d %s, %v", r.id, r.addr, errors.Cause(r.err))
+	}
+
+	return fmt.Sprintf("group %d %s, %s", r.id, r.addr, bytes.TrimSpace(r.resp.Raw[1:]))
+}
+
+func (r *groupReply) failed() bool {
+	return r.err != nil || r.resp.Type == parser.ErrorResp
+}
+
+// fanout sends the command to the masters in parallel, replies are sorted by group id.
+func (s *Server) fanout(masters map[int]string, cmd string, args ...interface{}) []*groupReply {
+	replies := make([]*groupReply, 0, len(masters))
+	for id, addr := range masters {
+		replies = append(replies, &groupReply{id: id, addr: addr})
+	}
+	sort.Sort(groupReplySorter(replies))
+
+	wg := &sync.WaitGroup{}
+	wg.Add(len(replies))
+	for _, r := range replies {
+		go func(r *groupReply) {
+			defer wg.Done()
+			r.resp, r.err = s.doBackendCommand(r.addr, cmd, args...)
+			if r.err != nil {
+				log.Warningf("%s on group %d %s failed, %v", cmd, r.id, r.addr, errors.ErrorStack(r.err))
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	return replies
+}
+
+type groupReplySorter []*groupReply
+
+func (s groupReplySorter) Len() int           { return len(s) }
+func (s groupReplySorter) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s groupReplySorter) Less(i, j int) bool { return s[i].id < s[j].id }
+
+// doBackendCommand sends one command to the backend server with a pooled connection.
+func (s *Server) doBackendCommand(addr string, cmd string, args ...interface{}) (*parser.Resp, error) {
+	conn, err := s.pools.GetConn(addr)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	err = writeCommand(conn, cmd, args...)
+	if err == nil {
+		err = conn.Flush()
+	}
+
+	var resp *parser.Resp
+	if err == nil {
+		err = conn.SetReadDeadline(time.Now().Add(time.Duration(s.conf.NetTimeout) * time.Second))
+	}
+	if err == nil {
+		resp, err = parser.Parse(conn.BufioReader())
+	}
+
+	if err != nil {
+		conn.Close()
+	}
+	s.pools.PutConn(conn)
+
+	return resp, errors.Trace(err)
+}
+
+func (s *Server) handleFanoutCommand(c *session, opstr string, op []byte, keys [][]byte, resp *parser.Resp) error {
+	var args []interface{}
+	for _, v := range resp.Multi[1:] {
+		args = append(args, v.Value())
+	}
+
+	masters := s.getGroupMasters()
+	if len(masters) == 0 {
+		s.sendBack(c, op, keys, resp, []byte("-ERR no server group\r\n"))
+		return nil
+	}
+
+	var buf []byte
+	var err error
+	switch opstr {
+	case "RANDOMKEY":
+		buf, err = s.randomKey(masters)
+	case "DBSIZE":
+		buf, err = mergeDBSize(s.fanout(masters, opstr, args...))
+	case "INFO":
+		buf, err = mergeInfo(s.fanout(masters, opstr, args...))
+	default:
+		log.Warningf("%s from %s", opstr, c.RemoteAddr())
+		buf, err = mergeOK(s.fanout(masters, opstr, args...))
+	}
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	s.sendBack(c, op, keys, resp, buf)
+	return nil
+}
+
+// randomKey asks the groups in random order until a key is found.
+func (s *Server) randomKey(masters map[int]string) ([]byte, error) {
+	ids := make([]int, 0, len(masters))
+	for id, _ := range masters {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	for _, i := range rand.Perm(len(ids)) {
+		r := &groupReply{id: ids[i], addr: masters[ids[i]]}
+		r.resp, r.err = s.doBackendCommand(r.addr, "RANDOMKEY")
+		if r.failed() {
+			return errorReply(r), nil
+		}
+
+		// nil bulk string, the group is empty
+		if r.resp.Type == parser.BulkResp && bytes.HasPrefix(r.resp.Raw, []byte("$-1")) {
+			continue
+		}
+
+		return r.resp.Bytes()
+	}
+
+	return []byte("$-1\r\n"), nil
+}
+
+func errorReply(r *groupReply) []byte {
+	return []byte("-ERR " + r.String() + "\r\n")
+}
+
+func mergeDBSize(replies []*groupReply) ([]byte, error) {
+	var total int64
+	for _, r := range replies {
+		if r.failed() {
+			return errorReply(r), nil
+		}
+
+		n, err := strconv.ParseInt(string(bytes.TrimSpace(r.resp.Raw[1:])), 10, 64)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		total += n
+	}
+
+	return []byte(fmt.Sprintf(":%d\r\n", total)), nil
+}
+
+// mergeInfo puts the info of every group into its own section.
+func mergeInfo(replies []*groupReply) ([]byte, error) {
+	var b bytes.Buffer
+	for _, r := range replies {
+		if r.failed() {
+			return errorReply(r), nil
+		}
+
+		fmt.Fprintf(&b, "# Group_%d\r\naddr:%s\r\n", r.id, r.addr)
+		info := bytes.TrimSpace(r.resp.Value())
+		if len(info) > 0 {
+			b.Write(info)
+			b.WriteString("\r\n")
+		}
+		b.WriteString("\r\n")
+	}
+
+	return respcoding.Marshal(b.Bytes())
+}
+
+func mergeOK(replies []*groupReply) ([]byte, error) {
+	for _, r := range replies {
+		if r.failed() {
+			return errorReply(r), nil
+		}
+	}
+
+	return OK_BYTES, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"bufio"
+	"bytes"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/juju/errors"
+	"github.com/reborndb/reborn/pkg/models"
+	"github.com/reborndb/reborn/pkg/proxy/group"
+	"github.com/reborndb/reborn/pkg/proxy/parser"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testProxyRouterSuite) testGroupReply(c *C, id int, raw string) *groupReply {
+	resp, err := parser.Parse(bufio.NewReader(bytes.NewBufferString(raw)))
+	c.Assert(err, IsNil)
+	return &groupReply{id: id, addr: "127.0.0.1:6379", resp: resp}
+}
+
+func (s *testProxyRouterSuite) TestMergeFanoutReplies(c *C) {
+	buf, err := mergeDBSize([]*groupReply{s.testGroupReply(c, 1, ":10\r\n"), s.testGroupReply(c, 2, ":5\r\n")})
+	c.Assert(err, IsNil)
+	c.Assert(string(buf), Equals, ":15\r\n")
+
+	buf, err = mergeDBSize([]*groupReply{s.testGroupReply(c, 1, ":10\r\n"), s.testGroupReply(c, 2, "-ERR unknown\r\n")})
+	c.Assert(err, IsNil)
+	c.Assert(string(buf), Equals, "-ERR group 2 127.0.0.1:6379, ERR unknown\r\n")
+
+	buf, err = mergeOK([]*groupReply{s.testGroupReply(c, 1, "+OK\r\n"), {id: 2, addr: "127.0.0.1:6379", err: errors.New("EOF")}})
+	c.Assert(err, IsNil)
+	c.Assert(string(buf), Equals, "-ERR group 2 127.0.0.1:6379, EOF\r\n")
+
+	buf, err = mergeInfo([]*groupReply{s.testGroupReply(c, 1, "$15\r\n# Server\r\na:1\r\n\r\n")})
+	c.Assert(err, IsNil)
+	c.Assert(string(buf), Equals, "$49\r\n# Group_1\r\naddr:127.0.0.1:6379\r\n# Server\r\na:1\r\n\r\n\r\n")
+}
+
+func (s *testProxyRouterSuite) TestGetGroupMasters(c *C) {
+	srv := &Server{}
+	srv.route.Store(&routeTable{})
+	c.Assert(srv.getGroupMasters(), HasLen, 0)
+
+	migrating := s.testNewSlot(1, models.SLOT_STATUS_MIGRATE, "127.0.0.1:6379")
+	migrating.slotInfo.GroupId = 3
+	migrating.slotInfo.State.MigrateStatus.From = 2
+	migrating.migrateFrom = group.NewGroup(models.ServerGroup{
+		Id:      2,
+		Servers: []*models.Server{{Type: models.SERVER_TYPE_MASTER, GroupId: 2, Addr: "127.0.0.1:6380"}},
+	})
+
+	srv.route.Store(&routeTable{slots: []*Slot{s.testNewSlot(0, models.SLOT_STATUS_ONLINE, "127.0.0.1:6381"), migrating, nil}})
+	c.Assert(srv.getGroupMasters(), DeepEquals, map[int]string{1: "127.0.0.1:6381", 2: "127.0.0.1:6380", 3: "127.0.0.1:6379"})
+}
+
+func (s *testProxyRouterSuite) TestFanoutCommands(c *C) {
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	info, err := redis.String(cc.Do("INFO"))
+	c.Assert(err, IsNil)
+	c.Assert(info, Matches, "(?s)# Group_1\r\naddr:"+s.s1.addr+"\r\n.*# Group_2\r\naddr:"+s.s2.addr+"\r\n.*")
+
+	// admin auth is not set
+	_, err = cc.Do("FLUSHALL")
+	c.Assert(err, ErrorMatches, "ERR FLUSHALL is disabled, admin auth is not set")
+
+	conf.AdminAuth = "admin"
+	defer func() {
+		conf.AdminAuth = ""
+	}()
+
+	_, err = cc.Do("FLUSHALL")
+	c.Assert(err, ErrorMatches, "ERR FLUSHALL requires admin auth")
+
+	_, err = cc.Do("SET", "foo", "bar")
+	c.Assert(err, IsNil)
+
+	// admin commands queued in MULTI are checked too
+	_, err = cc.Do("MULTI")
+	c.Assert(err, IsNil)
+
+	_, err = cc.Do("FLUSHALL")
+	c.Assert(err, ErrorMatches, "ERR FLUSHALL requires admin auth")
+
+	_, err = cc.Do("EXEC")
+	c.Assert(err, ErrorMatches, "EXECABORT.*")
+
+	got, err := redis.String(cc.Do("GET", "foo"))
+	c.Assert(err, IsNil)
+	c.Assert(got, Equals, "bar")
+
+	_, err = cc.Do("AUTH", "admin")
+	c.Assert(err, IsNil)
+
+	ok, err := redis.String(cc.Do("FLUSHALL"))
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, "OK")
+
+	v, err := cc.Do("GET", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(v, IsNil)
+}
@@ -9,6 +9,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
+	"os"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -25,31 +27,13 @@ import (
 	"github.com/reborndb/reborn/pkg/proxy/redisconn"
 )
 
-var blackList = []string{
-	"KEYS", "MOVE", "OBJECT", "RENAME", "RENAMENX", "SORT", "SCAN", "BITOP" /*"MGET",*/ /* "MSET",*/, "MSETNX", "SCAN",
-	"BLPOP", "BRPOP", "BRPOPLPUSH", "PSUBSCRIBE，PUBLISH", "PUNSUBSCRIBE", "SUBSCRIBE", "RANDOMKEY",
-	"UNSUBSCRIBE", "DISCARD", "EXEC", "MULTI", "UNWATCH", "WATCH", "SCRIPT EXISTS", "SCRIPT FLUSH", "SCRIPT KILL",
-	"SCRIPT LOAD" /*, "AUTH" , "ECHO"*/ /*"QUIT",*/ /*"SELECT",*/, "BGREWRITEAOF", "BGSAVE", "CLIENT KILL", "CLIENT LIST",
-	"CONFIG GET", "CONFIG SET", "CONFIG RESETSTAT", "DBSIZE", "DEBUG OBJECT", "DEBUG SEGFAULT", "FLUSHALL", "FLUSHDB",
-	"LASTSAVE", "MONITOR", "SAVE", "SHUTDOWN", "SLAVEOF", "SLOWLOG", "SYNC", "TIME", "SLOTSMGRTONE", "SLOTSMGRT",
-	"SLOTSDEL",
-}
-
 var (
-	blackListCommand = make(map[string]struct{})
-	OK_BYTES         = []byte("+OK\r\n")
-)
-
-func init() {
-	for _, k := range blackList {
-		blackListCommand[k] = struct{}{}
-	}
-}
+	OK_BYTES        = []byte("+OK\r\n")
+	CROSSSLOT_BYTES = []byte("-CROSSSLOT Keys in request don't hash to the same slot\r\n")
 
-func allowOp(op string) bool {
-	_, black := blackListCommand[op]
-	return !black
-}
+	MAXCLIENTS_BYTES = []byte("-ERR max number of clients reached\r\n")
+	OVERLOADED_BYTES = []byte("-TRYAGAIN proxy is overloaded\r\n")
+)
 
 func isMulOp(op string) bool {
 	if op == "MGET" || op == "DEL" || op == "MSET" {
@@ -60,7 +44,7 @@ func isMulOp(op string) bool {
 }
 
 func validSlot(i int) bool {
-	if i < 0 || i >= models.DEFAULT_SLOT_NUM {
+	if i < 0 || i >= slotNum() {
 		return false
 	}
 
@@ -220,16 +204,20 @@ func getRespOpKeys(c *session) (*parser.Resp, []byte, [][]byte, error) {
 	return resp, op, keys, nil
 }
 
-func filter(opstr string, keys [][]byte, c *session, timeoutSec int) (rawresp []byte, next bool, err error) {
-	if !allowOp(opstr) {
-		errmsg, err := respcoding.Marshal(fmt.Errorf("%s not allowed", opstr))
+func filter(cmd *commandInfo, keys [][]byte, nargs int, timeoutSec int) (rawresp []byte, next bool, err error) {
+	if cmd.denied() {
+		errmsg, err := respcoding.Marshal(fmt.Errorf("%s not allowed", cmd.name))
 		if err != nil {
-			log.Fatal("should never happend", opstr)
+			log.Fatal("should never happend", cmd.name)
 		}
 		return errmsg, false, errors.New(string(errmsg))
 	}
 
-	buf, shouldClose, handled, err := handleSpecCommand(opstr, keys, timeoutSec)
+	if !cmd.checkArity(nargs) {
+		return []byte("-ERR wrong number of arguments for '" + strings.ToLower(cmd.name) + "' command\r\n"), false, nil
+	}
+
+	buf, shouldClose, handled, err := handleSpecCommand(cmd.name, keys, timeoutSec)
 	if shouldClose { //quit command
 		return buf, false, errors.Trace(io.EOF)
 	}
@@ -248,6 +236,33 @@ func GetEventPath(evt interface{}) string {
 	return evt.(topo.Event).Path
 }
 
+// getLocalHosts returns the host name and all interface addresses of this machine,
+// it is used by nearest read policy to find the servers on the same host.
+func getLocalHosts() map[string]struct{} {
+	hosts := map[string]struct{}{
+		"localhost": struct{}{},
+		"127.0.0.1": struct{}{},
+	}
+
+	if hname, err := os.Hostname(); err == nil {
+		hosts[hname] = struct{}{}
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		log.Warning("get interface addrs failed", err)
+		return hosts
+	}
+
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			hosts[ipnet.IP.String()] = struct{}{}
+		}
+	}
+
+	return hosts
+}
+
 func CheckUlimit(min int) {
 	ulimitN, err := exec.Command("/bin/sh", "-c", "ulimit -n").Output()
 	if err != nil {
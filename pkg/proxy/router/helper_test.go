@@ -24,9 +24,9 @@ func (s *testProxyRouterSuite) TestStringsContain(c *C) {
 }
 
 func (s *testProxyRouterSuite) TestAllowOp(c *C) {
-	c.Assert(allowOp("SLOTSMGRT"), Equals, false)
-	c.Assert(allowOp("SLOTSMGRTONE"), Equals, false)
-	c.Assert(allowOp("SET"), Equals, true)
+	c.Assert(defaultCommands.lookup("SLOTSMGRT").denied(), Equals, true)
+	c.Assert(defaultCommands.lookup("SLOTSMGRTONE").denied(), Equals, true)
+	c.Assert(defaultCommands.lookup("SET").denied(), Equals, false)
 }
 
 func (s *testProxyRouterSuite) TestIsMulOp(c *C) {
@@ -175,3 +175,10 @@ func (s *testProxyRouterSuite) TestHandleSpecCommand(c *C) {
 		c.Assert(err, IsNil)
 	}
 }
+
+func (s *testProxyRouterSuite) TestIsReadOnlyOp(c *C) {
+	c.Assert(defaultCommands.lookup("GET").readOnly(), Equals, true)
+	c.Assert(defaultCommands.lookup("HGETALL").readOnly(), Equals, true)
+	c.Assert(defaultCommands.lookup("SET").readOnly(), Equals, false)
+	c.Assert(defaultCommands.lookup("INCR").readOnly(), Equals, false)
+}
@@ -0,0 +1,142 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+)
+
+// the reply to a rejected connection is given up after the timeout
+const RejectWriteTimeout = time.Second
+
+// clientLimiter counts the client connections, in total and by ip, a limit
+// of 0 means no limit.
+type clientLimiter struct {
+	mu         sync.Mutex
+	maxClients int
+	maxPerIP   int
+	total      int
+	perIP      map[string]int
+}
+
+func (l *clientLimiter) setLimits(maxClients int, maxPerIP int) {
+	l.mu.Lock()
+	l.maxClients, l.maxPerIP = maxClients, maxPerIP
+	l.mu.Unlock()
+}
+
+// acquire counts the connection from the ip, it returns the name of the
+// limit reached if any, the connection is not counted then.
+func (l *clientLimiter) acquire(ip string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxClients > 0 && l.total >= l.maxClients {
+		return "RejectedMaxClients"
+	}
+
+	if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		return "RejectedMaxClientsPerIP"
+	}
+
+	if l.perIP == nil {
+		l.perIP = make(map[string]int)
+	}
+
+	l.total++
+	l.perIP[ip]++
+	return ""
+}
+
+func (l *clientLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.total--
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+func remoteIP(c net.Conn) string {
+	host, _, err := net.SplitHostPort(c.RemoteAddr().String())
+	if err != nil {
+		return c.RemoteAddr().String()
+	}
+	return host
+}
+
+// acceptClient counts the client connection, or replies the error and closes
+// it if any limit is reached.
+func (s *Server) acceptClient(c net.Conn) bool {
+	limit := s.clients.acquire(remoteIP(c))
+	if len(limit) == 0 {
+		return true
+	}
+
+	s.counter.Add(limit, 1)
+	log.Warningf("reject connection %v, %s", c.RemoteAddr(), limit)
+
+	c.SetWriteDeadline(time.Now().Add(RejectWriteTimeout))
+	c.Write(MAXCLIENTS_BYTES)
+	c.Close()
+	return false
+}
+
+// setIdleDeadline closes the session if no request is read in the idle
+// timeout, the subscribers are never idle like redis.
+func (s *Server) setIdleDeadline(c *session) {
+	timeout := time.Duration(atomic.LoadInt64(&s.idleTimeout))
+	if timeout <= 0 {
+		return
+	}
+
+	if c.sub != nil {
+		c.SetReadDeadline(time.Time{})
+		return
+	}
+
+	c.SetReadDeadline(time.Now().Add(timeout))
+}
+
+func isTimeout(err error) bool {
+	ne, ok := errors.Cause(err).(net.Error)
+	return ok && ne.Timeout()
+}
+
+// overloaded returns whether the requests of the slot queue too long in
+// the dispatcher or the task runners of the backend, the request is shed
+// then instead of waiting.
+func (s *Server) overloaded(slotIdx int) bool {
+	n := s.conf.OverloadQueueLen
+	if n <= 0 {
+		return false
+	}
+
+	if len(s.dispatchers[slotIdx%len(s.dispatchers)].reqCh) >= n {
+		return true
+	}
+
+	t, ok := s.route.Load().(*routeTable)
+	if !ok || t.slots[slotIdx] == nil || t.slots[slotIdx].dst == nil {
+		return false
+	}
+
+	s.pipeMutex.RLock()
+	defer s.pipeMutex.RUnlock()
+
+	for _, tr := range s.pipeConns[t.slots[slotIdx].dst.Master()] {
+		if tr != nil && len(tr.in) >= n {
+			return true
+		}
+	}
+
+	return false
+}
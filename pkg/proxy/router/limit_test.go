@@ -0,0 +1,145 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"bufio"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/reborndb/reborn/pkg/models"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testProxyRouterSuite) TestClientLimiter(c *C) {
+	l := &clientLimiter{}
+	l.setLimits(3, 2)
+	c.Assert(l.acquire("1.1.1.1"), Equals, "")
+	c.Assert(l.acquire("1.1.1.1"), Equals, "")
+	c.Assert(l.acquire("1.1.1.1"), Equals, "RejectedMaxClientsPerIP")
+	c.Assert(l.acquire("2.2.2.2"), Equals, "")
+	c.Assert(l.acquire("3.3.3.3"), Equals, "RejectedMaxClients")
+
+	l.release("1.1.1.1")
+	c.Assert(l.acquire("3.3.3.3"), Equals, "")
+
+	l.release("2.2.2.2")
+	c.Assert(l.perIP, DeepEquals, map[string]int{"1.1.1.1": 1, "3.3.3.3": 1})
+
+	// no limit
+	l.setLimits(0, 0)
+	c.Assert(l.acquire("1.1.1.1"), Equals, "")
+}
+
+func (s *testProxyRouterSuite) TestOverloaded(c *C) {
+	srv := &Server{conf: &Conf{OverloadQueueLen: 2}, pipeConns: make(map[string][]*taskRunner)}
+	srv.dispatchers = []*dispatcher{newDispatcher(srv, 0)}
+	c.Assert(srv.overloaded(1), Equals, false)
+
+	srv.dispatchers[0].reqCh <- &PipelineRequest{}
+	srv.dispatchers[0].reqCh <- &PipelineRequest{}
+	c.Assert(srv.overloaded(1), Equals, true)
+
+	<-srv.dispatchers[0].reqCh
+	c.Assert(srv.overloaded(1), Equals, false)
+
+	// the task runner queue of the slot master
+	srv.route.Store(&routeTable{slots: []*Slot{
+		s.testNewSlot(0, models.SLOT_STATUS_ONLINE, "127.0.0.1:6379"),
+		s.testNewSlot(1, models.SLOT_STATUS_ONLINE, "127.0.0.1:6380"),
+	}})
+
+	tr := &taskRunner{in: make(chan interface{}, 4)}
+	srv.pipeConns["127.0.0.1:6380"] = []*taskRunner{nil, tr}
+	tr.in <- &PipelineRequest{}
+	tr.in <- &PipelineRequest{}
+	c.Assert(srv.overloaded(1), Equals, true)
+	c.Assert(srv.overloaded(0), Equals, false)
+
+	srv.conf.OverloadQueueLen = 0
+	c.Assert(srv.overloaded(1), Equals, false)
+}
+
+func (s *testProxyRouterSuite) TestClientLimits(c *C) {
+	// wait for the connections of the former tests closed
+	time.Sleep(100 * time.Millisecond)
+	ss.clients.mu.Lock()
+	n := ss.clients.perIP["127.0.0.1"]
+	ss.clients.mu.Unlock()
+
+	ss.clients.setLimits(0, n+1)
+	defer ss.clients.setLimits(0, 0)
+
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	_, err := cc.Do("PING")
+	c.Assert(err, IsNil)
+
+	rejected := ss.counter.Counts()["RejectedMaxClientsPerIP"]
+
+	nc, err := net.Dial("tcp", proxyAddr)
+	c.Assert(err, IsNil)
+	defer nc.Close()
+
+	line, err := bufio.NewReader(nc).ReadString('\n')
+	c.Assert(err, IsNil)
+	c.Assert(line, Equals, string(MAXCLIENTS_BYTES))
+	c.Assert(ss.counter.Counts()["RejectedMaxClientsPerIP"], Equals, rejected+1)
+
+	// the closed connection is not counted any more
+	cc.Close()
+	for i := 0; i < 50; i++ {
+		ss.clients.mu.Lock()
+		m := ss.clients.perIP["127.0.0.1"]
+		ss.clients.mu.Unlock()
+		if m <= n {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cc = s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	_, err = cc.Do("PING")
+	c.Assert(err, IsNil)
+}
+
+func (s *testProxyRouterSuite) TestIdleTimeout(c *C) {
+	atomic.StoreInt64(&ss.idleTimeout, int64(time.Second))
+	defer atomic.StoreInt64(&ss.idleTimeout, 0)
+
+	closed := ss.counter.Counts()["IdleClosed"]
+
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	_, err := cc.Do("PING")
+	c.Assert(err, IsNil)
+
+	// the subscriber is never idle
+	sc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer sc.Close()
+
+	psc := redis.PubSubConn{Conn: sc}
+	err = psc.Subscribe("idle_channel")
+	c.Assert(err, IsNil)
+	_, ok := psc.Receive().(redis.Subscription)
+	c.Assert(ok, Equals, true)
+
+	time.Sleep(1500 * time.Millisecond)
+
+	_, err = cc.Do("PING")
+	c.Assert(err, NotNil)
+	c.Assert(ss.counter.Counts()["IdleClosed"], Equals, closed+1)
+
+	err = psc.Unsubscribe("idle_channel")
+	c.Assert(err, IsNil)
+	sub, ok := psc.Receive().(redis.Subscription)
+	c.Assert(ok, Equals, true)
+	c.Assert(sub.Kind, Equals, "unsubscribe")
+}
@@ -5,7 +5,6 @@ package router
 
 import (
 	"bytes"
-	"hash/crc32"
 
 	"github.com/reborndb/reborn/pkg/models"
 )
@@ -15,16 +14,32 @@ const (
 	HASHTAG_END   = '}'
 )
 
-func mapKey2Slot(key []byte) int {
-	hashKey := key
+// slotConfig is the slot layout of the product, it is set by NewServer
+// before serving and never changes while the proxy is running.
+var slotConfig = models.DefaultSlotConfig()
+
+func setSlotConfig(cfg *models.SlotConfig) {
+	slotConfig = cfg
+}
+
+func slotNum() int {
+	return slotConfig.Num
+}
+
+// hashKey returns the hash tag of the key if any, otherwise the key itself.
+func hashKey(key []byte) []byte {
 	//hash tag support
 	htagStart := bytes.IndexByte(key, HASHTAG_START)
 	if htagStart >= 0 {
 		htagEnd := bytes.IndexByte(key[htagStart:], HASHTAG_END)
 		if htagEnd >= 0 {
-			hashKey = key[htagStart+1 : htagStart+htagEnd]
+			return key[htagStart+1 : htagStart+htagEnd]
 		}
 	}
 
-	return int(crc32.ChecksumIEEE(hashKey) % models.DEFAULT_SLOT_NUM)
+	return key
+}
+
+func mapKey2Slot(key []byte) int {
+	return slotConfig.HashSlot(hashKey(key))
 }
@@ -4,6 +4,7 @@
 package router
 
 import (
+	"github.com/reborndb/reborn/pkg/models"
 	. "gopkg.in/check.v1"
 )
 
@@ -14,3 +15,17 @@ func (s *testProxyRouterSuite) TestMapKey2Slot(c *C) {
 		c.Assert(index, Equals, mapKey2Slot([]byte(v)))
 	}
 }
+
+func (s *testProxyRouterSuite) TestMapKey2SlotConfig(c *C) {
+	old := slotConfig
+	defer setSlotConfig(old)
+
+	setSlotConfig(&models.SlotConfig{Num: 16384, Hash: models.SLOT_HASH_CRC16})
+	c.Assert(slotNum(), Equals, 16384)
+	c.Assert(validSlot(16383), Equals, true)
+	c.Assert(validSlot(16384), Equals, false)
+
+	// same as redis cluster
+	c.Assert(mapKey2Slot([]byte("foo")), Equals, 12182)
+	c.Assert(mapKey2Slot([]byte("{user1000}.following")), Equals, mapKey2Slot([]byte("user1000")))
+}
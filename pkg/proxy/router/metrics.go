@@ -0,0 +1,237 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latency buckets of the histograms in seconds
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	mu     sync.Mutex
+	counts []int64 // not cumulative, the last one is +Inf
+	count  int64
+	sum    float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]int64, len(latencyBuckets)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	i := sort.SearchFloat64s(latencyBuckets, v)
+
+	h.mu.Lock()
+	h.counts[i]++
+	h.count++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+// histogramVec is a set of histograms with one variable label.
+type histogramVec struct {
+	mu sync.RWMutex
+	m  map[string]*histogram
+}
+
+func newHistogramVec() *histogramVec {
+	return &histogramVec{m: make(map[string]*histogram)}
+}
+
+func (v *histogramVec) observe(label string, d time.Duration) {
+	v.mu.RLock()
+	h, ok := v.m[label]
+	v.mu.RUnlock()
+
+	if !ok {
+		v.mu.Lock()
+		if h, ok = v.m[label]; !ok {
+			h = newHistogram()
+			v.m[label] = h
+		}
+		v.mu.Unlock()
+	}
+
+	h.observe(d)
+}
+
+// retain removes the histograms whose labels are not in labels.
+func (v *histogramVec) retain(labels map[string]bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for label := range v.m {
+		if !labels[label] {
+			delete(v.m, label)
+		}
+	}
+}
+
+// metricsWriter writes metrics in the prometheus text format, every sample
+// has the constant labels of the proxy.
+type metricsWriter struct {
+	bytes.Buffer
+	labels string
+}
+
+func escapeLabel(v string) string {
+	v = strings.Replace(v, `\`, `\\`, -1)
+	v = strings.Replace(v, "\n", `\n`, -1)
+	return strings.Replace(v, `"`, `\"`, -1)
+}
+
+func (w *metricsWriter) header(name string, typ string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes a sample, the extra labels are pairs of name and value.
+func (w *metricsWriter) sample(name string, v float64, extra ...string) {
+	w.WriteString(name)
+	w.WriteString("{")
+	w.WriteString(w.labels)
+	for i := 0; i+1 < len(extra); i += 2 {
+		fmt.Fprintf(w, `,%s="%s"`, extra[i], escapeLabel(extra[i+1]))
+	}
+	w.WriteString("} ")
+	w.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	w.WriteString("\n")
+}
+
+func (w *metricsWriter) gauge(name string, help string, v int64) {
+	w.header(name, "gauge", help)
+	w.sample(name, float64(v))
+}
+
+func (w *metricsWriter) counter(name string, help string, v int64) {
+	w.header(name, "counter", help)
+	w.sample(name, float64(v))
+}
+
+// counterVec writes the counts as a counter with the label.
+func (w *metricsWriter) counterVec(name string, help string, label string, counts map[string]int64) {
+	w.header(name, "counter", help)
+
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		w.sample(name, float64(counts[k]), label, k)
+	}
+}
+
+func (w *metricsWriter) histogramVec(name string, help string, label string, v *histogramVec) {
+	w.header(name, "histogram", help)
+
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.m))
+	for k := range v.m {
+		keys = append(keys, k)
+	}
+	v.mu.RUnlock()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v.mu.RLock()
+		h, ok := v.m[k]
+		v.mu.RUnlock()
+		if !ok {
+			// removed meanwhile
+			continue
+		}
+
+		h.mu.Lock()
+		var n int64
+		for i, le := range latencyBuckets {
+			n += h.counts[i]
+			w.sample(name+"_bucket", float64(n), label, k, "le", strconv.FormatFloat(le, 'g', -1, 64))
+		}
+		w.sample(name+"_bucket", float64(h.count), label, k, "le", "+Inf")
+		w.sample(name+"_sum", h.sum, label, k)
+		w.sample(name+"_count", float64(h.count), label, k)
+		h.mu.Unlock()
+	}
+}
+
+// commandLabel returns the label of the command, unknown commands share one
+// label to bound the number of series.
+func commandLabel(cmd *commandInfo) string {
+	if cmd.arity == 0 {
+		return "unknown"
+	}
+
+	return strings.ToLower(cmd.name)
+}
+
+// observeCommand records the latency of the request, and its backend latency
+// if it is sent to a backend.
+func (s *Server) observeCommand(cmd *commandInfo, pr *PipelineRequest, d time.Duration) {
+	label := commandLabel(cmd)
+	s.cmdLatency.observe(label, d)
+
+	if pr == nil {
+		return
+	}
+
+	if pr.failed {
+		s.errCounter.Add(label, 1)
+	}
+
+	if len(pr.backend) > 0 && !pr.repliedAt.IsZero() {
+		s.backendLatency.observe(pr.backend, pr.repliedAt.Sub(pr.sentAt))
+	}
+}
+
+// ServeMetrics serves the metrics of the proxy in the prometheus text format.
+func (s *Server) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	mw := &metricsWriter{
+		labels: fmt.Sprintf(`product="%s",proxy_id="%s"`, escapeLabel(s.conf.ProductName), escapeLabel(s.conf.ProxyID)),
+	}
+
+	counts := s.counter.Counts()
+	mw.counter("reborn_proxy_ops_total", "Requests handled.", counts["ops"])
+	mw.counterVec("reborn_proxy_errors_total", "Requests failed by a backend error.", "command", s.errCounter.Counts())
+	mw.gauge("reborn_proxy_connections", "Client connections.", counts["connections"])
+	mw.counterVec("reborn_proxy_rejected_connections_total", "Client connections rejected by the limits.", "limit", map[string]int64{
+		"max_clients":        counts["RejectedMaxClients"],
+		"max_clients_per_ip": counts["RejectedMaxClientsPerIP"],
+	})
+	mw.counter("reborn_proxy_idle_closed_total", "Client connections closed by the idle timeout.", counts["IdleClosed"])
+	mw.counter("reborn_proxy_shed_total", "Requests rejected as the proxy is overloaded.", counts["Shed"])
+
+	mw.histogramVec("reborn_proxy_command_duration_seconds", "Latency of the requests.", "command", s.cmdLatency)
+	mw.histogramVec("reborn_proxy_backend_duration_seconds", "Latency of the backends.", "backend", s.backendLatency)
+
+	mw.gauge("reborn_proxy_premigrate_buffering", "Requests buffered for pre_migrate slots.", counts["PreMigrateBuffering"])
+	mw.counter("reborn_proxy_premigrate_buffered_total", "Requests buffered for pre_migrate slots.", counts["PreMigrateBuffered"])
+	mw.counter("reborn_proxy_premigrate_overflow_total", "Requests failed as the pre_migrate buffer is full.", counts["PreMigrateOverflow"])
+	mw.counter("reborn_proxy_premigrate_timeout_total", "Requests buffered longer than the timeout.", counts["PreMigrateTimeout"])
+
+	migrates := s.migrateCounter.Counts()
+	mw.counter("reborn_proxy_migrate_keys_total", "Keys migrated on access.", migrates["Keys"])
+	mw.counter("reborn_proxy_migrate_batches_total", "Batches of keys migrated on access.", migrates["Batches"])
+	mw.counter("reborn_proxy_migrate_coalesced_total", "Requests waiting for a pending migration of the same tag.", migrates["Coalesced"])
+	mw.counter("reborn_proxy_migrate_errors_total", "Keys failed to migrate on access.", migrates["Errors"])
+
+	var epoch int64
+	if t, ok := s.route.Load().(*routeTable); ok {
+		epoch = t.epoch
+	}
+	mw.gauge("reborn_proxy_topology_epoch", "Epoch of the route table.", epoch)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(mw.Bytes())
+}
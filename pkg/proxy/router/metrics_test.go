@@ -0,0 +1,79 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *testProxyRouterSuite) TestMetricsHistogram(c *C) {
+	v := newHistogramVec()
+	v.observe("get", 200*time.Microsecond)
+	v.observe("get", 2*time.Millisecond)
+	v.observe("get", 20*time.Second)
+
+	w := &metricsWriter{labels: `product="test",proxy_id="p\"1"`}
+	w.histogramVec("latency", "Latency.", "command", v)
+	out := w.String()
+
+	for _, line := range []string{
+		"# TYPE latency histogram",
+		`latency_bucket{product="test",proxy_id="p\"1",command="get",le="0.0005"} 1`,
+		`latency_bucket{product="test",proxy_id="p\"1",command="get",le="0.0025"} 2`,
+		`latency_bucket{product="test",proxy_id="p\"1",command="get",le="10"} 2`,
+		`latency_bucket{product="test",proxy_id="p\"1",command="get",le="+Inf"} 3`,
+		`latency_count{product="test",proxy_id="p\"1",command="get"} 3`,
+	} {
+		c.Assert(strings.Contains(out, line+"\n"), Equals, true, Commentf("%s not in\n%s", line, out))
+	}
+
+	// the series of the labels removed are not exported any more
+	v.observe("set", time.Millisecond)
+	v.retain(map[string]bool{"set": true})
+
+	w = &metricsWriter{}
+	w.histogramVec("latency", "Latency.", "command", v)
+	c.Assert(strings.Contains(w.String(), `command="get"`), Equals, false)
+	c.Assert(strings.Contains(w.String(), `command="set"`), Equals, true)
+}
+
+func (s *testProxyRouterSuite) TestMetricsEndpoint(c *C) {
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	_, err := cc.Do("SET", "metrics_key", "value")
+	c.Assert(err, IsNil)
+
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("GET", "/metrics", nil)
+	c.Assert(err, IsNil)
+	ss.ServeMetrics(w, r)
+	c.Assert(w.Code, Equals, http.StatusOK)
+
+	backend := s.s1.addr
+	if mapKey2Slot([]byte("metrics_key")) >= 512 {
+		backend = s.s2.addr
+	}
+
+	out := w.Body.String()
+	labels := `{product="test",proxy_id="proxy_test"`
+	for _, prefix := range []string{
+		"reborn_proxy_ops_total" + labels + "} ",
+		"reborn_proxy_connections" + labels + "} ",
+		"reborn_proxy_rejected_connections_total" + labels + `,limit="max_clients"} `,
+		"reborn_proxy_shed_total" + labels + "} ",
+		"reborn_proxy_command_duration_seconds_count" + labels + `,command="set"} `,
+		"reborn_proxy_backend_duration_seconds_count" + labels + `,backend="` + backend + `"} `,
+		"reborn_proxy_premigrate_buffering" + labels + "} ",
+		"reborn_proxy_migrate_keys_total" + labels + "} ",
+		"reborn_proxy_topology_epoch" + labels + "} ",
+	} {
+		c.Assert(strings.Contains(out, "\n"+prefix), Equals, true, Commentf("%s not in\n%s", prefix, out))
+	}
+}
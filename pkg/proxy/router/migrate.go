@@ -0,0 +1,256 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	"github.com/reborndb/reborn/pkg/models"
+	"github.com/reborndb/reborn/pkg/proxy/parser"
+)
+
+const (
+	MigrateWorkers   = 4  // migrating goroutines of every source group
+	MigrateBatchSize = 64 // keys migrated in one round trip
+)
+
+// migrateCall migrates a key of a migrating slot on access, the requests
+// of the keys with the same hash tag wait for the same call, since the
+// server migrates all keys of the tag together.
+type migrateCall struct {
+	tag  string
+	key  []byte
+	slot *Slot
+	done chan struct{}
+	err  error
+}
+
+// keyMigrator migrates keys on access from the master of a source group,
+// the calls are batched and pipelined on pooled connections.
+type keyMigrator struct {
+	s     *Server
+	from  string
+	queue chan *migrateCall
+
+	mu      sync.Mutex
+	pending map[string]*migrateCall // hash tag -> call not done
+}
+
+func newKeyMigrator(s *Server, from string) *keyMigrator {
+	m := &keyMigrator{
+		s:       s,
+		from:    from,
+		queue:   make(chan *migrateCall, PipelineRequestNum),
+		pending: make(map[string]*migrateCall),
+	}
+
+	for i := 0; i < MigrateWorkers; i++ {
+		go m.run()
+	}
+
+	return m
+}
+
+// add returns the call migrating the key, a new one is queued if no
+// call of the same hash tag is pending.
+func (m *keyMigrator) add(slot *Slot, key []byte) *migrateCall {
+	tag := string(hashKey(key))
+
+	m.mu.Lock()
+	call, ok := m.pending[tag]
+	if !ok {
+		call = &migrateCall{tag: tag, key: key, slot: slot, done: make(chan struct{})}
+		m.pending[tag] = call
+	}
+	m.mu.Unlock()
+
+	if ok {
+		m.s.migrateCounter.Add("Coalesced", 1)
+		return call
+	}
+
+	m.queue <- call
+	return call
+}
+
+func (m *keyMigrator) run() {
+	for call := range m.queue {
+		batch := []*migrateCall{call}
+
+	collect:
+		for len(batch) < MigrateBatchSize {
+			select {
+			case call, ok := <-m.queue:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, call)
+			default:
+				break collect
+			}
+		}
+
+		start := time.Now()
+		m.migrate(batch)
+		recordResponseTime(m.s.migrateCounter, time.Since(start)/time.Millisecond)
+
+		m.mu.Lock()
+		for _, call := range batch {
+			delete(m.pending, call.tag)
+		}
+		m.mu.Unlock()
+
+		for _, call := range batch {
+			close(call.done)
+		}
+	}
+}
+
+// migrate sends SLOTSMGRTTAGONE of the calls in a pipeline, and sets the
+// error of every call.
+func (m *keyMigrator) migrate(batch []*migrateCall) {
+	s := m.s
+	s.migrateCounter.Add("Batches", 1)
+	s.migrateCounter.Add("Keys", int64(len(batch)))
+
+	setErr := func(calls []*migrateCall, err error) {
+		s.migrateCounter.Add("Errors", int64(len(calls)))
+		for _, call := range calls {
+			call.err = err
+		}
+	}
+
+	redisConn, err := s.pools.GetConn(m.from)
+	if err != nil {
+		setErr(batch, errors.Trace(err))
+		return
+	}
+
+	defer s.pools.PutConn(redisConn)
+
+	// keys of the slots migrating to different groups
+	var dsts []string
+	byDst := make(map[string][]*migrateCall)
+	for _, call := range batch {
+		dst := call.slot.dst.Master()
+		if _, ok := byDst[dst]; !ok {
+			dsts = append(dsts, dst)
+		}
+		byDst[dst] = append(byDst[dst], call)
+	}
+
+	var calls []*migrateCall
+	for _, dst := range dsts {
+		keys := make([][]byte, 0, len(byDst[dst]))
+		for _, call := range byDst[dst] {
+			keys = append(keys, call.key)
+		}
+
+		err = writeMigrateKeyCmd(redisConn, dst, MigrateKeyTimeoutMs, keys...)
+		if err != nil {
+			redisConn.Close()
+			log.Errorf("migrate key %s error, from %s to %s, err:%v", string(keys[0]), m.from, dst, err)
+			setErr(batch, errors.Trace(err))
+			return
+		}
+
+		calls = append(calls, byDst[dst]...)
+	}
+
+	// pooled connections keep the read deadline of the former user
+	timeout := time.Duration(MigrateKeyTimeoutMs)*time.Millisecond + time.Duration(s.conf.NetTimeout)*time.Second
+	if err = redisConn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		redisConn.Close()
+		setErr(batch, errors.Trace(err))
+		return
+	}
+
+	redisReader := redisConn.BufioReader()
+
+	// handle migrate result
+	for i, call := range calls {
+		resp, err := parser.Parse(redisReader)
+		if err != nil {
+			log.Errorf("migrate key %s error, from %s to %s, err:%v",
+				string(call.key), m.from, call.slot.dst.Master(), err)
+			redisConn.Close()
+			setErr(calls[i:], errors.Trace(err))
+			return
+		}
+
+		log.Debug("migrate", string(call.key), "from", m.from, "to", call.slot.dst.Master(), string(resp.Raw))
+
+		if resp.Type == parser.ErrorResp {
+			log.Error(string(call.key), string(resp.Raw), "migrateFrom", m.from)
+			setErr([]*migrateCall{call}, errors.New(string(resp.Raw)))
+			continue
+		}
+
+		s.counter.Add("Migrate", 1)
+	}
+}
+
+func (s *Server) getKeyMigrator(from string) *keyMigrator {
+	s.migrateMutex.RLock()
+	m, ok := s.migrators[from]
+	s.migrateMutex.RUnlock()
+	if ok {
+		return m
+	}
+
+	s.migrateMutex.Lock()
+	defer s.migrateMutex.Unlock()
+
+	if m, ok = s.migrators[from]; !ok {
+		m = newKeyMigrator(s, from)
+		s.migrators[from] = m
+	}
+
+	return m
+}
+
+// migrateKeys migrates the keys of the migrating slot off the dispatcher,
+// the request can be sent to the slot after all calls returned are done.
+func (s *Server) migrateKeys(slot *Slot, keys [][]byte) []*migrateCall {
+	if slot.migrateFrom == nil {
+		log.Fatalf("migrateFrom not exist %+v", slot)
+	}
+
+	if slot.dst.Master() == slot.migrateFrom.Master() {
+		log.Fatalf("the same migrate src and dst, %+v", slot)
+	}
+
+	m := s.getKeyMigrator(slot.migrateFrom.Master())
+	calls := make([]*migrateCall, 0, len(keys))
+	for _, key := range keys {
+		calls = append(calls, m.add(slot, key))
+	}
+
+	return calls
+}
+
+// stopKeyMigrators stops the migrators of the groups which are not the
+// source of any migrating slot of the current route table, the calls queued
+// are still done. It is called after the table swapped, so no dispatcher
+// adds calls to the migrators stopped.
+func (s *Server) stopKeyMigrators(t *routeTable) {
+	used := make(map[string]bool)
+	for _, slot := range t.slots {
+		if slot != nil && slot.slotInfo.State.Status == models.SLOT_STATUS_MIGRATE && slot.migrateFrom != nil {
+			used[slot.migrateFrom.Master()] = true
+		}
+	}
+
+	s.migrateMutex.Lock()
+	for from, m := range s.migrators {
+		if !used[from] {
+			close(m.queue)
+			delete(s.migrators, from)
+		}
+	}
+	s.migrateMutex.Unlock()
+}
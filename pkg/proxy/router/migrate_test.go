@@ -0,0 +1,66 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/garyburd/redigo/redis"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testProxyRouterSuite) TestMigrateCoalesce(c *C) {
+	proxyConn := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer proxyConn.Close()
+
+	// keys of the same hash tag are migrated together
+	tag := s.testGenKeysInSlot(c, 0, 1)[0]
+	keys := make([]string, 20)
+	for i := 0; i < len(keys); i++ {
+		keys[i] = fmt.Sprintf("{%s}_%d", tag, i)
+		_, err := proxyConn.Do("SET", keys[i], keys[i])
+		c.Assert(err, IsNil)
+	}
+
+	counts := ss.migrateCounter.Counts()
+	slot0 := s.testSetSlotMigrate(c, 0, 1, 2)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < len(keys); i++ {
+		cc := s.testDialConn(c, proxyAddr, proxyAuth)
+		defer cc.Close()
+
+		wg.Add(1)
+		go func(cc redis.Conn, key string) {
+			defer wg.Done()
+			value, err := redis.String(cc.Do("GET", key))
+			c.Check(err, IsNil)
+			c.Check(value, Equals, key)
+		}(cc, keys[i])
+	}
+	wg.Wait()
+
+	// every request either migrates or waits for a pending migration
+	now := ss.migrateCounter.Counts()
+	migrated := now["Keys"] - counts["Keys"]
+	coalesced := now["Coalesced"] - counts["Coalesced"]
+	c.Assert(migrated > 0, Equals, true)
+	c.Assert(migrated+coalesced, Equals, int64(len(keys)))
+	c.Assert(now["Errors"], Equals, counts["Errors"])
+
+	s2Conn := s.testDialConn(c, s.s2.addr, storeAuth)
+	defer s2Conn.Close()
+
+	for i := 0; i < len(keys); i++ {
+		value, err := redis.String(s2Conn.Do("GET", keys[i]))
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, keys[i])
+	}
+
+	s.testSetSlotOnline(c, slot0)
+
+	s.s1.store.Reset()
+	s.s2.store.Reset()
+}
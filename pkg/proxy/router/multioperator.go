@@ -4,31 +4,30 @@
 package router
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
 	"time"
 
-	"github.com/garyburd/redigo/redis"
 	"github.com/juju/errors"
 	"github.com/ngaut/log"
-	respcoding "github.com/ngaut/resp"
-)
-
-const (
-	RedisPoolMaxIdleNum        = 5
-	RedisPoolIdleTimeoutSecond = 2400
-
-	MultiOperatorNum = 128
+	"github.com/reborndb/reborn/pkg/models"
+	"github.com/reborndb/reborn/pkg/proxy/parser"
 )
 
+// MultiOperator splits a multi-key request whose keys are in different slots
+// into one request per server group, and sends them to the task runners through
+// the dispatchers at once. Requests to the same backend server are pipelined by
+// its task runner, so all groups are requested in parallel.
 type MultiOperator struct {
-	q    chan *MulOp
-	pool *redis.Pool
+	send  func(r *PipelineRequest)
+	route func() *routeTable
 }
 
 type MulOp struct {
-	op     string
-	keys   [][]byte
-	result *[]byte
-	wait   chan error
+	op   string
+	keys [][]byte
 }
 
 type pair struct {
@@ -52,118 +51,229 @@ func getSlotMap(keys [][]byte) map[int][]*pair {
 	return slotmap
 }
 
-func newMultiOperator(server string, auth string) *MultiOperator {
-	oper := &MultiOperator{q: make(chan *MulOp, MultiOperatorNum)}
-	oper.pool = newPool(server, auth)
-	for i := 0; i < MultiOperatorNum/2; i++ {
-		go oper.work()
+// batch is the keys of one sub request, the slots online in the same group
+// are batched, other slots, e.g. migrating, are sent one by one.
+type batch struct {
+	slots []int
+	pairs []*pair
+	reply *parser.Resp
+}
+
+// getBatches groups the slots of slotmap by the server groups in route table.
+func getBatches(t *routeTable, slotmap map[int][]*pair) []*batch {
+	slots := make([]int, 0, len(slotmap))
+	for slot, _ := range slotmap {
+		slots = append(slots, slot)
 	}
+	sort.Ints(slots)
+
+	var batches []*batch
+	groups := make(map[int]*batch)
+	for _, i := range slots {
+		slot := t.slots[i]
+		if slot == nil || slot.slotInfo.State.Status != models.SLOT_STATUS_ONLINE {
+			batches = append(batches, &batch{slots: []int{i}, pairs: slotmap[i]})
+			continue
+		}
 
-	return oper
+		b, ok := groups[slot.slotInfo.GroupId]
+		if !ok {
+			b = &batch{}
+			groups[slot.slotInfo.GroupId] = b
+			batches = append(batches, b)
+		}
+		b.slots = append(b.slots, i)
+		b.pairs = append(b.pairs, slotmap[i]...)
+	}
+
+	return batches
 }
 
-func newPool(server, auth string) *redis.Pool {
-	return &redis.Pool{
-		MaxIdle:     RedisPoolMaxIdleNum,
-		IdleTimeout: RedisPoolIdleTimeoutSecond * time.Second,
-		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial("tcp", server)
-			if err != nil {
-				return nil, errors.Trace(err)
-			}
-			if len(auth) > 0 {
-				if ok, err := c.Do("AUTH", auth); err != nil {
-					c.Close()
-					return nil, errors.Trace(err)
-				} else if ok != "OK" {
-					c.Close()
-					return nil, errors.Errorf("auth err, need OK but got %s", ok)
-				}
-			}
-			return c, errors.Trace(err)
-		},
+// split returns one batch per slot.
+func (b *batch) split() []*batch {
+	batches := make([]*batch, 0, len(b.slots))
+	for _, p := range b.pairs {
+		i := mapKey2Slot(p.key)
+		if len(batches) == 0 || batches[len(batches)-1].slots[0] != i {
+			batches = append(batches, &batch{slots: []int{i}})
+		}
+		last := batches[len(batches)-1]
+		last.pairs = append(last.pairs, p)
 	}
+
+	return batches
 }
 
-func (oper *MultiOperator) handleMultiOp(op string, keys [][]byte, result *[]byte) error {
-	wait := make(chan error, 1)
-	oper.q <- &MulOp{op: op, keys: keys, result: result, wait: wait}
-	return <-wait
+func newMultiOperator(send func(r *PipelineRequest), route func() *routeTable) *MultiOperator {
+	return &MultiOperator{send: send, route: route}
 }
 
-func (oper *MultiOperator) work() {
-	for mop := range oper.q {
-		switch mop.op {
-		case "MGET":
-			oper.mget(mop)
-		case "MSET":
-			oper.mset(mop)
-		case "DEL":
-			oper.del(mop)
+func (oper *MultiOperator) handleMultiOp(op string, keys [][]byte, result *[]byte) error {
+	start := time.Now()
+	defer func() {
+		if sec := time.Since(start).Seconds(); sec > 2 {
+			log.Warningf("too long to do %s, %v", op, sec)
 		}
+	}()
+
+	mop := &MulOp{op: op, keys: keys}
+
+	var b []byte
+	var err error
+	switch op {
+	case "MGET":
+		b, err = oper.mgetResults(mop)
+	case "MSET":
+		b, err = oper.msetResults(mop)
+	case "DEL":
+		b, err = oper.delResults(mop)
+	default:
+		err = errors.Errorf("unknown multi-key command %s", op)
 	}
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	*result = b
+	return nil
 }
 
-func (oper *MultiOperator) mgetResults(mop *MulOp) ([]byte, error) {
-	slotmap := getSlotMap(mop.keys)
-	results := make([]interface{}, len(mop.keys))
-	conn := oper.pool.Get()
-	defer conn.Close()
-	for _, vec := range slotmap {
-		req := make([]interface{}, 0, len(vec))
-		for _, p := range vec {
-			req = append(req, p.key)
+func newRequest(cmd string, args ...interface{}) (*parser.Resp, error) {
+	var b bytes.Buffer
+	if err := parser.WriteCommand(&b, cmd, args...); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	resp, err := parser.Parse(bufio.NewReader(&b))
+	return resp, errors.Trace(err)
+}
+
+// dispatch sends the sub request of every batch, args returns the arguments
+// of a key in the sub request. The batches replied are returned, if any sub
+// request fails with an error reply, it is returned as errResp. A batch is
+// split and sent again slot by slot if its slots are not in one group any more.
+func (oper *MultiOperator) dispatch(op string, batches []*batch, readOnly bool,
+	args func(p *pair) []interface{}) (done []*batch, errResp *parser.Resp, err error) {
+	reqs := make(map[*PipelineRequest]*batch, len(batches))
+	for _, b := range batches {
+		keys := make([][]byte, 0, len(b.pairs))
+		var cmdArgs []interface{}
+		for _, p := range b.pairs {
+			keys = append(keys, p.key)
+			cmdArgs = append(cmdArgs, args(p)...)
 		}
 
-		replys, err := redis.Values(conn.Do("mget", req...))
+		req, err := newRequest(op, cmdArgs...)
 		if err != nil {
-			return nil, errors.Trace(err)
+			return nil, nil, errors.Trace(err)
+		}
+
+		r := &PipelineRequest{
+			slotIdx:  b.slots[0],
+			op:       []byte(op),
+			keys:     keys,
+			req:      req,
+			readOnly: readOnly,
+		}
+		if len(b.slots) > 1 {
+			r.batchSlots = b.slots
 		}
+		reqs[r] = b
+	}
+
+	backQ := make(chan *PipelineResponse, len(reqs))
+	for r, _ := range reqs {
+		r.backQ = backQ
+		oper.send(r)
+	}
 
-		for i, reply := range replys {
-			if reply != nil {
-				results[vec[i].pos] = reply
-			} else {
-				results[vec[i].pos] = nil
+	var retry []*batch
+	done = make([]*batch, 0, len(reqs))
+	for i := 0; i < len(reqs); i++ {
+		r := <-backQ
+		b := reqs[r.ctx]
+		if r.err != nil {
+			if errors.Cause(r.err) == errRouteChanged {
+				retry = append(retry, b.split()...)
+			} else if err == nil {
+				err = r.err
 			}
+			continue
 		}
+
+		if r.resp.Type == parser.ErrorResp && errResp == nil {
+			errResp = r.resp
+		}
+		b.reply = r.resp
+		done = append(done, b)
+	}
+
+	if err != nil || len(retry) == 0 {
+		return done, errResp, errors.Trace(err)
+	}
+
+	more, moreErrResp, err := oper.dispatch(op, retry, readOnly, args)
+	if errResp == nil {
+		errResp = moreErrResp
 	}
 
-	b, err := respcoding.Marshal(results)
-	return b, errors.Trace(err)
+	return append(done, more...), errResp, errors.Trace(err)
 }
 
-func (oper *MultiOperator) mget(mop *MulOp) {
-	start := time.Now()
-	defer func() {
-		if sec := time.Since(start).Seconds(); sec > 2 {
-			log.Warning("too long to do mget", sec)
-		}
-	}()
+func keyArg(p *pair) []interface{} {
+	return []interface{}{p.key}
+}
 
-	b, err := oper.mgetResults(mop)
+func (oper *MultiOperator) mgetResults(mop *MulOp) ([]byte, error) {
+	batches := getBatches(oper.route(), getSlotMap(mop.keys))
+	batches, errResp, err := oper.dispatch("MGET", batches, true, keyArg)
 	if err != nil {
-		mop.wait <- errors.Trace(err)
-		return
+		return nil, errors.Trace(err)
 	}
-	*mop.result = b
-	mop.wait <- errors.Trace(err)
+
+	if errResp != nil {
+		return errResp.Bytes()
+	}
+
+	results := make([]*parser.Resp, len(mop.keys))
+	for _, b := range batches {
+		resp := b.reply
+		if resp.Type != parser.MultiResp || len(resp.Multi) != len(b.pairs) {
+			return nil, errors.Errorf("bad mget reply %q", resp.Raw)
+		}
+
+		for i, p := range b.pairs {
+			results[p.pos] = resp.Multi[i]
+		}
+	}
+
+	resp := &parser.Resp{Type: parser.MultiResp, Multi: results}
+	resp.Raw = append(append([]byte("*"), parser.Itoa(len(results))...), "\r\n"...)
+	return resp.Bytes()
 }
 
 func (oper *MultiOperator) delResults(mop *MulOp) ([]byte, error) {
-	var results int64
-	conn := oper.pool.Get()
-	defer conn.Close()
-	for _, k := range mop.keys {
-		n, err := conn.Do("del", k)
+	batches := getBatches(oper.route(), getSlotMap(mop.keys))
+	batches, errResp, err := oper.dispatch("DEL", batches, false, keyArg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if errResp != nil {
+		return errResp.Bytes()
+	}
+
+	results := 0
+	for _, b := range batches {
+		n, err := parser.Btoi(bytes.TrimSpace(b.reply.Raw[1:]))
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
-		results += n.(int64)
+		results += n
 	}
 
-	b, err := respcoding.Marshal(int(results))
-	return b, errors.Trace(err)
+	return []byte(fmt.Sprintf(":%d\r\n", results)), nil
 }
 
 func (oper *MultiOperator) msetResults(mop *MulOp) ([]byte, error) {
@@ -173,50 +283,23 @@ func (oper *MultiOperator) msetResults(mop *MulOp) ([]byte, error) {
 		return nil, errors.Errorf("bad number of keys for mset command - %d", keysNum)
 	}
 
-	conn := oper.pool.Get()
-	defer conn.Close()
+	// the value follows its key
+	keys := make([][]byte, 0, keysNum/2)
 	for i := 0; i < keysNum; i += 2 {
-		_, err := conn.Do("set", mop.keys[i], mop.keys[i+1]) //change mset to set
-		if err != nil {
-			return nil, errors.Trace(err)
-		}
+		keys = append(keys, mop.keys[i])
 	}
 
-	return OK_BYTES, nil
-}
-
-func (oper *MultiOperator) mset(mop *MulOp) {
-	start := time.Now()
-	defer func() { // TODO: extra function
-		if sec := time.Since(start).Seconds(); sec > 2 {
-			log.Warning("too long to do del", sec)
-		}
-	}()
-
-	b, err := oper.msetResults(mop)
+	batches := getBatches(oper.route(), getSlotMap(keys))
+	_, errResp, err := oper.dispatch("MSET", batches, false, func(p *pair) []interface{} {
+		return []interface{}{p.key, mop.keys[p.pos*2+1]}
+	})
 	if err != nil {
-		mop.wait <- errors.Trace(err)
-		return
+		return nil, errors.Trace(err)
 	}
 
-	*mop.result = b
-	mop.wait <- errors.Trace(err)
-}
-
-func (oper *MultiOperator) del(mop *MulOp) {
-	start := time.Now()
-	defer func() { // TODO: extra function
-		if sec := time.Since(start).Seconds(); sec > 2 {
-			log.Warning("too long to do del", sec)
-		}
-	}()
-
-	b, err := oper.delResults(mop)
-	if err != nil {
-		mop.wait <- errors.Trace(err)
-		return
+	if errResp != nil {
+		return errResp.Bytes()
 	}
 
-	*mop.result = b
-	mop.wait <- errors.Trace(err)
+	return OK_BYTES, nil
 }
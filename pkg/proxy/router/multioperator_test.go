@@ -4,53 +4,122 @@
 package router
 
 import (
+	"strconv"
 	"strings"
 
-	"github.com/reborndb/qdb/pkg/store"
+	"github.com/garyburd/redigo/redis"
+	"github.com/reborndb/reborn/pkg/models"
+	"github.com/reborndb/reborn/pkg/proxy/group"
 	. "gopkg.in/check.v1"
 )
 
+// testGenCrossSlotKeys returns keys in both groups, half in slot 0 - 511 and others in 512 - 1023.
+func (s *testProxyRouterSuite) testGenCrossSlotKeys(c *C) []string {
+	keys := s.testGenKeysInSlot(c, 1, 2)
+	keys = append(keys, s.testGenKeysInSlot(c, 1000, 2)...)
+	keys = append(keys, s.testGenKeysInSlot(c, 2, 1)...)
+	return keys
+}
+
+func (s *testProxyRouterSuite) TestGetBatches(c *C) {
+	newSlot := func(id int, gid int, status models.SlotStatus) *Slot {
+		g := models.ServerGroup{Id: gid, Servers: []*models.Server{{Type: models.SERVER_TYPE_MASTER, Addr: "localhost:" + strconv.Itoa(6379+gid), GroupId: gid}}}
+		info := &models.Slot{Id: id, GroupId: gid, State: models.SlotState{Status: status}}
+		return &Slot{slotInfo: info, groupInfo: &g, dst: group.NewGroup(g)}
+	}
+
+	t := &routeTable{slots: []*Slot{
+		newSlot(0, 1, models.SLOT_STATUS_ONLINE),
+		newSlot(1, 2, models.SLOT_STATUS_ONLINE),
+		newSlot(2, 1, models.SLOT_STATUS_ONLINE),
+		newSlot(3, 1, models.SLOT_STATUS_MIGRATE),
+		nil,
+	}}
+
+	slotmap := map[int][]*pair{
+		0: {{key: []byte("a"), pos: 0}},
+		1: {{key: []byte("b"), pos: 1}},
+		2: {{key: []byte("c"), pos: 2}, {key: []byte("d"), pos: 3}},
+		3: {{key: []byte("e"), pos: 4}},
+		4: {{key: []byte("f"), pos: 5}},
+	}
+
+	// online slots of a group are batched, others are sent one by one
+	batches := getBatches(t, slotmap)
+	c.Assert(batches, HasLen, 4)
+	c.Assert(batches[0].slots, DeepEquals, []int{0, 2})
+	c.Assert(batches[0].pairs, DeepEquals, []*pair{slotmap[0][0], slotmap[2][0], slotmap[2][1]})
+	c.Assert(batches[1].slots, DeepEquals, []int{1})
+	c.Assert(batches[2].slots, DeepEquals, []int{3})
+	c.Assert(batches[3].slots, DeepEquals, []int{4})
+
+	c.Assert(isBatchRoutable(t, []int{0, 2}), Equals, true)
+	c.Assert(isBatchRoutable(t, []int{0, 1}), Equals, false)
+	c.Assert(isBatchRoutable(t, []int{0, 3}), Equals, false)
+	c.Assert(isBatchRoutable(t, []int{4, 0}), Equals, false)
+}
+
+func (s *testProxyRouterSuite) TestBatchSplit(c *C) {
+	keys := s.testGenKeysInSlot(c, 1, 2)
+	keys = append(keys, s.testGenKeysInSlot(c, 2, 1)...)
+
+	b := &batch{slots: []int{1, 2}}
+	for i, k := range keys {
+		b.pairs = append(b.pairs, &pair{key: []byte(k), pos: i})
+	}
+
+	batches := b.split()
+	c.Assert(batches, HasLen, 2)
+	c.Assert(batches[0].slots, DeepEquals, []int{1})
+	c.Assert(batches[0].pairs, DeepEquals, b.pairs[:2])
+	c.Assert(batches[1].slots, DeepEquals, []int{2})
+	c.Assert(batches[1].pairs, DeepEquals, b.pairs[2:])
+}
+
 func (s *testProxyRouterSuite) TestMgetResults(c *C) {
-	moper := newMultiOperator(s.s.addr, storeAuth)
+	keys := s.testGenCrossSlotKeys(c)
 
-	var err error
-	err = s.s.store.Set(0, store.FormatBytes("a", "a"))
-	c.Assert(err, IsNil)
-	err = s.s.store.Set(0, store.FormatBytes("b", "b"))
-	c.Assert(err, IsNil)
-	err = s.s.store.Set(0, store.FormatBytes("c", "c"))
-	c.Assert(err, IsNil)
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
 
-	buf, err := moper.mgetResults(&MulOp{
-		op: "mget",
-		keys: [][]byte{[]byte("a"),
-			[]byte("b"), []byte("c"), []byte("x")}})
-	c.Assert(err, IsNil)
+	for _, k := range keys[1:] {
+		_, err := cc.Do("SET", k, k)
+		c.Assert(err, IsNil)
+	}
 
-	res := string(buf)
-	c.Assert(strings.Contains(res, "a"), Equals, true)
-	c.Assert(strings.Contains(res, "b"), Equals, true)
-	c.Assert(strings.Contains(res, "c"), Equals, true)
+	mop := &MulOp{op: "MGET"}
+	for _, k := range keys {
+		mop.keys = append(mop.keys, []byte(k))
+	}
 
-	buf, err = moper.mgetResults(&MulOp{
-		op: "mget",
-		keys: [][]byte{[]byte("x"),
-			[]byte("c"), []byte("x")}})
-	c.Assert(err, IsNil)
+	proxyMutex.Lock()
+	moper := ss.moper
+	proxyMutex.Unlock()
 
-	buf, err = moper.mgetResults(&MulOp{
-		op: "mget",
-		keys: [][]byte{[]byte("x"),
-			[]byte("y"), []byte("x")}})
+	buf, err := moper.mgetResults(mop)
 	c.Assert(err, IsNil)
 
-	err = s.s.store.Reset()
+	// results are in order, the first key does not exist
+	expected := "*5\r\n$-1\r\n"
+	for _, k := range keys[1:] {
+		expected += "$" + strconv.Itoa(len(k)) + "\r\n" + k + "\r\n"
+	}
+	c.Assert(string(buf), Equals, expected)
+
+	values, err := redis.Strings(cc.Do("MGET", keys[0], keys[2], keys[1]))
 	c.Assert(err, IsNil)
+	c.Assert(values, DeepEquals, []string{"", keys[2], keys[1]})
+
+	s.s1.store.Reset()
+	s.s2.store.Reset()
 }
 
 func (s *testProxyRouterSuite) TestMsetResults(c *C) {
+	proxyMutex.Lock()
+	moper := ss.moper
+	proxyMutex.Unlock()
+
 	// for mset x y z bad case test
-	moper := newMultiOperator(s.s.addr, storeAuth)
 	_, err := moper.msetResults(&MulOp{
 		op: "mset",
 		keys: [][]byte{[]byte("x"),
@@ -58,30 +127,54 @@ func (s *testProxyRouterSuite) TestMsetResults(c *C) {
 	c.Assert(err, NotNil)
 	c.Assert(strings.Contains(err.Error(), "bad number of keys for mset command"), Equals, true)
 
-	err = s.s.store.Reset()
+	keys := s.testGenCrossSlotKeys(c)
+
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	var args []interface{}
+	for i, k := range keys {
+		args = append(args, k, i)
+	}
+
+	ok, err := redis.String(cc.Do("MSET", args...))
 	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, "OK")
+
+	for i, k := range keys {
+		v, err := redis.Int(cc.Do("GET", k))
+		c.Assert(err, IsNil)
+		c.Assert(v, Equals, i)
+	}
+
+	s.s1.store.Reset()
+	s.s2.store.Reset()
 }
 
 func (s *testProxyRouterSuite) TestDeltResults(c *C) {
-	moper := newMultiOperator(s.s.addr, storeAuth)
+	keys := s.testGenCrossSlotKeys(c)
 
-	var err error
-	err = s.s.store.Set(0, store.FormatBytes("a", "a"))
-	c.Assert(err, IsNil)
-	err = s.s.store.Set(0, store.FormatBytes("b", "b"))
-	c.Assert(err, IsNil)
-	err = s.s.store.Set(0, store.FormatBytes("c", "c"))
-	c.Assert(err, IsNil)
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
 
-	buf, err := moper.delResults(&MulOp{
-		op: "del",
-		keys: [][]byte{[]byte("a"),
-			[]byte("b"), []byte("c")}})
-	c.Assert(err, IsNil)
+	for _, k := range keys[1:] {
+		_, err := cc.Do("SET", k, k)
+		c.Assert(err, IsNil)
+	}
 
-	res := string(buf)
-	c.Assert(strings.Contains(res, "3"), Equals, true)
+	var args []interface{}
+	for _, k := range keys {
+		args = append(args, k)
+	}
 
-	err = s.s.store.Reset()
+	n, err := redis.Int(cc.Do("DEL", args...))
 	c.Assert(err, IsNil)
+	c.Assert(n, Equals, len(keys)-1)
+
+	n, err = redis.Int(cc.Do("DEL", args...))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+
+	s.s1.store.Reset()
+	s.s2.store.Reset()
 }
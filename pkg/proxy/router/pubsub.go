@@ -0,0 +1,460 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	respcoding "github.com/ngaut/resp"
+	"github.com/reborndb/reborn/pkg/proxy/parser"
+	"github.com/reborndb/reborn/pkg/proxy/redisconn"
+)
+
+const SubscriberResyncInterval = 1 * time.Second
+
+func isSubOp(op string) bool {
+	switch op {
+	case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		return true
+	}
+
+	return false
+}
+
+// subConn is a dedicated connection to one backend server in subscribe mode.
+type subConn struct {
+	addr   string
+	conn   *redisconn.Conn
+	closed bool
+}
+
+// subscriber holds the subscriptions of a session.
+// Channels are hashed to slots like keys, and subscribed on the master
+// of the slot's group, patterns are subscribed on all group masters.
+type subscriber struct {
+	s *Server
+	c *session
+
+	m        sync.Mutex
+	channels map[string]string // channel -> server address
+	patterns map[string]struct{}
+	conns    map[string]*subConn // server address -> connection
+
+	resync chan struct{}
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newSubscriber(s *Server, c *session) *subscriber {
+	sub := &subscriber{
+		s:        s,
+		c:        c,
+		channels: make(map[string]string),
+		patterns: make(map[string]struct{}),
+		conns:    make(map[string]*subConn),
+		resync:   make(chan struct{}, 1),
+		quit:     make(chan struct{}),
+	}
+
+	sub.wg.Add(1)
+	go sub.resyncLoop()
+
+	return sub
+}
+
+func (sub *subscriber) count() int {
+	return len(sub.channels) + len(sub.patterns)
+}
+
+func (sub *subscriber) notifyResync() {
+	select {
+	case sub.resync <- struct{}{}:
+	default:
+	}
+}
+
+// getConn returns the connection to addr, creates it if not exists, must hold lock.
+func (sub *subscriber) getConn(addr string) (*subConn, error) {
+	if sc, ok := sub.conns[addr]; ok {
+		return sc, nil
+	}
+
+	conn, err := newRedisConn(addr, sub.s.conf.NetTimeout, RedisConnReaderSize, RedisConnWiterSize, sub.s.getCredential(addr))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	sc := &subConn{addr: addr, conn: conn}
+	sub.conns[addr] = sc
+
+	sub.wg.Add(1)
+	go sub.readLoop(sc)
+
+	return sc, nil
+}
+
+// closeConn closes the connection to addr, must hold lock.
+func (sub *subscriber) closeConn(addr string) {
+	if sc, ok := sub.conns[addr]; ok {
+		sc.closed = true
+		sc.conn.Close()
+		delete(sub.conns, addr)
+	}
+}
+
+func (sub *subscriber) doCommand(addr string, cmd string, args ...interface{}) error {
+	sc, err := sub.getConn(addr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if err = writeCommand(sc.conn, cmd, args...); err == nil {
+		err = sc.conn.Flush()
+	}
+
+	if err != nil {
+		sub.closeConn(addr)
+		return errors.Trace(err)
+	}
+
+	return nil
+}
+
+func (sub *subscriber) subscribe(channel string) error {
+	if _, ok := sub.channels[channel]; ok {
+		return nil
+	}
+
+	addr, err := sub.s.getSlotMaster(mapKey2Slot([]byte(channel)))
+	sub.channels[channel] = addr
+	if err == nil {
+		err = sub.doCommand(addr, "SUBSCRIBE", channel)
+	}
+
+	if err != nil {
+		// keep the channel, it will be subscribed again by resync
+		sub.notifyResync()
+		return errors.Trace(err)
+	}
+
+	return nil
+}
+
+func (sub *subscriber) unsubscribe(channel string) {
+	addr, ok := sub.channels[channel]
+	if !ok {
+		return
+	}
+
+	delete(sub.channels, channel)
+	if _, ok := sub.conns[addr]; ok {
+		if err := sub.doCommand(addr, "UNSUBSCRIBE", channel); err != nil {
+			log.Warning(errors.ErrorStack(err))
+		}
+	}
+}
+
+func (sub *subscriber) psubscribe(pattern string) error {
+	if _, ok := sub.patterns[pattern]; ok {
+		return nil
+	}
+
+	sub.patterns[pattern] = struct{}{}
+	for _, addr := range sub.s.getGroupMasters() {
+		if err := sub.doCommand(addr, "PSUBSCRIBE", pattern); err != nil {
+			sub.notifyResync()
+			return errors.Trace(err)
+		}
+	}
+
+	return nil
+}
+
+func (sub *subscriber) punsubscribe(pattern string) {
+	if _, ok := sub.patterns[pattern]; !ok {
+		return
+	}
+
+	delete(sub.patterns, pattern)
+	for addr, _ := range sub.conns {
+		if err := sub.doCommand(addr, "PUNSUBSCRIBE", pattern); err != nil {
+			log.Warning(errors.ErrorStack(err))
+		}
+	}
+}
+
+// doResync moves the subscriptions to the current masters, it is called
+// after topology changed, e.g. group master failover or slot migration.
+func (sub *subscriber) doResync() error {
+	sub.m.Lock()
+	defer sub.m.Unlock()
+
+	used := make(map[string]bool)
+	var lastErr error
+
+	for channel, addr := range sub.channels {
+		newAddr, err := sub.s.getSlotMaster(mapKey2Slot([]byte(channel)))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		_, subscribed := sub.conns[addr]
+		if newAddr == addr && subscribed {
+			used[addr] = true
+			continue
+		}
+
+		if subscribed {
+			if err := sub.doCommand(addr, "UNSUBSCRIBE", channel); err != nil {
+				log.Warning(errors.ErrorStack(err))
+			}
+		}
+
+		sub.channels[channel] = newAddr
+		if err := sub.doCommand(newAddr, "SUBSCRIBE", channel); err != nil {
+			lastErr = err
+		}
+		used[newAddr] = true
+	}
+
+	if len(sub.patterns) > 0 {
+		for _, addr := range sub.s.getGroupMasters() {
+			_, subscribed := sub.conns[addr]
+			used[addr] = true
+			if subscribed {
+				continue
+			}
+
+			for pattern, _ := range sub.patterns {
+				if err := sub.doCommand(addr, "PSUBSCRIBE", pattern); err != nil {
+					lastErr = err
+					break
+				}
+			}
+		}
+	}
+
+	// close connections to the servers which are not master any more
+	for addr, _ := range sub.conns {
+		if !used[addr] {
+			sub.closeConn(addr)
+		}
+	}
+
+	return errors.Trace(lastErr)
+}
+
+func (sub *subscriber) resyncLoop() {
+	defer sub.wg.Done()
+
+	for {
+		select {
+		case <-sub.quit:
+			return
+		case <-sub.resync:
+		}
+
+		if err := sub.doResync(); err != nil {
+			log.Warningf("resync subscriber %s failed, %v", sub.c.RemoteAddr(), err)
+			select {
+			case <-sub.quit:
+				return
+			case <-time.After(SubscriberResyncInterval):
+				sub.notifyResync()
+			}
+		}
+	}
+}
+
+func (sub *subscriber) readLoop(sc *subConn) {
+	defer sub.wg.Done()
+
+	for {
+		resp, err := parser.Parse(sc.conn.BufioReader())
+		if err != nil {
+			sub.m.Lock()
+			closed := sc.closed
+			if !closed {
+				sub.closeConn(sc.addr)
+			}
+			sub.m.Unlock()
+
+			if !closed {
+				log.Warningf("subscribe connection to %s broken, %v", sc.addr, err)
+				sub.notifyResync()
+			}
+			return
+		}
+
+		// subscription replies of backend are dropped, proxy replies them itself
+		if resp.Type == parser.MultiResp && len(resp.Multi) > 0 {
+			kind := strings.ToLower(string(resp.Multi[0].Value()))
+			if kind == "message" || kind == "pmessage" {
+				sub.c.backQ <- &PipelineResponse{resp: resp}
+			}
+		}
+	}
+}
+
+func (sub *subscriber) close() {
+	close(sub.quit)
+
+	sub.m.Lock()
+	for addr, _ := range sub.conns {
+		sub.closeConn(addr)
+	}
+	sub.m.Unlock()
+
+	sub.wg.Wait()
+}
+
+func (s *Server) pushBack(c *session, v interface{}) error {
+	b, err := respcoding.Marshal(v)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	resp, err := parser.Parse(bufio.NewReader(bytes.NewReader(b)))
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	c.backQ <- &PipelineResponse{resp: resp}
+	return nil
+}
+
+// getSlotMaster asks dispatcher for the master address of the slot.
+func (s *Server) getSlotMaster(slot int) (string, error) {
+	ch := make(chan string, 1)
+	backQ := make(chan *PipelineResponse, 1)
+	s.sendRequest(&PipelineRequest{
+		slotIdx: slot,
+		backQ:   backQ,
+		direct: func(addr string) {
+			ch <- addr
+		},
+	})
+
+	select {
+	case addr := <-ch:
+		return addr, nil
+	case r := <-backQ:
+		if r.err != nil {
+			return "", errors.Trace(r.err)
+		}
+		return "", errors.Errorf("get master of slot %d failed, %s", slot, strings.TrimSpace(string(r.resp.Raw)))
+	}
+}
+
+func (s *Server) addSubscriber(sub *subscriber) {
+	s.subMutex.Lock()
+	s.subscribers[sub] = struct{}{}
+	s.subMutex.Unlock()
+}
+
+func (s *Server) removeSubscriber(sub *subscriber) {
+	s.subMutex.Lock()
+	delete(s.subscribers, sub)
+	s.subMutex.Unlock()
+}
+
+// notifySubscribers asks all subscribers to check their subscriptions after topology changed.
+func (s *Server) notifySubscribers() {
+	s.subMutex.Lock()
+	for sub, _ := range s.subscribers {
+		sub.notifyResync()
+	}
+	s.subMutex.Unlock()
+}
+
+func (s *Server) releasePubSub(c *session) {
+	if c.sub != nil {
+		s.removeSubscriber(c.sub)
+		c.sub.close()
+		c.sub = nil
+	}
+}
+
+func (s *Server) handlePubSubCommand(c *session, opstr string, op []byte, keys [][]byte, resp *parser.Resp) error {
+	if !isSubOp(opstr) {
+		s.sendBack(c, op, keys, resp, []byte("-ERR only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT allowed in this context\r\n"))
+		return nil
+	}
+
+	var args []string
+	for _, v := range resp.Multi[1:] {
+		args = append(args, string(v.Value()))
+	}
+
+	if (opstr == "SUBSCRIBE" || opstr == "PSUBSCRIBE") && len(args) == 0 {
+		s.sendBack(c, op, keys, resp, []byte("-ERR wrong number of arguments for '"+strings.ToLower(opstr)+"' command\r\n"))
+		return nil
+	}
+
+	if c.sub == nil {
+		c.sub = newSubscriber(s, c)
+		s.addSubscriber(c.sub)
+	}
+
+	sub := c.sub
+	kind := strings.ToLower(opstr)
+
+	sub.m.Lock()
+	switch opstr {
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		for _, arg := range args {
+			var err error
+			if opstr == "SUBSCRIBE" {
+				err = sub.subscribe(arg)
+			} else {
+				err = sub.psubscribe(arg)
+			}
+			if err != nil {
+				log.Warningf("%s %s failed, %v", opstr, arg, err)
+			}
+			s.pushBack(c, []interface{}{[]byte(kind), []byte(arg), sub.count()})
+		}
+	case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		if len(args) == 0 {
+			if opstr == "UNSUBSCRIBE" {
+				for channel, _ := range sub.channels {
+					args = append(args, channel)
+				}
+			} else {
+				for pattern, _ := range sub.patterns {
+					args = append(args, pattern)
+				}
+			}
+		}
+
+		if len(args) == 0 {
+			s.pushBack(c, []interface{}{[]byte(kind), nil, sub.count()})
+		}
+
+		for _, arg := range args {
+			if opstr == "UNSUBSCRIBE" {
+				sub.unsubscribe(arg)
+			} else {
+				sub.punsubscribe(arg)
+			}
+			s.pushBack(c, []interface{}{[]byte(kind), []byte(arg), sub.count()})
+		}
+	}
+	n := sub.count()
+	sub.m.Unlock()
+
+	// leave subscribe mode
+	if n == 0 {
+		s.releasePubSub(c)
+	}
+
+	return nil
+}
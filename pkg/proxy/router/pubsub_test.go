@@ -0,0 +1,60 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"github.com/garyburd/redigo/redis"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testProxyRouterSuite) TestIsSubOp(c *C) {
+	c.Assert(isSubOp("SUBSCRIBE"), Equals, true)
+	c.Assert(isSubOp("PUNSUBSCRIBE"), Equals, true)
+	c.Assert(isSubOp("PUBLISH"), Equals, false)
+	c.Assert(isSubOp("GET"), Equals, false)
+}
+
+func (s *testProxyRouterSuite) TestPubSubCommands(c *C) {
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	// unsubscribe without subscription
+	reply, err := redis.Values(cc.Do("UNSUBSCRIBE"))
+	c.Assert(err, IsNil)
+	c.Assert(reply, DeepEquals, []interface{}{[]byte("unsubscribe"), nil, int64(0)})
+
+	err = cc.Send("SUBSCRIBE", "c1", "c2")
+	c.Assert(err, IsNil)
+	err = cc.Flush()
+	c.Assert(err, IsNil)
+
+	for i, channel := range []string{"c1", "c2"} {
+		reply, err = redis.Values(cc.Receive())
+		c.Assert(err, IsNil)
+		c.Assert(reply, DeepEquals, []interface{}{[]byte("subscribe"), []byte(channel), int64(i + 1)})
+	}
+
+	reply, err = redis.Values(cc.Do("PSUBSCRIBE", "c*"))
+	c.Assert(err, IsNil)
+	c.Assert(reply, DeepEquals, []interface{}{[]byte("psubscribe"), []byte("c*"), int64(3)})
+
+	_, err = cc.Do("GET", "foo")
+	c.Assert(err, ErrorMatches, "ERR only .* allowed in this context")
+
+	reply, err = redis.Values(cc.Do("UNSUBSCRIBE", "c1"))
+	c.Assert(err, IsNil)
+	c.Assert(reply, DeepEquals, []interface{}{[]byte("unsubscribe"), []byte("c1"), int64(2)})
+
+	reply, err = redis.Values(cc.Do("PUNSUBSCRIBE"))
+	c.Assert(err, IsNil)
+	c.Assert(reply, DeepEquals, []interface{}{[]byte("punsubscribe"), []byte("c*"), int64(1)})
+
+	reply, err = redis.Values(cc.Do("UNSUBSCRIBE"))
+	c.Assert(err, IsNil)
+	c.Assert(reply, DeepEquals, []interface{}{[]byte("unsubscribe"), []byte("c2"), int64(0)})
+
+	// back to normal mode
+	_, err = cc.Do("SET", "foo", "bar")
+	c.Assert(err, IsNil)
+}
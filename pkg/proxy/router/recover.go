@@ -0,0 +1,147 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/go-zookeeper/zk"
+	"github.com/ngaut/log"
+	"github.com/ngaut/zkhelper"
+	"github.com/reborndb/reborn/pkg/models"
+)
+
+const (
+	CoordRetryMinDelay = 1 * time.Second
+	CoordRetryMaxDelay = 30 * time.Second
+)
+
+// recoverCoord reconnects to the coordinator with backoff after the session
+// expired or a coordinator error, the dispatchers keep serving with the last
+// route table meanwhile. It returns after the proxy is registered again, the
+// watches are rebuilt and the actions missed are applied and answered, so
+// the new actions are handled after them.
+func (s *Server) recoverCoord() {
+	s.counter.Add("CoordRecover", 1)
+
+	delay := CoordRetryMinDelay
+	for {
+		err := s.resyncCoord()
+		if err == nil {
+			log.Warningf("coordinator recovered, lastActionSeq %d", s.lastActionSeq)
+			return
+		}
+
+		log.Warningf("recover coordinator failed, retry after %v, %v", delay, errors.ErrorStack(err))
+		s.counter.Add("CoordRecoverFailed", 1)
+		s.waitRecoverRetry(delay)
+
+		delay *= 2
+		if delay > CoordRetryMaxDelay {
+			delay = CoordRetryMaxDelay
+		}
+	}
+}
+
+// waitRecoverRetry waits for the delay, the events which do not need the
+// coordinator are still handled, the watch events are dropped since the
+// watches are rebuilt after reconnected.
+func (s *Server) waitRecoverRetry(delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			return
+		case e := <-s.evtbus:
+			switch e.(type) {
+			case *killEvent:
+				s.handleMarkOffline()
+				e.(*killEvent).done <- nil
+			default:
+				log.Infof("drop event while recovering, %+v", e)
+			}
+		}
+	}
+}
+
+func (s *Server) resyncCoord() error {
+	if err := s.top.Reconnect(); err != nil {
+		return errors.Trace(err)
+	}
+
+	// the ephemeral node is kept if the session is not expired
+	_, err := s.top.CreateProxyInfo(&s.pi)
+	if err != nil && !zkhelper.ZkErrorEqual(err, zk.ErrNodeExists) {
+		return errors.Trace(err)
+	}
+
+	_, err = s.top.CreateProxyFenceNode(&s.pi)
+	if err != nil && !zkhelper.ZkErrorEqual(err, zk.ErrNodeExists) {
+		log.Warning(errors.ErrorStack(err))
+	}
+
+	// watch before reading the topology, so no action is missed
+	if err = s.handleProxyCommand(); err != nil {
+		return errors.Trace(err)
+	}
+
+	s.proxyWatches = make(map[string]bool)
+	if err = s.watchProxies(); err != nil {
+		return errors.Trace(err)
+	}
+
+	nodes, err := s.top.WatchChildren(models.GetWatchActionPath(s.top.ProductName), s.evtbus)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	seqs, err := models.ExtraSeqList(nodes)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// the missed actions may change any slot, reload all of them
+	for i := range s.slots {
+		if err = s.fillSlot(i, true); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	// the backend credentials and users are loaded before the route table
+	// is applied, so the new task runners and sessions use the current ones
+	s.loadCommands()
+	s.loadCredentials()
+	s.loadUsers()
+	s.applyRouteTable()
+
+	// the topology read already includes the missed actions, answer them
+	for _, seq := range seqs {
+		if seq <= s.lastActionSeq {
+			continue
+		}
+
+		act, err := s.top.GetActionWithSeq(int64(seq))
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		if !needResponse(act.Receivers, s.pi) {
+			continue
+		}
+
+		err = s.top.DoResponse(seq, &s.pi)
+		if err != nil && !zkhelper.ZkErrorEqual(err, zk.ErrNodeExists) {
+			return errors.Trace(err)
+		}
+	}
+
+	if len(seqs) > 0 {
+		s.lastActionSeq = seqs[len(seqs)-1]
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"path"
+	"time"
+
+	"github.com/ngaut/go-zookeeper/zk"
+	"github.com/reborndb/reborn/pkg/models"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testProxyRouterSuite) TestRecoverCoord(c *C) {
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	getRecoverCount := func() int64 {
+		return ss.counter.Counts()["CoordRecover"]
+	}
+
+	waitRecover := func(n int64) {
+		for i := 0; i < 50 && getRecoverCount() < n; i++ {
+			time.Sleep(100 * time.Millisecond)
+		}
+		c.Assert(getRecoverCount() >= n, Equals, true)
+	}
+
+	proxyPath := path.Join(models.GetProxyPath(conf.ProductName), conf.ProxyID)
+	n := getRecoverCount()
+
+	// the proxy node is lost, the proxy registers again
+	err := conn.Delete(proxyPath, -1)
+	c.Assert(err, IsNil)
+	waitRecover(n + 1)
+
+	var pi *models.ProxyInfo
+	for i := 0; i < 50; i++ {
+		if pi, err = models.GetProxyInfo(conn, conf.ProductName, conf.ProxyID); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	c.Assert(err, IsNil)
+	c.Assert(pi.State, Equals, models.PROXY_STATE_ONLINE)
+
+	_, err = cc.Do("SET", "foo", "bar")
+	c.Assert(err, IsNil)
+
+	// session expired, the proxy keeps serving and handles actions after recovered
+	ss.evtbus <- zk.Event{Type: zk.EventNotWatching, State: zk.StateExpired}
+	waitRecover(n + 2)
+
+	_, err = cc.Do("SET", "foo", "bar")
+	c.Assert(err, IsNil)
+
+	err = models.SetSlotRange(conn, conf.ProductName, 0, 0, 1, models.SLOT_STATUS_ONLINE)
+	c.Assert(err, IsNil)
+
+	_, err = cc.Do("GET", "foo")
+	c.Assert(err, IsNil)
+
+	s.s1.store.Reset()
+	s.s2.store.Reset()
+}
+
+func (s *testProxyRouterSuite) TestUnknownAction(c *C) {
+	n := ss.counter.Counts()["UnknownAction"]
+
+	// an action of a newer dashboard is answered instead of killing the proxy
+	err := models.NewActionWithTimeout(conn, conf.ProductName, models.ActionType("future_action"), nil, "", true, 3000)
+	c.Assert(err, IsNil)
+	c.Assert(ss.counter.Counts()["UnknownAction"], Equals, n+1)
+
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	_, err = cc.Do("SET", "foo", "bar")
+	c.Assert(err, IsNil)
+}
@@ -6,15 +6,16 @@ package router
 import (
 	"bufio"
 	"bytes"
-	"container/list"
 	"io"
 	"net"
 	"os"
 	"os/signal"
 	"path"
+	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -27,6 +28,7 @@ import (
 	"github.com/reborndb/reborn/pkg/proxy/parser"
 	"github.com/reborndb/reborn/pkg/proxy/redisconn"
 	topo "github.com/reborndb/reborn/pkg/proxy/router/topology"
+	"github.com/reborndb/reborn/pkg/utils"
 )
 
 const (
@@ -45,23 +47,66 @@ const (
 )
 
 type Server struct {
-	slots  [models.DEFAULT_SLOT_NUM]*Slot
+	slots  []*Slot // owned by the topology loop, published as route table
 	top    *topo.Topology
 	evtbus chan interface{}
-	reqCh  chan *PipelineRequest
+
+	route       atomic.Value // *routeTable
+	dispatchers []*dispatcher
 
 	lastActionSeq int
 	pi            models.ProxyInfo
 	startAt       time.Time
 
-	moper       *MultiOperator
-	pools       *redisconn.Pools
-	counter     *stats.Counters
-	onSuicide   onSuicideFun
-	bufferedReq *list.List
-	conf        *Conf
+	moper      *MultiOperator
+	pools      *redisconn.Pools
+	blockPools *redisconn.Pools // dedicated connections for blocking commands
+	counter    *stats.Counters
+	onSuicide  onSuicideFun
+	conf       *Conf
+
+	pipeMutex      sync.RWMutex
+	pipeConns      map[string][]*taskRunner //redis->taskrunners
+	backendCounter *stats.Counters          // queue depth and in-flight requests of task runners
+	localHosts     map[string]struct{}
+
+	breakerMutex sync.Mutex
+	breakers     map[string]*circuitBreaker // redis->circuit breaker
+
+	migrateMutex   sync.RWMutex
+	migrators      map[string]*keyMigrator // source master -> migrator
+	migrateCounter *stats.Counters         // migrate-on-access keys and latency
+
+	slowlog *slowlog
+	tls     *tlsReloader // nil if the client listener is plain
+
+	cmdLatency     *histogramVec   // command -> latency
+	backendLatency *histogramVec   // redis -> latency
+	errCounter     *stats.Counters // command -> requests failed by backend errors
+
+	lastSessionId int64
+
+	subMutex    sync.Mutex
+	subscribers map[*subscriber]struct{}
 
-	pipeConns map[string]*taskRunner //redis->taskrunner
+	cmdMutex sync.RWMutex
+	cmds     commandTable
+
+	credMutex sync.RWMutex
+	creds     *models.Credentials // backend credentials, nil before loaded
+
+	userMutex sync.RWMutex
+	users     map[string]*aclUser // name -> user
+
+	clients     clientLimiter
+	idleTimeout int64 // time.Duration, atomic
+
+	sessionMutex sync.RWMutex
+	sessions     map[int64]*session // id -> live session
+
+	proxyMutex   sync.RWMutex
+	proxies      []models.ProxyInfo // online proxies, presented as cluster nodes
+	proxyWatches map[string]bool    // watched proxy paths, owned by the topology loop
 }
 
 func (s *Server) clearSlot(i int) {
@@ -69,26 +114,37 @@ func (s *Server) clearSlot(i int) {
 		return
 	}
 
-	if s.slots[i] != nil {
-		s.slots[i].dst = nil
-		s.slots[i].migrateFrom = nil
-		s.slots[i] = nil
-	}
+	// the slot may be still used by a former route table
+	s.slots[i] = nil
 }
 
-func (s *Server) stopTaskRunners() {
+// stopTaskRunners drains and closes the task runners of the backends,
+// requests to other backends are not affected.
+func (s *Server) stopTaskRunners(addrs map[string]bool) {
+	// nobody can send to the removed task runners after unlock,
+	// so the close signal is the last one in their queues
+	var runners []*taskRunner
+	s.pipeMutex.Lock()
+	for addr, trs := range s.pipeConns {
+		if !addrs[addr] {
+			continue
+		}
+		for _, tr := range trs {
+			if tr != nil {
+				runners = append(runners, tr)
+			}
+		}
+		delete(s.pipeConns, addr)
+	}
+	s.pipeMutex.Unlock()
+
 	wg := &sync.WaitGroup{}
-	log.Warning("taskrunner count", len(s.pipeConns))
-	wg.Add(len(s.pipeConns))
-	for _, tr := range s.pipeConns {
+	log.Warning("taskrunner count", len(runners))
+	wg.Add(len(runners))
+	for _, tr := range runners {
 		tr.in <- wg
 	}
 	wg.Wait()
-
-	// remove all
-	for k, _ := range s.pipeConns {
-		delete(s.pipeConns, k)
-	}
 }
 
 func (s *Server) dumpCounter() {
@@ -98,20 +154,18 @@ func (s *Server) dumpCounter() {
 	}
 }
 
-func (s *Server) fillSlot(i int, force bool) {
+func (s *Server) fillSlot(i int, force bool) error {
 	if !validSlot(i) {
-		return
+		return nil
 	}
 
 	if !force && s.slots[i] != nil { //check
 		log.Fatalf("slot %d already filled, slot: %+v", i, s.slots[i])
 	}
 
-	s.clearSlot(i)
-
 	slotInfo, groupInfo, err := s.top.GetSlotByIndex(i)
 	if err != nil {
-		log.Fatal(errors.ErrorStack(err))
+		return errors.Trace(err)
 	}
 
 	slot := &Slot{
@@ -122,98 +176,143 @@ func (s *Server) fillSlot(i int, force bool) {
 
 	log.Infof("fill slot %d, force %v, %+v", i, force, slot.dst)
 
-	if slot.slotInfo.State.Status == models.SLOT_STATUS_MIGRATE {
+	// the source group of a pre_migrate slot is kept too, SCAN needs its master
+	status := slot.slotInfo.State.Status
+	if status == models.SLOT_STATUS_MIGRATE ||
+		(status == models.SLOT_STATUS_PRE_MIGRATE && slot.slotInfo.State.MigrateStatus.From != models.INVALID_ID) {
 		// get migrate src group and fill it
 		from, err := s.top.GetGroup(slot.slotInfo.State.MigrateStatus.From)
-		if err != nil { // TODO: retry ?
-			log.Fatal(err)
+		if err != nil {
+			return errors.Trace(err)
 		}
 		slot.migrateFrom = group.NewGroup(*from)
 	}
 
 	s.slots[i] = slot
 	s.counter.Add("FillSlot", 1)
+	return nil
 }
 
-func (s *Server) createTaskRunner(slot *Slot) error {
-	dst := slot.dst.Master()
-	if _, ok := s.pipeConns[dst]; !ok {
-		tr, err := NewTaskRunner(dst, s.conf.NetTimeout, s.conf.StoreAuth)
-		if err != nil {
-			return errors.Errorf("create task runner failed, %v,  %+v, %+v", err, slot.dst, slot.slotInfo)
-		} else {
-			s.pipeConns[dst] = tr
-		}
+// taskRunnerIndex returns which task runner of the backend serves the request,
+// requests of the same slot or session always go through the same connection.
+func (s *Server) taskRunnerIndex(r *PipelineRequest) int {
+	n := s.conf.BackendConns
+	if n <= 1 {
+		return 0
 	}
 
-	return nil
+	if s.conf.BackendConnPolicy == BackendConnPolicySession {
+		return int(r.sessionId % int64(n))
+	}
+
+	return r.slotIdx % n
 }
 
-func (s *Server) createTaskRunners() {
-	for _, slot := range s.slots {
-		if err := s.createTaskRunner(slot); err != nil {
-			log.Error(err)
-			return
-		}
+// getTaskRunner returns the task runner i of the backend, creates it if not
+// exists, must hold pipeMutex.
+func (s *Server) getTaskRunner(addr string, i int) (*taskRunner, error) {
+	trs, ok := s.pipeConns[addr]
+	if !ok {
+		trs = make([]*taskRunner, s.conf.BackendConns)
+		s.pipeConns[addr] = trs
 	}
-}
 
-func (s *Server) handleMigrateState(slotIndex int, keys ...[]byte) error {
-	shd := s.slots[slotIndex]
-	if shd.slotInfo.State.Status != models.SLOT_STATUS_MIGRATE {
-		return nil
+	if trs[i] != nil {
+		return trs[i], nil
 	}
 
-	if shd.migrateFrom == nil {
-		log.Fatalf("migrateFrom not exist %+v", shd)
+	b := s.getBreaker(addr)
+	tr, err := NewTaskRunner(addr, i, s.conf.NetTimeout, s.getCredential(addr), s.backendCounter, b)
+	if err != nil {
+		b.failure()
+		return nil, errors.Trace(err)
 	}
 
-	if shd.dst.Master() == shd.migrateFrom.Master() {
-		log.Fatalf("the same migrate src and dst, %+v", shd)
+	trs[i] = tr
+	return tr, nil
+}
+
+// sendToTaskRunner sends the request to the task runner i of the backend.
+func (s *Server) sendToTaskRunner(addr string, i int, r *PipelineRequest) error {
+	for {
+		s.pipeMutex.RLock()
+		if trs, ok := s.pipeConns[addr]; ok && trs[i] != nil {
+			trs[i].in <- r
+			s.pipeMutex.RUnlock()
+			return nil
+		}
+		s.pipeMutex.RUnlock()
+
+		s.pipeMutex.Lock()
+		_, err := s.getTaskRunner(addr, i)
+		s.pipeMutex.Unlock()
+		if err != nil {
+			return errors.Trace(err)
+		}
 	}
+}
 
-	redisConn, err := s.pools.GetConn(shd.migrateFrom.Master())
-	if err != nil {
-		return errors.Trace(err)
+func (s *Server) createTaskRunner(slot *Slot) error {
+	s.pipeMutex.Lock()
+	defer s.pipeMutex.Unlock()
+
+	for i := 0; i < s.conf.BackendConns; i++ {
+		if _, err := s.getTaskRunner(slot.dst.Master(), i); err != nil {
+			return errors.Errorf("create task runner failed, %v,  %+v, %+v", err, slot.dst, slot.slotInfo)
+		}
 	}
 
-	defer s.pools.PutConn(redisConn)
+	return nil
+}
 
-	err = writeMigrateKeyCmd(redisConn, shd.dst.Master(), MigrateKeyTimeoutMs, keys...)
+func (s *Server) isLocalAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
 	if err != nil {
-		redisConn.Close()
-		log.Errorf("migrate key %s error, from %s to %s, err:%v",
-			string(keys[0]), shd.migrateFrom.Master(), shd.dst.Master(), err)
-		return errors.Trace(err)
+		return false
 	}
 
-	redisReader := redisConn.BufioReader()
+	_, ok := s.localHosts[host]
+	return ok
+}
 
-	// handle migrate result
-	for i := 0; i < len(keys); i++ {
-		resp, err := parser.Parse(redisReader)
-		if err != nil {
-			log.Errorf("migrate key %s error, from %s to %s, err:%v",
-				string(keys[i]), shd.migrateFrom.Master(), shd.dst.Master(), err)
-			redisConn.Close()
-			return errors.Trace(err)
-		}
+// getBackend returns the server address that the request should be sent to
+func (s *Server) getBackend(slot *Slot, r *PipelineRequest) string {
+	// keys in a migrating slot must be migrated to and read from master
+	if !r.readOnly || slot.slotInfo.State.Status != models.SLOT_STATUS_ONLINE {
+		return slot.dst.Master()
+	}
 
-		result, err := resp.Bytes()
+	return slot.dst.Reader(s.conf.ReadPolicy, s.isLocalAddr)
+}
 
-		log.Debug("migrate", string(keys[0]), "from", shd.migrateFrom.Master(), "to", shd.dst.Master(),
-			string(result))
+// getGroupMasters returns the master address of every group in the route table, indexed by group id.
+// The source groups of migrating slots are included, they still hold keys not moved yet.
+func (s *Server) getGroupMasters() map[int]string {
+	masters := make(map[int]string)
+	for _, slot := range s.getRouteTable().slots {
+		if slot == nil {
+			continue
+		}
 
-		if resp.Type == parser.ErrorResp {
-			redisConn.Close()
-			log.Error(string(keys[0]), string(resp.Raw), "migrateFrom", shd.migrateFrom.Master())
-			return errors.New(string(resp.Raw))
+		masters[slot.slotInfo.GroupId] = slot.dst.Master()
+		if slot.migrateFrom != nil {
+			masters[slot.slotInfo.State.MigrateStatus.From] = slot.migrateFrom.Master()
 		}
 	}
 
-	s.counter.Add("Migrate", int64(len(keys)))
+	return masters
+}
 
-	return nil
+func (s *Server) createTaskRunners() {
+	for _, slot := range s.slots {
+		if slot == nil {
+			continue
+		}
+		if err := s.createTaskRunner(slot); err != nil {
+			log.Error(err)
+			return
+		}
+	}
 }
 
 func (s *Server) sendBack(c *session, op []byte, keys [][]byte, resp *parser.Resp, result []byte) {
@@ -231,12 +330,56 @@ func (s *Server) sendBack(c *session, op []byte, keys [][]byte, resp *parser.Res
 	c.backQ <- &PipelineResponse{ctx: pr, err: err, resp: resp}
 }
 
-func (s *Server) handleAuthCommand(opstr string, auth []byte) ([]byte, error) {
-	if string(auth) != s.conf.ProxyAuth {
-		return []byte("-ERR invalid auth\r\n"), errors.Errorf("invalid auth")
+// handleAuthCommand authenticates the session by AUTH password with the
+// proxy or admin auth, or by AUTH user password with a proxy user.
+func (s *Server) handleAuthCommand(c *session, args [][]byte) ([]byte, error) {
+	c.authenticated, c.admin, c.user = false, false, ""
+
+	switch len(args) {
+	case 1:
+		// an empty proxy auth is not set, it must not match AUTH ""
+		admin := s.isAdminAuth(args[0])
+		if !admin && (len(s.conf.ProxyAuth) == 0 || string(args[0]) != s.conf.ProxyAuth) {
+			break
+		}
+		c.authenticated = true
+		c.admin = admin
+		return OK_BYTES, nil
+	case 2:
+		u := s.getUser(string(args[0]))
+		if u == nil || !u.CheckPassword(string(args[1])) {
+			break
+		}
+		c.authenticated = true
+		c.user = u.Name
+		return OK_BYTES, nil
+	default:
+		return []byte("-ERR wrong number of arguments for 'auth' command\r\n"), nil
 	}
 
-	return OK_BYTES, nil
+	s.counter.Add("AuthFailed", 1)
+	return []byte("-ERR invalid auth\r\n"), errors.Errorf("invalid auth")
+}
+
+// checkAdmin returns the error reply if the session cannot run the admin
+// command, nil if allowed, the proxy users run it by their categories.
+func (s *Server) checkAdmin(c *session, name string) []byte {
+	if len(c.user) > 0 {
+		if u := s.getUser(c.user); u != nil && u.categories&cmdAdmin != 0 {
+			return nil
+		}
+		return []byte("-NOPERM this user has no permissions to run the '" + strings.ToLower(name) + "' command\r\n")
+	}
+
+	if len(s.conf.AdminAuth) == 0 {
+		return []byte("-ERR " + name + " is disabled, admin auth is not set\r\n")
+	}
+
+	if !c.admin {
+		return []byte("-ERR " + name + " requires admin auth\r\n")
+	}
+
+	return nil
 }
 
 func (s *Server) redisTunnel(c *session) error {
@@ -247,19 +390,48 @@ func (s *Server) redisTunnel(c *session) error {
 	k := keys[0]
 
 	opstr := strings.ToUpper(string(op))
+	c.touch(strings.ToLower(opstr))
+
+	// keys is a fake key if no argument
+	var args [][]byte
+	if len(resp.Multi) > 1 {
+		args = keys
+	}
 
 	if opstr == "AUTH" {
-		buf, err := s.handleAuthCommand(opstr, k)
+		buf, err := s.handleAuthCommand(c, args)
 		s.sendBack(c, op, keys, resp, buf)
-		c.authenticated = (err == nil)
 		return errors.Trace(err)
-	} else if len(s.conf.ProxyAuth) > 0 && !c.authenticated {
+	} else if !c.authenticated && s.authRequired() {
 		buf := []byte("-ERR NOAUTH Authentication required\r\n")
 		s.sendBack(c, op, keys, resp, buf)
 		return errors.Errorf("NOAUTH Authentication required")
 	}
 
-	buf, next, err := filter(opstr, keys, c, s.conf.NetTimeout)
+	cmd := s.getCommand(opstr)
+
+	// the commands queued in MULTI are checked too
+	if buf := s.checkACL(c, cmd, args); buf != nil {
+		s.sendBack(c, op, keys, resp, buf)
+		return nil
+	}
+
+	// a session in subscribe mode can not start MULTI, and pub/sub commands
+	// in MULTI are rejected by transaction
+	inMulti := c.txn != nil && c.txn.multi
+	if (isSubOp(opstr) && !inMulti) || (c.sub != nil && opstr != "PING" && opstr != "QUIT") {
+		s.counter.Add(opstr, 1)
+		s.counter.Add("ops", 1)
+		return errors.Trace(s.handlePubSubCommand(c, opstr, op, keys, resp))
+	}
+
+	if isTxnOp(opstr) || (inMulti && opstr != "QUIT") {
+		s.counter.Add(opstr, 1)
+		s.counter.Add("ops", 1)
+		return errors.Trace(s.handleTxnCommand(c, opstr, op, keys, resp))
+	}
+
+	buf, next, err := filter(cmd, keys, len(resp.Multi), s.conf.NetTimeout)
 	if err != nil {
 		if len(buf) > 0 { //quit command or error message
 			s.sendBack(c, op, keys, resp, buf)
@@ -267,11 +439,6 @@ func (s *Server) redisTunnel(c *session) error {
 		return errors.Trace(err)
 	}
 
-	start := time.Now()
-	defer func() {
-		recordResponseTime(s.counter, time.Since(start)/1000/1000)
-	}()
-
 	s.counter.Add(opstr, 1)
 	s.counter.Add("ops", 1)
 	if !next {
@@ -279,35 +446,104 @@ func (s *Server) redisTunnel(c *session) error {
 		return nil
 	}
 
-	if isMulOp(opstr) {
-		if !isTheSameSlot(keys) { // can not send to redis directly
-			var result []byte
-			err := s.moper.handleMultiOp(opstr, keys, &result)
-			if err != nil {
-				return errors.Trace(err)
-			}
-			s.sendBack(c, op, keys, resp, result)
+	if cmd.admin() {
+		if buf := s.checkAdmin(c, opstr); buf != nil {
+			s.sendBack(c, op, keys, resp, buf)
 			return nil
 		}
 	}
 
+	if opstr == "COMMAND" {
+		return errors.Trace(s.handleCommandCommand(c, op, keys, resp))
+	}
+
+	if opstr == "CLUSTER" {
+		return errors.Trace(s.handleClusterCommand(c, op, keys, resp))
+	}
+
+	if opstr == "CLIENT" {
+		return errors.Trace(s.handleClientCommand(c, op, keys, resp))
+	}
+
+	if opstr == "SLOWLOG" {
+		return errors.Trace(s.handleSlowlogCommand(c, op, keys, resp))
+	}
+
+	if isFanoutOp(opstr) {
+		return errors.Trace(s.handleFanoutCommand(c, opstr, op, keys, resp))
+	}
+
+	if opstr == "SCAN" {
+		return errors.Trace(s.handleScanCommand(c, op, keys, resp))
+	}
+
+	if cmd.blocking() {
+		return errors.Trace(s.handleBlockingCommand(c, opstr, op, keys, resp))
+	}
+
+	start := time.Now()
+	var pr *PipelineRequest
+	defer func() {
+		d := time.Since(start)
+		recordResponseTime(s.counter, d/1000/1000)
+		s.observeCommand(cmd, pr, d)
+	}()
+
+	var rkeys [][]byte
+	if len(resp.Multi) > 1 {
+		rkeys = cmd.keys(keys)
+	}
+
+	// commands without key are sent to the slot of the first argument
 	i := mapKey2Slot(k)
+	if len(rkeys) > 0 {
+		i = mapKey2Slot(rkeys[0])
+	}
+
+	if s.overloaded(i) {
+		s.counter.Add("Shed", 1)
+		s.sendBack(c, op, keys, resp, OVERLOADED_BYTES)
+		return nil
+	}
+
+	if cmd.multiKey() && !isTheSameSlot(rkeys) {
+		if !isMulOp(opstr) {
+			s.counter.Add("CrossSlot", 1)
+			s.sendBack(c, op, keys, resp, CROSSSLOT_BYTES)
+			return nil
+		}
+
+		// can not send to redis directly
+		var result []byte
+		err := s.moper.handleMultiOp(opstr, keys, &result)
+		pr = &PipelineRequest{slotIdx: -1, failed: err != nil}
+		if err != nil {
+			log.Warning(c.RemoteAddr(), opstr, errors.ErrorStack(err))
+			result, _ = errorResp(err).Bytes()
+		}
+		s.sendBack(c, op, keys, resp, result)
+		s.recordSlowlog(c, op, keys, pr, start)
+		return nil
+	}
 
 	// pipeline
 	c.pipelineSeq++
-	pr := &PipelineRequest{
-		slotIdx: i,
-		op:      op,
-		keys:    keys,
-		seq:     c.pipelineSeq,
-		backQ:   c.backQ,
-		req:     resp,
-		wg:      &sync.WaitGroup{},
+	pr = &PipelineRequest{
+		slotIdx:   i,
+		sessionId: c.id,
+		op:        op,
+		keys:      rkeys,
+		seq:       c.pipelineSeq,
+		backQ:     c.backQ,
+		req:       resp,
+		wg:        &sync.WaitGroup{},
+		readOnly:  cmd.readOnly(),
 	}
 	pr.wg.Add(1)
 
-	s.reqCh <- pr
+	s.sendRequest(pr)
 	pr.wg.Wait()
+	s.recordSlowlog(c, op, keys, pr, start)
 
 	return nil
 }
@@ -315,8 +551,13 @@ func (s *Server) redisTunnel(c *session) error {
 func (s *Server) handleConn(c net.Conn) {
 	log.Info("new connection", c.RemoteAddr())
 
+	if !s.acceptClient(c) {
+		return
+	}
+
 	s.counter.Add("connections", 1)
 	client := &session{
+		id:            atomic.AddInt64(&s.lastSessionId, 1),
 		Conn:          c,
 		r:             bufio.NewReaderSize(c, DefaultReaderSize),
 		w:             bufio.NewWriterSize(c, DefaultWiterSize),
@@ -324,38 +565,50 @@ func (s *Server) handleConn(c net.Conn) {
 		backQ:         make(chan *PipelineResponse, PipelineResponseNum),
 		closeSignal:   &sync.WaitGroup{},
 		authenticated: false,
+		activeAt:      time.Now(),
+		multi:         -1,
 	}
 	client.closeSignal.Add(1)
+	s.registerSession(client)
 
 	go client.WritingLoop()
 
 	var err error
 	defer func() {
 		client.closeSignal.Wait() //waiting for writer goroutine
-		if errors2.ErrorNotEqual(err, io.EOF) {
+		s.unregisterSession(client)
+		s.releaseTxn(client)
+		if isTimeout(err) {
+			s.counter.Add("IdleClosed", 1)
+			log.Infof("close idle connection %v", client)
+		} else if errors2.ErrorNotEqual(err, io.EOF) {
 			log.Warningf("close connection %v, %v", client, errors.ErrorStack(err))
 		} else {
 			log.Infof("close connection %v", client)
 		}
 
+		s.clients.release(remoteIP(c))
 		s.counter.Add("connections", -1)
 	}()
 
 	for {
+		s.setIdleDeadline(client)
 		err = s.redisTunnel(client)
 		if err != nil {
+			s.releasePubSub(client)
 			close(client.backQ)
 			return
 		}
-		client.Ops++
+		atomic.AddInt64(&client.Ops, 1)
+		client.updateInfo()
 	}
 }
 
-func (s *Server) OnSlotRangeChange(param *models.SlotMultiSetParam) {
+func (s *Server) OnSlotRangeChange(param *models.SlotMultiSetParam) error {
 	log.Warningf("slotRangeChange %+v", param)
 	if !validSlot(param.From) || !validSlot(param.To) {
 		log.Errorf("invalid slot number, %+v", param)
-		return
+		return nil
 	}
 
 	for i := param.From; i <= param.To; i++ {
@@ -363,21 +616,29 @@ func (s *Server) OnSlotRangeChange(param *models.SlotMultiSetParam) {
 		case models.SLOT_STATUS_OFFLINE:
 			s.clearSlot(i)
 		case models.SLOT_STATUS_ONLINE:
-			s.fillSlot(i, true)
+			if err := s.fillSlot(i, true); err != nil {
+				return errors.Trace(err)
+			}
 		default:
 			log.Errorf("can not handle status %v", param.Status)
 		}
 	}
+
+	return nil
 }
 
-func (s *Server) OnGroupChange(groupId int) {
+func (s *Server) OnGroupChange(groupId int) error {
 	log.Warning("group changed", groupId)
 
 	for i, slot := range s.slots {
-		if slot.slotInfo.GroupId == groupId {
-			s.fillSlot(i, true)
+		if slot != nil && slot.slotInfo.GroupId == groupId {
+			if err := s.fillSlot(i, true); err != nil {
+				return errors.Trace(err)
+			}
 		}
 	}
+
+	return nil
 }
 
 func (s *Server) registerSignal() {
@@ -390,21 +651,43 @@ func (s *Server) registerSignal() {
 		s.evtbus <- &killEvent{done: done}
 		<-done
 	}()
+
+	if s.tls != nil {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				s.reloadTLS()
+			}
+		}()
+	}
 }
 
 func (s *Server) Run() {
-	log.Infof("listening %s on %s", s.conf.Proto, s.conf.Addr)
-	listener, err := net.Listen(s.conf.Proto, s.conf.Addr)
+	log.Infof("listening %s on %s, tls %v", s.conf.Proto, s.conf.Addr, s.tls != nil)
+	listener, err := s.listen()
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	var delay time.Duration
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
 			log.Warning(errors.ErrorStack(err))
+
+			// e.g. too many open files, wait for the connections closed
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				time.Sleep(delay)
+			}
 			continue
 		}
+		delay = 0
 		go s.handleConn(conn)
 	}
 }
@@ -423,53 +706,88 @@ func (s *Server) getProxyInfo() models.ProxyInfo {
 	return pi
 }
 
-func (s *Server) getActionObject(seq int, target interface{}) {
+func (s *Server) getActionObject(seq int, target interface{}) error {
 	act := &models.Action{Target: target}
 	err := s.top.GetActionWithSeqObject(int64(seq), act)
 	if err != nil {
-		log.Fatal(errors.ErrorStack(err))
+		return errors.Trace(err)
 	}
 
 	log.Infof("%+v", act)
+	return nil
 }
 
-func (s *Server) checkAndDoTopoChange(seq int) bool {
+func (s *Server) checkAndDoTopoChange(seq int) (bool, error) {
 	act, err := s.top.GetActionWithSeq(int64(seq))
-	if err != nil { // TODO: error is not "not exist"
-		log.Fatal(errors.ErrorStack(err), "action seq", seq)
+	if err != nil {
+		return false, errors.Trace(err)
 	}
 
 	if !needResponse(act.Receivers, s.pi) { // no need to response
-		return false
+		return false, nil
 	}
 
 	log.Warningf("action %v receivers %v", seq, act.Receivers)
 
-	s.stopTaskRunners()
-
 	switch act.Type {
 	case models.ACTION_TYPE_SLOT_MIGRATE, models.ACTION_TYPE_SLOT_CHANGED,
 		models.ACTION_TYPE_SLOT_PREMIGRATE:
 		slot := &models.Slot{}
-		s.getActionObject(seq, slot)
-		s.fillSlot(slot.Id, true)
+		if err = s.getActionObject(seq, slot); err == nil {
+			err = s.fillSlot(slot.Id, true)
+		}
 	case models.ACTION_TYPE_SERVER_GROUP_CHANGED:
 		serverGroup := &models.ServerGroup{}
-		s.getActionObject(seq, serverGroup)
-		s.OnGroupChange(serverGroup.Id)
+		if err = s.getActionObject(seq, serverGroup); err == nil {
+			err = s.OnGroupChange(serverGroup.Id)
+		}
 	case models.ACTION_TYPE_SERVER_GROUP_REMOVE:
 		// do not care
 	case models.ACTION_TYPE_MULTI_SLOT_CHANGED:
 		param := &models.SlotMultiSetParam{}
-		s.getActionObject(seq, param)
-		s.OnSlotRangeChange(param)
+		if err = s.getActionObject(seq, param); err == nil {
+			err = s.OnSlotRangeChange(param)
+		}
+	case models.ACTION_TYPE_COMMANDS_CHANGED:
+		// command table has nothing to do with task runners
+		s.loadCommands()
+		return true, nil
+	case models.ACTION_TYPE_CREDENTIALS_CHANGED:
+		// the route table is not changed, only the connections are
+		s.loadCredentials()
+		return true, nil
+	case models.ACTION_TYPE_USERS_CHANGED:
+		s.loadUsers()
+		return true, nil
 	default:
-		log.Fatalf("unknown action %+v", act)
+		// a newer dashboard may send actions unknown here, answer them so
+		// the dashboard is not blocked, the proxy should be upgraded
+		log.Errorf("unknown action %+v, ignore it", act)
+		s.counter.Add("UnknownAction", 1)
+		return true, nil
 	}
 
-	s.createTaskRunners()
+	// the slots filled before the error are published after recovered
+	if err != nil {
+		return false, errors.Trace(err)
+	}
+
+	s.applyRouteTable()
+	return true, nil
+}
 
-	return true
+// applyRouteTable publishes the slots, dispatchers move to the new slots at
+// once, then the requests dispatched to the servers of the changed slots
+// are drained, the breakers and metrics of the servers left are dropped.
+func (s *Server) applyRouteTable() {
+	old := s.swapRouteTable()
+	s.stopTaskRunners(s.staleBackends(old, s.getRouteTable()))
+	used := s.getRouteTable().backends()
+	s.removeBreakers(used)
+	s.backendLatency.retain(used)
+	s.stopKeyMigrators(s.getRouteTable())
+	s.createTaskRunners()
+	s.notifySubscribers()
 }
 
 func (s *Server) handleMarkOffline() {
@@ -486,37 +804,40 @@ func (s *Server) handleMarkOffline() {
 	s.onSuicide()
 }
 
-func (s *Server) handleProxyCommand() {
+func (s *Server) handleProxyCommand() error {
 	pi, err := s.top.GetProxyInfo(s.pi.ID)
 	if err != nil {
-		log.Fatal(errors.ErrorStack(err))
+		return errors.Trace(err)
 	}
 
 	if pi.State == models.PROXY_STATE_MARK_OFFLINE {
 		s.handleMarkOffline()
+		return nil
 	}
+
+	// re-watch
+	_, err = s.top.WatchNode(path.Join(models.GetProxyPath(s.top.ProductName), s.pi.ID), s.evtbus)
+	return errors.Trace(err)
 }
 
-func (s *Server) processAction(e interface{}) {
+func (s *Server) processAction(e interface{}) error {
 	if strings.Index(GetEventPath(e), models.GetProxyPath(s.top.ProductName)) == 0 {
-		// proxy event, should be order for me to suicide
-		s.handleProxyCommand()
-		return
+		return errors.Trace(s.handleProxyEvent(e))
 	}
 
 	// re-watch
 	nodes, err := s.top.WatchChildren(models.GetWatchActionPath(s.top.ProductName), s.evtbus)
 	if err != nil {
-		log.Fatal(errors.ErrorStack(err))
+		return errors.Trace(err)
 	}
 
 	seqs, err := models.ExtraSeqList(nodes)
 	if err != nil {
-		log.Fatal(errors.ErrorStack(err))
+		return errors.Trace(err)
 	}
 
 	if len(seqs) == 0 || !s.top.IsChildrenChangedEvent(e) {
-		return
+		return nil
 	}
 
 	// get last pos
@@ -529,74 +850,37 @@ func (s *Server) processAction(e interface{}) {
 	}
 
 	if index < 0 {
-		return
+		return nil
 	}
 
 	actions := seqs[index:]
 	for _, seq := range actions {
 		exist, err := s.top.Exist(path.Join(s.top.GetActionResponsePath(seq), s.pi.ID))
 		if err != nil {
-			log.Fatal(errors.ErrorStack(err))
+			return errors.Trace(err)
 		}
 
 		if exist {
 			continue
 		}
 
-		if s.checkAndDoTopoChange(seq) {
-			s.responseAction(int64(seq))
+		ok, err := s.checkAndDoTopoChange(seq)
+		if err != nil {
+			return errors.Trace(err)
 		}
-	}
-
-	s.lastActionSeq = seqs[len(seqs)-1]
-}
-
-func (s *Server) dispatch(r *PipelineRequest) (success bool) {
-	slotStatus := s.slots[r.slotIdx].slotInfo.State.Status
-	if slotStatus != models.SLOT_STATUS_ONLINE && slotStatus != models.SLOT_STATUS_MIGRATE {
-		return false
-	}
-
-	if err := s.handleMigrateState(r.slotIdx, r.keys...); err != nil {
-		r.backQ <- &PipelineResponse{ctx: r, resp: nil, err: err}
-		return true
-	}
 
-	tr, ok := s.pipeConns[s.slots[r.slotIdx].dst.Master()]
-	if !ok {
-		// try recreate taskrunner
-		if err := s.createTaskRunner(s.slots[r.slotIdx]); err != nil {
-			r.backQ <- &PipelineResponse{ctx: r, resp: nil, err: err}
-			return true
+		if ok {
+			s.responseAction(int64(seq))
 		}
-
-		tr = s.pipeConns[s.slots[r.slotIdx].dst.Master()]
 	}
-	tr.in <- r
 
-	return true
+	s.lastActionSeq = seqs[len(seqs)-1]
+	return nil
 }
 
 func (s *Server) handleTopoEvent() {
 	for {
 		select {
-		case r := <-s.reqCh:
-			if s.slots[r.slotIdx].slotInfo.State.Status == models.SLOT_STATUS_PRE_MIGRATE {
-				s.bufferedReq.PushBack(r)
-				continue
-			}
-
-			for e := s.bufferedReq.Front(); e != nil; {
-				next := e.Next()
-				if s.dispatch(e.Value.(*PipelineRequest)) {
-					s.bufferedReq.Remove(e)
-				}
-				e = next
-			}
-
-			if !s.dispatch(r) {
-				log.Fatalf("should never happend, %+v, %+v", r, s.slots[r.slotIdx].slotInfo)
-			}
 		case e := <-s.evtbus:
 			switch e.(type) {
 			case *killEvent:
@@ -604,7 +888,9 @@ func (s *Server) handleTopoEvent() {
 				e.(*killEvent).done <- nil
 			default:
 				if s.top.IsSessionExpiredEvent(e) {
-					log.Fatalf("session expired: %+v", e)
+					log.Warningf("session expired: %+v", e)
+					s.recoverCoord()
+					continue
 				}
 
 				evtPath := GetEventPath(e)
@@ -622,7 +908,10 @@ func (s *Server) handleTopoEvent() {
 
 				}
 
-				s.processAction(e)
+				if err := s.processAction(e); err != nil {
+					log.Warningf("process event failed, %v", errors.ErrorStack(err))
+					s.recoverCoord()
+				}
 			}
 		}
 	}
@@ -668,10 +957,27 @@ func (s *Server) waitOnline() {
 	}
 }
 
+// loadSlotConfig reads the slot layout of the product after registering,
+// the layout can not be resharded while any proxy is registered.
+func (s *Server) loadSlotConfig() {
+	cfg, err := s.top.GetSlotConfig()
+	if err != nil {
+		log.Fatal(errors.ErrorStack(err))
+	}
+
+	log.Infof("slot config: %s", cfg)
+	setSlotConfig(cfg)
+	s.slots = make([]*Slot, cfg.Num)
+}
+
 func (s *Server) FillSlots() {
-	for i := 0; i < models.DEFAULT_SLOT_NUM; i++ {
-		s.fillSlot(i, false)
+	for i := 0; i < len(s.slots); i++ {
+		if err := s.fillSlot(i, false); err != nil {
+			log.Fatal(errors.ErrorStack(err))
+		}
 	}
+
+	s.swapRouteTable()
 }
 
 func (s *Server) RegisterAndWait(wait bool) {
@@ -690,13 +996,13 @@ func (s *Server) RegisterAndWait(wait bool) {
 	}
 }
 
-func newRedisConn(addr string, timeout int, readSize int, writeSize int, auth string) (*redisconn.Conn, error) {
-	c, err := redisconn.NewConnectionWithSize(addr, timeout, readSize, writeSize)
+func newRedisConn(addr string, timeout int, readSize int, writeSize int, cred *utils.Credential) (*redisconn.Conn, error) {
+	c, err := redisconn.NewConnectionWithDial(addr, timeout, readSize, writeSize, cred.Dial)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
-	if err = doAuth(c, auth); err != nil {
+	if err = doAuth(c, cred.GetAuth()); err != nil {
 		c.Close()
 		return nil, errors.Trace(err)
 	}
@@ -707,22 +1013,65 @@ func newRedisConn(addr string, timeout int, readSize int, writeSize int, auth st
 func NewServer(conf *Conf) *Server {
 	log.Infof("start with configuration: %+v", conf)
 
-	f := func(addr string) (*redisconn.Conn, error) {
-		return newRedisConn(addr, conf.NetTimeout, RedisConnReaderSize, RedisConnWiterSize, conf.StoreAuth)
+	if conf.BackendConns <= 0 {
+		conf.BackendConns = 1
+	}
+
+	if conf.PreMigrateBufferSize <= 0 {
+		conf.PreMigrateBufferSize = DefaultPreMigrateBufferSize
+	}
+
+	if conf.PreMigrateTimeoutMs <= 0 {
+		conf.PreMigrateTimeoutMs = DefaultPreMigrateTimeoutMs
+	}
+
+	if conf.SlowlogMaxLen <= 0 {
+		conf.SlowlogMaxLen = DefaultSlowlogMaxLen
+	}
+
+	var reloader *tlsReloader
+	if len(conf.TLSCertFile) > 0 {
+		var err error
+		if reloader, err = newTLSReloader(conf.TLSCertFile, conf.TLSKeyFile, conf.TLSClientCAFile); err != nil {
+			log.Fatalf("load tls certificate failed, %v", errors.ErrorStack(err))
+		}
 	}
 
 	s := &Server{
-		conf:          conf,
-		evtbus:        make(chan interface{}, EventBusNum),
-		top:           topo.NewTopo(conf.ProductName, conf.CoordinatorAddr, conf.f, conf.Coordinator),
-		counter:       stats.NewCounters("router"),
-		lastActionSeq: -1,
-		startAt:       time.Now(),
-		moper:         newMultiOperator(conf.Addr, conf.ProxyAuth),
-		reqCh:         make(chan *PipelineRequest, PipelineRequestNum),
-		pools:         redisconn.NewPools(PoolCapability, f),
-		pipeConns:     make(map[string]*taskRunner),
-		bufferedReq:   list.New(),
+		conf:           conf,
+		evtbus:         make(chan interface{}, EventBusNum),
+		top:            topo.NewTopo(conf.ProductName, conf.CoordinatorAddr, conf.f, conf.Coordinator),
+		counter:        stats.NewCounters("router"),
+		lastActionSeq:  -1,
+		startAt:        time.Now(),
+		pipeConns:      make(map[string][]*taskRunner),
+		backendCounter: stats.NewCounters("backend"),
+		breakers:       make(map[string]*circuitBreaker),
+		migrators:      make(map[string]*keyMigrator),
+		migrateCounter: stats.NewCounters("migrate"),
+		slowlog:        newSlowlog(conf.SlowlogMaxLen),
+		tls:            reloader,
+		cmdLatency:     newHistogramVec(),
+		backendLatency: newHistogramVec(),
+		errCounter:     stats.NewCounters("errors"),
+		localHosts:     getLocalHosts(),
+		subscribers:    make(map[*subscriber]struct{}),
+		sessions:       make(map[int64]*session),
+		proxyWatches:   make(map[string]bool),
+		cmds:           defaultCommands,
+	}
+
+	s.pools = redisconn.NewPools(PoolCapability, s.newPoolConn)
+	s.blockPools = redisconn.NewPools(BlockingPoolCapability, s.newPoolConn)
+	s.moper = newMultiOperator(s.sendRequest, s.getRouteTable)
+	s.clients.setLimits(conf.MaxClients, conf.MaxClientsPerIP)
+	s.idleTimeout = int64(time.Duration(conf.IdleTimeoutSec) * time.Second)
+
+	// requests are dispatched in parallel by slot
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		d := newDispatcher(s, i)
+		s.dispatchers = append(s.dispatchers, d)
+		go d.run()
 	}
 
 	s.pi.ID = conf.ProxyID
@@ -756,6 +1105,12 @@ func NewServer(conf *Conf) *Server {
 	stats.Publish("evtbus", stats.StringFunc(func() string {
 		return strconv.Itoa(len(s.evtbus))
 	}))
+	stats.Publish("epoch", stats.StringFunc(func() string {
+		if t, ok := s.route.Load().(*routeTable); ok {
+			return strconv.FormatInt(t.epoch, 10)
+		}
+		return "0"
+	}))
 	stats.Publish("startAt", stats.StringFunc(func() string {
 		return s.startAt.String()
 	}))
@@ -763,11 +1118,19 @@ func NewServer(conf *Conf) *Server {
 	s.RegisterAndWait(true)
 	s.registerSignal()
 
+	if err = s.watchProxies(); err != nil {
+		log.Fatal(errors.ErrorStack(err))
+	}
+
 	_, err = s.top.WatchChildren(models.GetWatchActionPath(conf.ProductName), s.evtbus)
 	if err != nil {
 		log.Fatal(errors.ErrorStack(err))
 	}
 
+	s.loadSlotConfig()
+	s.loadCommands()
+	s.loadUsers()
+	s.loadCredentials()
 	s.FillSlots()
 
 	// start event handler
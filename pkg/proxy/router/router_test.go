@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"github.com/garyburd/redigo/redis"
+	stats "github.com/ngaut/gostats"
 	"github.com/ngaut/log"
 	"github.com/ngaut/zkhelper"
 	"github.com/reborndb/go/bytesize"
@@ -26,6 +27,7 @@ import (
 	"github.com/reborndb/qdb/pkg/store"
 	"github.com/reborndb/reborn/pkg/models"
 	"github.com/reborndb/reborn/pkg/proxy/redisconn"
+	"github.com/reborndb/reborn/pkg/utils"
 	. "gopkg.in/check.v1"
 )
 
@@ -146,6 +148,7 @@ func (s *testProxyRouterSuite) initEnv(c *C) {
 			HTTPAddr:        ":11000",
 			ProxyAuth:       proxyAuth,
 			StoreAuth:       storeAuth,
+			BackendConns:    2,
 		}
 
 		// init action path
@@ -166,8 +169,9 @@ func (s *testProxyRouterSuite) initEnv(c *C) {
 		s1 := models.NewServer(models.SERVER_TYPE_MASTER, s.s1.addr)
 		s2 := models.NewServer(models.SERVER_TYPE_MASTER, s.s2.addr)
 
-		g1.AddServer(conn, s1, storeAuth)
-		g2.AddServer(conn, s2, storeAuth)
+		creds := &models.Credentials{Default: &utils.Credential{Auth: storeAuth}}
+		g1.AddServer(conn, s1, creds)
+		g2.AddServer(conn, s2, creds)
 
 		// set slot range
 		err = models.SetSlotRange(conn, conf.ProductName, 0, 511, 1, models.SLOT_STATUS_ONLINE)
@@ -286,6 +290,57 @@ func (s *testProxyRouterSuite) TestSingleKeyRedisCmd(c *C) {
 	s.s2.store.Reset()
 }
 
+func (s *testProxyRouterSuite) TestBackendConns(c *C) {
+	srv := &Server{conf: &Conf{BackendConns: 4, BackendConnPolicy: BackendConnPolicySlot}}
+	c.Assert(srv.taskRunnerIndex(&PipelineRequest{slotIdx: 5, sessionId: 2}), Equals, 1)
+
+	srv.conf.BackendConnPolicy = BackendConnPolicySession
+	c.Assert(srv.taskRunnerIndex(&PipelineRequest{slotIdx: 5, sessionId: 2}), Equals, 2)
+
+	srv.conf.BackendConns = 1
+	c.Assert(srv.taskRunnerIndex(&PipelineRequest{slotIdx: 5, sessionId: 2}), Equals, 0)
+
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	// slot 0 and 1 are served by different connections to s1
+	for _, slot := range []int{0, 1} {
+		key := s.testGenKeysInSlot(c, slot, 1)[0]
+		_, err := cc.Do("SET", key, "bar")
+		c.Assert(err, IsNil)
+	}
+
+	counts := ss.backendCounter.Counts()
+	for _, name := range []string{s.s1.addr + "#0", s.s1.addr + "#1"} {
+		_, ok := counts[name+".queue"]
+		c.Assert(ok, Equals, true)
+		_, ok = counts[name+".inflight"]
+		c.Assert(ok, Equals, true)
+	}
+
+	s.s1.store.Reset()
+	s.s2.store.Reset()
+}
+
+func (s *testProxyRouterSuite) TestTaskRunnerStats(c *C) {
+	counter := stats.NewCounters("")
+	tr, err := NewTaskRunner(s.s1.addr, 0, 5, nil, counter, newCircuitBreaker(s.s1.addr, counter))
+	c.Assert(err, IsNil)
+
+	// stats are cleared when the task runner exits
+	counter.Set(tr.name+".queue", 10)
+	counter.Set(tr.name+".inflight", 10)
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	tr.in <- wg
+	wg.Wait()
+
+	counts := counter.Counts()
+	c.Assert(counts[tr.name+".queue"], Equals, int64(0))
+	c.Assert(counts[tr.name+".inflight"], Equals, int64(0))
+}
+
 func (s *testProxyRouterSuite) TestMget(c *C) {
 	cc := s.testDialConn(c, proxyAddr, proxyAuth)
 	defer cc.Close()
@@ -401,6 +456,16 @@ func (s *testProxyRouterSuite) TestNotAllowedCmd(c *C) {
 	c.Assert(err, NotNil)
 	c.Assert(strings.Contains(err.Error(), "not allowed"), Equals, true)
 
+	// SCRIPT is forwarded, the connection is not closed
+	cc = s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	_, err = cc.Do("SCRIPT", "EXISTS", "abc")
+	c.Assert(err == nil || !strings.Contains(err.Error(), "not allowed"), Equals, true)
+
+	_, err = cc.Do("PING")
+	c.Assert(err, IsNil)
+
 	s.s1.store.Reset()
 	s.s2.store.Reset()
 }
@@ -436,6 +501,9 @@ func (s *testProxyRouterSuite) TestInvalidRedisCmdEcho(c *C) {
 	c.Assert(err, IsNil)
 
 	_, err = cc.Do("echo")
+	c.Assert(err, ErrorMatches, "ERR wrong number of arguments for 'echo' command")
+
+	_, err = cc.Do("echo", "xx")
 	c.Assert(err, IsNil)
 
 	s.s1.store.Reset()
@@ -485,9 +553,18 @@ func (s *testProxyRouterSuite) TestStoreRestart(c *C) {
 	err = c2.Close()
 	c.Assert(err, IsNil)
 
-	// proxy should closed our connection
-	_, err = cc.Do("SET", "key1", "value1")
-	c.Assert(err, NotNil)
+	// the connection is kept, the breakers are closed after the probe succeeds
+	for i := 0; ; i++ {
+		_, err = cc.Do("SET", "key1", "value1")
+		if err == nil {
+			break
+		}
+
+		_, ok := err.(redis.Error)
+		c.Assert(ok, Equals, true)
+		c.Assert(i < 50, Equals, true)
+		time.Sleep(100 * time.Millisecond)
+	}
 
 	// now, proxy should recover from connection error
 	ccc := s.testDialConn(c, proxyAddr, proxyAuth)
@@ -580,7 +657,9 @@ func (s *testProxyRouterSuite) TestMigrate(c *C) {
 		if !mustErr {
 			c.Assert(err, IsNil)
 		} else {
-			c.Assert(err, NotNil)
+			// the backend error is replied, the connection is kept
+			_, ok := err.(redis.Error)
+			c.Assert(ok, Equals, true)
 			break
 		}
 	}
@@ -591,9 +670,11 @@ func (s *testProxyRouterSuite) TestMigrate(c *C) {
 
 	time.Sleep(1 * time.Second)
 
-	// proxy should closed our connection
+	// the pooled connections to server1 are broken, the connection is kept
 	_, err = proxyConn.Do("SET", keys[0], keys[0])
 	c.Assert(err, NotNil)
+	_, ok := err.(redis.Error)
+	c.Assert(ok, Equals, true)
 	proxyConn.Close()
 
 	// because migrate store server has a connection pool in proxy
@@ -601,11 +682,7 @@ func (s *testProxyRouterSuite) TestMigrate(c *C) {
 	// to close all old broken connections
 	ss.pools.Close()
 
-	f := func(addr string) (*redisconn.Conn, error) {
-		return newRedisConn(addr, conf.NetTimeout, RedisConnReaderSize, RedisConnWiterSize, conf.StoreAuth)
-	}
-
-	ss.pools = redisconn.NewPools(PoolCapability, f)
+	ss.pools = redisconn.NewPools(PoolCapability, ss.newPoolConn)
 
 	s1Conn := s.testDialConn(c, s.s1.addr, storeAuth)
 	defer s1Conn.Close()
@@ -0,0 +1,360 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	respcoding "github.com/ngaut/resp"
+	"github.com/reborndb/reborn/pkg/proxy/parser"
+)
+
+// The cursor returned to client is composed of three parts, from high to low bits,
+// 16 bits hash of the group scan order, iteration restarts if the order changed,
+// 16 bits id of the group being scanned, and 32 bits cursor of the backend server.
+const (
+	scanBackendCursorBits = 32
+	scanGroupBits         = 16
+
+	scanBackendCursorMask = 1<<scanBackendCursorBits - 1
+	scanGroupMask         = 1<<scanGroupBits - 1
+)
+
+type scanCursor struct {
+	hash    uint64
+	group   int
+	backend uint64
+}
+
+func parseScanCursor(v uint64) *scanCursor {
+	return &scanCursor{
+		hash:    v >> (scanBackendCursorBits + scanGroupBits),
+		group:   int(v >> scanBackendCursorBits & scanGroupMask),
+		backend: v & scanBackendCursorMask,
+	}
+}
+
+func (c *scanCursor) Uint64() uint64 {
+	return c.hash<<(scanBackendCursorBits+scanGroupBits) | uint64(c.group)<<scanBackendCursorBits | c.backend
+}
+
+// scanTopo is a snapshot of the slot owners, it is taken from the route table.
+type scanTopo struct {
+	owners  []int          // slot -> group id
+	from    []int          // slot -> migrating source group id, -1 if not migrating
+	masters map[int]string // group id -> master address
+	order   []int          // group ids in scan order
+	hash    uint64
+	unready []int // slots not filled, e.g. offline or in recovery
+}
+
+// sortScanGroups returns the groups in scan order. Keys of a migrating slot
+// only move from source group to destination group, so the source group is
+// scanned first, otherwise the keys moved after the destination group has
+// been scanned would be missed.
+func sortScanGroups(groups []int, edges [][2]int) []int {
+	sort.Ints(groups)
+
+	indegree := make(map[int]int)
+	for _, e := range edges {
+		indegree[e[1]]++
+	}
+
+	order := make([]int, 0, len(groups))
+	done := make(map[int]bool)
+	for len(order) < len(groups) {
+		next := -1
+		for _, g := range groups {
+			if !done[g] && indegree[g] == 0 {
+				next = g
+				break
+			}
+		}
+
+		// migration cycle, can not be sorted, use the smallest one
+		if next == -1 {
+			for _, g := range groups {
+				if !done[g] {
+					next = g
+					break
+				}
+			}
+		}
+
+		done[next] = true
+		order = append(order, next)
+		for _, e := range edges {
+			if e[0] == next {
+				indegree[e[1]]--
+			}
+		}
+	}
+
+	return order
+}
+
+func hashScanOrder(order []int) uint64 {
+	b := make([]byte, 0, len(order)*4)
+	for _, g := range order {
+		b = strconv.AppendInt(b, int64(g), 10)
+		b = append(b, ',')
+	}
+
+	// never be 0, so the cursor is not 0 until the end of iteration
+	h := uint64(crc32.ChecksumIEEE(b)) & scanGroupMask
+	if h == 0 {
+		h = 1
+	}
+
+	return h
+}
+
+// newScanTopo takes the snapshot from the slots of a route table, which are
+// never changed, so it needs neither the topology loop nor the coordinator.
+func newScanTopo(slots []*Slot) *scanTopo {
+	t := &scanTopo{
+		owners:  make([]int, len(slots)),
+		from:    make([]int, len(slots)),
+		masters: make(map[int]string),
+	}
+
+	var edges [][2]int
+	for i, slot := range slots {
+		if slot == nil {
+			t.owners[i], t.from[i] = -1, -1
+			t.unready = append(t.unready, i)
+			continue
+		}
+
+		t.owners[i] = slot.slotInfo.GroupId
+		t.masters[slot.slotInfo.GroupId] = slot.dst.Master()
+		t.from[i] = -1
+
+		// the source group is kept in the slot, even if it has no slot left
+		from := slot.slotInfo.State.MigrateStatus.From
+		if slot.migrateFrom == nil || from == slot.slotInfo.GroupId {
+			continue
+		}
+
+		t.from[i] = from
+		t.masters[from] = slot.migrateFrom.Master()
+		edges = append(edges, [2]int{from, slot.slotInfo.GroupId})
+	}
+
+	groups := make([]int, 0, len(t.masters))
+	for id, _ := range t.masters {
+		groups = append(groups, id)
+	}
+
+	t.order = sortScanGroups(groups, edges)
+	t.hash = hashScanOrder(t.order)
+
+	return t
+}
+
+// parseScanArgs checks the arguments of SCAN, args are all the arguments after
+// the command name. If the arguments are invalid, an error reply is returned.
+func parseScanArgs(args [][]byte) (uint64, []interface{}, []byte) {
+	if len(args) == 0 || len(args)%2 != 1 {
+		if len(args) == 0 {
+			return 0, nil, []byte("-ERR wrong number of arguments for 'scan' command\r\n")
+		}
+		return 0, nil, []byte("-ERR syntax error\r\n")
+	}
+
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return 0, nil, []byte("-ERR invalid cursor\r\n")
+	}
+
+	var opts []interface{}
+	for i := 1; i < len(args); i += 2 {
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+		case "COUNT":
+			if n, err := strconv.Atoi(string(args[i+1])); err != nil {
+				return 0, nil, []byte("-ERR value is not an integer or out of range\r\n")
+			} else if n < 1 {
+				return 0, nil, []byte("-ERR syntax error\r\n")
+			}
+		default:
+			return 0, nil, []byte("-ERR syntax error\r\n")
+		}
+		opts = append(opts, args[i], args[i+1])
+	}
+
+	return cursor, opts, nil
+}
+
+// handleScanCommand scans the groups one by one in the order of scanTopo,
+// only the keys of slots which belong to or are migrating from the group are returned.
+func (s *Server) handleScanCommand(c *session, op []byte, keys [][]byte, resp *parser.Resp) error {
+	args := keys
+	if len(resp.Multi) < 2 {
+		args = nil
+	}
+
+	v, opts, errBuf := parseScanArgs(args)
+	if errBuf != nil {
+		s.sendBack(c, op, keys, resp, errBuf)
+		return nil
+	}
+
+	t := newScanTopo(s.getRouteTable().slots)
+	if len(t.unready) > 0 {
+		// keys of the slot can not be found in any group
+		s.sendBack(c, op, keys, resp, []byte("-ERR slot "+strconv.Itoa(t.unready[0])+" is not ready\r\n"))
+		return nil
+	}
+
+	if len(t.order) == 0 {
+		s.sendBack(c, op, keys, resp, []byte("-ERR no server group to scan\r\n"))
+		return nil
+	}
+
+	cursor := parseScanCursor(v)
+	if v == 0 || cursor.hash != t.hash {
+		if v != 0 {
+			// the scan order is changed, keys may be missed, restart from the first group
+			s.counter.Add("ScanRestart", 1)
+		}
+		cursor = &scanCursor{hash: t.hash, group: t.order[0]}
+	}
+
+	addr, ok := t.masters[cursor.group]
+	if !ok {
+		s.sendBack(c, op, keys, resp, []byte("-ERR invalid cursor\r\n"))
+		return nil
+	}
+
+	reply, err := s.scanBackend(addr, cursor.backend, opts)
+	if err != nil {
+		log.Warningf("scan %s failed, %v", addr, errors.ErrorStack(err))
+		s.sendBack(c, op, keys, resp, []byte("-ERR scan backend server failed\r\n"))
+		return nil
+	}
+
+	if reply.Type == parser.ErrorResp {
+		s.sendBack(c, op, keys, resp, reply.Raw)
+		return nil
+	}
+
+	next, found, err := parseScanReply(reply)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if next > scanBackendCursorMask {
+		s.sendBack(c, op, keys, resp, []byte("-ERR backend cursor is out of range\r\n"))
+		return nil
+	}
+
+	var u *aclUser
+	if len(c.user) > 0 {
+		u = s.getUser(c.user)
+	}
+	result := t.filterKeys(cursor.group, found, u)
+
+	cursor.backend = next
+	if next == 0 {
+		cursor.group = nextScanGroup(t.order, cursor.group)
+	}
+
+	v = 0
+	if cursor.group != -1 {
+		v = cursor.Uint64()
+	}
+
+	buf, err := respcoding.Marshal([]interface{}{[]byte(strconv.FormatUint(v, 10)), result})
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	s.sendBack(c, op, keys, resp, buf)
+	return nil
+}
+
+// filterKeys returns the keys of slots which belong to or are migrating from
+// the group, if u is not nil, only the keys the user can access are returned.
+func (t *scanTopo) filterKeys(group int, keys [][]byte, u *aclUser) []interface{} {
+	result := make([]interface{}, 0, len(keys))
+	for _, k := range keys {
+		slot := mapKey2Slot(k)
+		if t.owners[slot] != group && t.from[slot] != group {
+			continue
+		}
+
+		if u != nil && !u.canAccess(k) {
+			continue
+		}
+
+		result = append(result, k)
+	}
+
+	return result
+}
+
+// nextScanGroup returns the group after g in order, -1 if g is the last one.
+func nextScanGroup(order []int, g int) int {
+	for i := 0; i < len(order)-1; i++ {
+		if order[i] == g {
+			return order[i+1]
+		}
+	}
+
+	return -1
+}
+
+func parseScanReply(reply *parser.Resp) (uint64, [][]byte, error) {
+	if reply.Type != parser.MultiResp || len(reply.Multi) != 2 || reply.Multi[1].Type != parser.MultiResp {
+		return 0, nil, errors.Errorf("bad scan reply %q", reply.Raw)
+	}
+
+	next, err := strconv.ParseUint(string(reply.Multi[0].Value()), 10, 64)
+	if err != nil {
+		return 0, nil, errors.Trace(err)
+	}
+
+	keys := make([][]byte, 0, len(reply.Multi[1].Multi))
+	for _, k := range reply.Multi[1].Multi {
+		keys = append(keys, k.Value())
+	}
+
+	return next, keys, nil
+}
+
+// scanBackend sends SCAN to the backend server and returns the reply.
+func (s *Server) scanBackend(addr string, cursor uint64, opts []interface{}) (*parser.Resp, error) {
+	conn, err := s.pools.GetConn(addr)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	args := append([]interface{}{strconv.FormatUint(cursor, 10)}, opts...)
+	err = writeCommand(conn, "SCAN", args...)
+	if err == nil {
+		err = conn.Flush()
+	}
+
+	var reply *parser.Resp
+	if err == nil {
+		err = conn.SetReadDeadline(time.Now().Add(time.Duration(s.conf.NetTimeout) * time.Second))
+	}
+	if err == nil {
+		reply, err = parser.Parse(conn.BufioReader())
+	}
+
+	if err != nil {
+		conn.Close()
+	}
+	s.pools.PutConn(conn)
+
+	return reply, errors.Trace(err)
+}
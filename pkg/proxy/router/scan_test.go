@@ -0,0 +1,117 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"bufio"
+	"bytes"
+
+	"github.com/reborndb/reborn/pkg/models"
+	"github.com/reborndb/reborn/pkg/proxy/group"
+	"github.com/reborndb/reborn/pkg/proxy/parser"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testProxyRouterSuite) TestScanCursor(c *C) {
+	cursor := &scanCursor{hash: 0xabcd, group: 12, backend: 0x12345678}
+	c.Assert(parseScanCursor(cursor.Uint64()), DeepEquals, cursor)
+
+	// the first group with backend cursor only
+	c.Assert((&scanCursor{backend: 10}).Uint64(), Equals, uint64(10))
+
+	c.Assert(hashScanOrder([]int{1, 2}), Not(Equals), hashScanOrder([]int{2, 1}))
+	c.Assert(hashScanOrder(nil), Not(Equals), uint64(0))
+}
+
+func (s *testProxyRouterSuite) TestSortScanGroups(c *C) {
+	c.Assert(sortScanGroups([]int{3, 1, 2}, nil), DeepEquals, []int{1, 2, 3})
+
+	// migrating source is scanned before destination
+	c.Assert(sortScanGroups([]int{1, 2, 3}, [][2]int{{3, 1}}), DeepEquals, []int{2, 3, 1})
+	c.Assert(sortScanGroups([]int{1, 2, 3}, [][2]int{{3, 2}, {2, 1}}), DeepEquals, []int{3, 2, 1})
+
+	// cycle
+	c.Assert(sortScanGroups([]int{1, 2, 3}, [][2]int{{1, 2}, {2, 1}}), DeepEquals, []int{3, 1, 2})
+
+	c.Assert(nextScanGroup([]int{2, 3, 1}, 3), Equals, 1)
+	c.Assert(nextScanGroup([]int{2, 3, 1}, 1), Equals, -1)
+}
+
+func (s *testProxyRouterSuite) TestScanTopoUnready(c *C) {
+	g := models.ServerGroup{Id: 1, Servers: []*models.Server{{Type: models.SERVER_TYPE_MASTER, Addr: "localhost:6379", GroupId: 1}}}
+	info := &models.Slot{Id: 1, GroupId: 1, State: models.SlotState{Status: models.SLOT_STATUS_ONLINE}}
+	slots := []*Slot{nil, {slotInfo: info, groupInfo: &g, dst: group.NewGroup(g)}}
+
+	// the slot is cleared by offline action or not filled in recovery
+	t := newScanTopo(slots)
+	c.Assert(t.unready, DeepEquals, []int{0})
+	c.Assert(t.owners, DeepEquals, []int{-1, 1})
+	c.Assert(t.order, DeepEquals, []int{1})
+
+	// the source group has no slot left, its master is kept in the slot
+	g3 := models.ServerGroup{Id: 3, Servers: []*models.Server{{Type: models.SERVER_TYPE_MASTER, Addr: "localhost:6380", GroupId: 3}}}
+	info = &models.Slot{Id: 0, GroupId: 1, State: models.SlotState{Status: models.SLOT_STATUS_MIGRATE}}
+	info.State.MigrateStatus.From = 3
+	slots[0] = &Slot{slotInfo: info, groupInfo: &g, dst: group.NewGroup(g), migrateFrom: group.NewGroup(g3)}
+
+	t = newScanTopo(slots)
+	c.Assert(t.unready, HasLen, 0)
+	c.Assert(t.from, DeepEquals, []int{3, -1})
+	c.Assert(t.masters, DeepEquals, map[int]string{1: "localhost:6379", 3: "localhost:6380"})
+	c.Assert(t.order, DeepEquals, []int{3, 1})
+}
+
+func (s *testProxyRouterSuite) TestParseScanArgs(c *C) {
+	cursor, opts, errBuf := parseScanArgs([][]byte{[]byte("10"), []byte("match"), []byte("a*"), []byte("COUNT"), []byte("100")})
+	c.Assert(errBuf, IsNil)
+	c.Assert(cursor, Equals, uint64(10))
+	c.Assert(opts, HasLen, 4)
+
+	_, _, errBuf = parseScanArgs(nil)
+	c.Assert(string(errBuf), Matches, "-ERR wrong number of arguments.*\r\n")
+
+	_, _, errBuf = parseScanArgs([][]byte{[]byte("abc")})
+	c.Assert(string(errBuf), Equals, "-ERR invalid cursor\r\n")
+
+	_, _, errBuf = parseScanArgs([][]byte{[]byte("0"), []byte("COUNT")})
+	c.Assert(string(errBuf), Equals, "-ERR syntax error\r\n")
+
+	_, _, errBuf = parseScanArgs([][]byte{[]byte("0"), []byte("COUNT"), []byte("0")})
+	c.Assert(string(errBuf), Equals, "-ERR syntax error\r\n")
+
+	_, _, errBuf = parseScanArgs([][]byte{[]byte("0"), []byte("TYPE"), []byte("string")})
+	c.Assert(string(errBuf), Equals, "-ERR syntax error\r\n")
+}
+
+func (s *testProxyRouterSuite) TestParseScanReply(c *C) {
+	reply, err := parser.Parse(bufio.NewReader(bytes.NewBufferString("*2\r\n$2\r\n17\r\n*2\r\n$2\r\nk1\r\n$2\r\nk2\r\n")))
+	c.Assert(err, IsNil)
+
+	next, keys, err := parseScanReply(reply)
+	c.Assert(err, IsNil)
+	c.Assert(next, Equals, uint64(17))
+	c.Assert(keys, DeepEquals, [][]byte{[]byte("k1"), []byte("k2")})
+
+	reply, err = parser.Parse(bufio.NewReader(bytes.NewBufferString("+OK\r\n")))
+	c.Assert(err, IsNil)
+
+	_, _, err = parseScanReply(reply)
+	c.Assert(err, NotNil)
+}
+
+func (s *testProxyRouterSuite) TestScanCommand(c *C) {
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	_, err := cc.Do("SCAN", "0", "COUNT")
+	c.Assert(err, ErrorMatches, "ERR syntax error")
+
+	// the backend in test does not support SCAN, its error is returned
+	_, err = cc.Do("SCAN", "0")
+	c.Assert(err, NotNil)
+
+	// connection still works
+	_, err = cc.Do("SET", "foo", "bar")
+	c.Assert(err, IsNil)
+}
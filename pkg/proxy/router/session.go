@@ -7,7 +7,9 @@ import (
 	"bufio"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/juju/errors"
@@ -16,12 +18,14 @@ import (
 )
 
 type session struct {
+	id int64
+
 	r *bufio.Reader
 	w *bufio.Writer
 	net.Conn
 
 	CreateAt              time.Time
-	Ops                   int64
+	Ops                   int64 // atomic, read by CLIENT LIST
 	pipelineSeq           int64
 	backQ                 chan *PipelineResponse
 	lastUnsentResponseSeq int64
@@ -29,16 +33,53 @@ type session struct {
 	closeSignal           *sync.WaitGroup
 
 	authenticated bool
+	admin         bool   // authenticated with admin auth, can run destructive commands
+	user          string // authenticated by AUTH user password, empty for the proxy auth
+
+	// MULTI/WATCH state, nil if no transaction
+	txn *transaction
+	// subscriptions, nil if not in subscribe mode
+	sub *subscriber
+
+	// shown by CLIENT LIST of other sessions
+	infoMutex sync.Mutex
+	name      string
+	lastCmd   string
+	activeAt  time.Time
+	blocked   bool
+	subs      int
+	psubs     int
+	multi     int
+	userName  string
 }
 
 type PipelineRequest struct {
-	slotIdx int
-	op      []byte
-	keys    [][]byte
-	seq     int64
-	backQ   chan *PipelineResponse
-	req     *parser.Resp
-	wg      *sync.WaitGroup
+	slotIdx   int
+	sessionId int64
+	op        []byte
+	keys      [][]byte
+	seq       int64
+	backQ     chan *PipelineResponse
+	req       *parser.Resp
+	wg        *sync.WaitGroup
+
+	readOnly bool
+
+	// all slots of the keys if several slots of one group are batched,
+	// they must be still online in the group of slotIdx when dispatched
+	batchSlots []int
+
+	// set by the task runner for the slow log and metrics
+	backend   string
+	sentAt    time.Time
+	repliedAt time.Time
+
+	failed bool // replied with a backend error
+
+	// direct is used for the request which needs a dedicated backend connection,
+	// dispatcher calls it in a new goroutine with the resolved server address
+	// instead of sending the request to a task runner.
+	direct func(addr string)
 }
 
 func (pr *PipelineRequest) String() string {
@@ -52,7 +93,13 @@ type PipelineResponse struct {
 }
 
 func (s *session) String() string {
-	return fmt.Sprintf("conn:%s, CreateAt:%s, Ops:%d, closed:%v", s.Conn.RemoteAddr(), s.CreateAt, s.Ops, s.closed)
+	return fmt.Sprintf("conn:%s, CreateAt:%s, Ops:%d, closed:%v", s.Conn.RemoteAddr(), s.CreateAt, atomic.LoadInt64(&s.Ops), s.closed)
+}
+
+// errorResp returns the error reply of the backend error.
+func errorResp(err error) *parser.Resp {
+	msg := strings.Replace(strings.Replace(err.Error(), "\r", " ", -1), "\n", " ", -1)
+	return &parser.Resp{Type: parser.ErrorResp, Raw: []byte("-ERR " + msg + "\r\n")}
 }
 
 func (s *session) writeResp(resp *PipelineResponse) error {
@@ -60,17 +107,30 @@ func (s *session) writeResp(resp *PipelineResponse) error {
 }
 
 func (s *session) handleResponse(resp *PipelineResponse) (flush bool, err error) {
+	if resp.ctx == nil { // pushed message, like pub/sub message, has no request
+		if !s.closed {
+			if err := s.writeResp(resp); err != nil {
+				return false, errors.Trace(err)
+			}
+			flush = true
+		}
+		return
+	}
+
 	if resp.ctx.seq != s.lastUnsentResponseSeq {
 		log.Fatal("should never happend")
 	}
 
 	s.lastUnsentResponseSeq++
-	if resp.ctx.wg != nil {
-		resp.ctx.wg.Done()
+	if resp.err != nil {
+		// a backend failure only fails the request, the client is kept
+		log.Warning(s.RemoteAddr(), resp.ctx, errors.ErrorStack(resp.err))
+		resp.resp = errorResp(resp.err)
 	}
 
-	if resp.err != nil { //notify close client connection
-		return true, resp.err
+	if resp.ctx.wg != nil {
+		resp.ctx.failed = resp.err != nil
+		resp.ctx.wg.Done()
 	}
 
 	if !s.closed {
@@ -0,0 +1,230 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	respcoding "github.com/ngaut/resp"
+	"github.com/reborndb/reborn/pkg/proxy/parser"
+)
+
+const (
+	DefaultSlowlogSlowerThanUs = 10000
+	DefaultSlowlogMaxLen       = 128
+
+	SlowlogMaxArgc   = 32  // arguments kept in an entry, like redis
+	SlowlogMaxArgLen = 128 // bytes kept of an argument
+)
+
+type slowlogEntry struct {
+	Id        int64    `json:"id"`
+	Time      int64    `json:"time"`     // unix seconds
+	Duration  int64    `json:"duration"` // microseconds
+	Args      []string `json:"args"`
+	Client    string   `json:"client"`
+	Backend   string   `json:"backend"`
+	Slot      int      `json:"slot"`
+	QueueUs   int64    `json:"queue_us"`   // from received to sent to backend
+	BackendUs int64    `json:"backend_us"` // from sent to backend to replied
+}
+
+// slowlog keeps the latest slow requests in a ring buffer.
+type slowlog struct {
+	mu      sync.Mutex
+	entries []*slowlogEntry
+	next    int // where the next entry is put
+	num     int
+	lastId  int64
+}
+
+func newSlowlog(maxLen int) *slowlog {
+	return &slowlog{entries: make([]*slowlogEntry, maxLen)}
+}
+
+func (l *slowlog) add(e *slowlogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.Id = l.lastId
+	l.lastId++
+
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % len(l.entries)
+	if l.num < len(l.entries) {
+		l.num++
+	}
+}
+
+// get returns the latest n entries, the newest first, all entries if n < 0.
+func (l *slowlog) get(n int) []*slowlogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if n < 0 || n > l.num {
+		n = l.num
+	}
+
+	entries := make([]*slowlogEntry, 0, n)
+	for i := 1; i <= n; i++ {
+		entries = append(entries, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+	}
+
+	return entries
+}
+
+func (l *slowlog) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.num
+}
+
+func (l *slowlog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i := range l.entries {
+		l.entries[i] = nil
+	}
+	l.next = 0
+	l.num = 0
+}
+
+// slowlogArgs returns the arguments of the request, truncated like redis.
+func slowlogArgs(op []byte, keys [][]byte) []string {
+	argv := append([][]byte{op}, keys...)
+	argc := len(argv)
+	if argc > SlowlogMaxArgc {
+		argc = SlowlogMaxArgc
+	}
+
+	args := make([]string, 0, argc)
+	for i := 0; i < argc; i++ {
+		if i == argc-1 && argc != len(argv) {
+			args = append(args, fmt.Sprintf("... (%d more arguments)", len(argv)-argc+1))
+			break
+		}
+
+		arg := argv[i]
+		if len(arg) > SlowlogMaxArgLen {
+			arg = []byte(fmt.Sprintf("%s... (%d more bytes)", arg[:SlowlogMaxArgLen], len(arg)-SlowlogMaxArgLen))
+		}
+		args = append(args, string(arg))
+	}
+
+	return args
+}
+
+// recordSlowlog adds the request to the slow log if it is slower than the
+// threshold, a negative threshold disables the slow log.
+func (s *Server) recordSlowlog(c *session, op []byte, keys [][]byte, pr *PipelineRequest, start time.Time) {
+	if s.conf.SlowlogSlowerThanUs < 0 {
+		return
+	}
+
+	d := time.Since(start)
+	if d < time.Duration(s.conf.SlowlogSlowerThanUs)*time.Microsecond {
+		return
+	}
+
+	s.counter.Add("Slowlog", 1)
+
+	e := &slowlogEntry{
+		Time:     start.Unix(),
+		Duration: int64(d / time.Microsecond),
+		Args:     slowlogArgs(op, keys),
+		Client:   c.RemoteAddr().String(),
+		Slot:     pr.slotIdx,
+	}
+
+	// set by the task runner, the requests replied by proxy have no backend
+	if len(pr.backend) > 0 {
+		e.Backend = pr.backend
+		e.QueueUs = int64(pr.sentAt.Sub(start) / time.Microsecond)
+		if !pr.repliedAt.IsZero() {
+			e.BackendUs = int64(pr.repliedAt.Sub(pr.sentAt) / time.Microsecond)
+		}
+	}
+
+	s.slowlog.add(e)
+}
+
+func (s *Server) handleSlowlogCommand(c *session, op []byte, keys [][]byte, resp *parser.Resp) error {
+	sub := strings.ToUpper(string(keys[0]))
+
+	var v interface{}
+	switch {
+	case sub == "GET" && len(keys) <= 2:
+		n := 10
+		if len(keys) == 2 {
+			var err error
+			if n, err = strconv.Atoi(string(keys[1])); err != nil {
+				s.sendBack(c, op, keys, resp, []byte("-ERR value is not an integer or out of range\r\n"))
+				return nil
+			}
+		}
+
+		entries := s.slowlog.get(n)
+		reply := make([]interface{}, 0, len(entries))
+		for _, e := range entries {
+			args := make([]interface{}, 0, len(e.Args))
+			for _, arg := range e.Args {
+				args = append(args, []byte(arg))
+			}
+
+			reply = append(reply, []interface{}{
+				int(e.Id), int(e.Time), int(e.Duration), args, []byte(e.Client),
+				[]byte(e.Backend), e.Slot, int(e.QueueUs), int(e.BackendUs),
+			})
+		}
+		v = reply
+	case sub == "LEN" && len(keys) == 1:
+		v = s.slowlog.len()
+	case sub == "RESET" && len(keys) == 1:
+		s.slowlog.reset()
+		s.sendBack(c, op, keys, resp, OK_BYTES)
+		return nil
+	default:
+		s.sendBack(c, op, keys, resp, []byte("-ERR Unknown subcommand or wrong number of arguments for '"+string(keys[0])+"'\r\n"))
+		return nil
+	}
+
+	buf, err := respcoding.Marshal(v)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	s.sendBack(c, op, keys, resp, buf)
+	return nil
+}
+
+// ServeSlowlog serves the slow log in json for the debug http server, the
+// latest n entries are returned if the query has n.
+func (s *Server) ServeSlowlog(w http.ResponseWriter, r *http.Request) {
+	n := -1
+	if v := r.FormValue("n"); len(v) > 0 {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "invalid n "+v, http.StatusBadRequest)
+			return
+		}
+	}
+
+	b, err := json.MarshalIndent(s.slowlog.get(n), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,121 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testProxyRouterSuite) TestSlowlogRing(c *C) {
+	l := newSlowlog(3)
+	for i := 0; i < 5; i++ {
+		l.add(&slowlogEntry{Slot: i})
+	}
+
+	c.Assert(l.len(), Equals, 3)
+
+	entries := l.get(-1)
+	c.Assert(entries, HasLen, 3)
+	for i, e := range entries {
+		c.Assert(e.Id, Equals, int64(4-i))
+		c.Assert(e.Slot, Equals, 4-i)
+	}
+
+	c.Assert(l.get(1), HasLen, 1)
+	c.Assert(l.get(1)[0].Id, Equals, int64(4))
+
+	l.reset()
+	c.Assert(l.len(), Equals, 0)
+	c.Assert(l.get(-1), HasLen, 0)
+
+	args := slowlogArgs([]byte("SET"), [][]byte{[]byte("key"), []byte(strings.Repeat("v", SlowlogMaxArgLen+10))})
+	c.Assert(args, DeepEquals, []string{"SET", "key", strings.Repeat("v", SlowlogMaxArgLen) + "... (10 more bytes)"})
+
+	keys := make([][]byte, SlowlogMaxArgc+5)
+	for i := range keys {
+		keys[i] = []byte("k")
+	}
+	args = slowlogArgs([]byte("MGET"), keys)
+	c.Assert(args, HasLen, SlowlogMaxArgc)
+	c.Assert(args[SlowlogMaxArgc-1], Equals, "... (7 more arguments)")
+}
+
+func (s *testProxyRouterSuite) TestSlowlogCommand(c *C) {
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	conf.AdminAuth = "admin"
+	defer func() {
+		conf.AdminAuth = ""
+	}()
+
+	_, err := cc.Do("SLOWLOG", "LEN")
+	c.Assert(err, ErrorMatches, "ERR SLOWLOG requires admin auth")
+
+	_, err = cc.Do("AUTH", "admin")
+	c.Assert(err, IsNil)
+
+	ok, err := redis.String(cc.Do("SLOWLOG", "RESET"))
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, "OK")
+
+	// the threshold of the test proxy is 0, every request is logged
+	_, err = cc.Do("SET", "slowlog_key", "value")
+	c.Assert(err, IsNil)
+
+	n, err := redis.Int(cc.Do("SLOWLOG", "LEN"))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+
+	entries, err := redis.Values(cc.Do("SLOWLOG", "GET"))
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 1)
+
+	entry, err := redis.Values(entries[0], nil)
+	c.Assert(err, IsNil)
+	c.Assert(entry, HasLen, 9)
+
+	args, err := redis.Strings(entry[3], nil)
+	c.Assert(err, IsNil)
+	c.Assert(args, DeepEquals, []string{"SET", "slowlog_key", "value"})
+
+	slot := mapKey2Slot([]byte("slowlog_key"))
+	backend := s.s1.addr
+	if slot >= 512 {
+		backend = s.s2.addr
+	}
+
+	client, _ := redis.String(entry[4], nil)
+	c.Assert(strings.HasPrefix(client, "127.0.0.1:"), Equals, true)
+	addr, _ := redis.String(entry[5], nil)
+	c.Assert(addr, Equals, backend)
+	c.Assert(entry[6], Equals, int64(slot))
+
+	// debug http server
+	w := httptest.NewRecorder()
+	r, err := http.NewRequest("GET", "/slowlog?n=1", nil)
+	c.Assert(err, IsNil)
+	ss.ServeSlowlog(w, r)
+	c.Assert(w.Code, Equals, http.StatusOK)
+
+	var logs []*slowlogEntry
+	err = json.Unmarshal(w.Body.Bytes(), &logs)
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 1)
+	c.Assert(logs[0].Args, DeepEquals, args)
+	c.Assert(logs[0].Backend, Equals, backend)
+
+	_, err = cc.Do("SLOWLOG", "RESET")
+	c.Assert(err, IsNil)
+
+	n, err = redis.Int(cc.Do("SLOWLOG", "LEN"))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
@@ -2,13 +2,16 @@ package router
 
 import (
 	"container/list"
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/juju/errors"
+	stats "github.com/ngaut/gostats"
 	"github.com/ngaut/log"
 	"github.com/reborndb/reborn/pkg/proxy/parser"
 	"github.com/reborndb/reborn/pkg/proxy/redisconn"
+	"github.com/reborndb/reborn/pkg/utils"
 )
 
 const (
@@ -28,7 +31,25 @@ type taskRunner struct {
 	closed     bool
 	wgClose    *sync.WaitGroup
 	latest     time.Time //latest request time stamp
-	auth       string
+	cred       *utils.Credential
+
+	name    string // redisAddr#index, a backend may have several task runners
+	counter *stats.Counters
+	breaker *circuitBreaker // shared by the task runners of the backend
+}
+
+// updateStats exports the number of requests waiting in queue and
+// the number of requests sent but not replied yet.
+func (tr *taskRunner) updateStats() {
+	tr.counter.Set(tr.name+".queue", int64(len(tr.in)))
+	tr.counter.Set(tr.name+".inflight", int64(tr.tasks.Len()))
+}
+
+// clearStats resets the stats when the task runner exits, so the removed
+// backends do not keep stale values.
+func (tr *taskRunner) clearStats() {
+	tr.counter.Set(tr.name+".queue", 0)
+	tr.counter.Set(tr.name+".inflight", 0)
 }
 
 func (tr *taskRunner) readloop() {
@@ -67,6 +88,8 @@ func (tr *taskRunner) handleTask(r *PipelineRequest, flush bool) error {
 
 	tr.tasks.PushBack(r)
 	tr.latest = time.Now()
+	r.backend = tr.redisAddr
+	r.sentAt = tr.latest
 
 	return errors.Trace(tr.dowrite(r, flush))
 }
@@ -95,9 +118,10 @@ func (tr *taskRunner) cleanupOutgoingTasks(err error) {
 
 func (tr *taskRunner) tryRecover(err error) error {
 	log.Warning("try recover from ", err)
+	tr.breaker.failure()
 	tr.cleanupOutgoingTasks(err)
 	//try to recover
-	c, err := newRedisConn(tr.redisAddr, tr.netTimeout, PipelineBufSize, PipelineBufSize, tr.auth)
+	c, err := newRedisConn(tr.redisAddr, tr.netTimeout, PipelineBufSize, PipelineBufSize, tr.cred)
 	if err != nil {
 		tr.cleanupQueueTasks() //do not block dispatcher
 		log.Warning(err)
@@ -106,6 +130,7 @@ func (tr *taskRunner) tryRecover(err error) error {
 	}
 
 	tr.c = c
+	tr.breaker.reconnected()
 	go tr.readloop()
 
 	return nil
@@ -164,8 +189,10 @@ func (tr *taskRunner) handleResponse(e interface{}) error {
 		resp := e.(*parser.Resp)
 		e := tr.tasks.Front()
 		req := e.Value.(*PipelineRequest)
+		req.repliedAt = time.Now()
 		req.backQ <- &PipelineResponse{ctx: req, resp: resp, err: nil}
 		tr.tasks.Remove(e)
+		tr.breaker.success()
 		return nil
 	}
 
@@ -176,8 +203,11 @@ func (tr *taskRunner) writeloop() {
 	var err error
 	tick := time.Tick(2 * time.Second)
 	for {
+		tr.updateStats()
+
 		if tr.closed && tr.tasks.Len() == 0 {
-			log.Warning("exit taskrunner", tr.redisAddr)
+			log.Warning("exit taskrunner", tr.name)
+			tr.clearStats()
 			tr.wgClose.Done()
 			tr.c.Close()
 			return
@@ -203,17 +233,20 @@ func (tr *taskRunner) writeloop() {
 	}
 }
 
-func NewTaskRunner(addr string, netTimeout int, auth string) (*taskRunner, error) {
+func NewTaskRunner(addr string, index int, netTimeout int, cred *utils.Credential, counter *stats.Counters, breaker *circuitBreaker) (*taskRunner, error) {
 	tr := &taskRunner{
 		in:         make(chan interface{}, TaskRunnerInNum),
 		out:        make(chan interface{}, TaskRunnerOutNum),
 		redisAddr:  addr,
 		tasks:      list.New(),
 		netTimeout: netTimeout,
-		auth:       auth,
+		cred:       cred,
+		name:       fmt.Sprintf("%s#%d", addr, index),
+		counter:    counter,
+		breaker:    breaker,
 	}
 
-	c, err := newRedisConn(addr, netTimeout, PipelineBufSize, PipelineBufSize, auth)
+	c, err := newRedisConn(addr, netTimeout, PipelineBufSize, PipelineBufSize, cred)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -0,0 +1,128 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"sync"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+)
+
+// tlsReloader holds the certificate of the client listener, it is reloaded
+// from the files on SIGHUP. The sessions keep the certificate of their
+// handshake, so reloading does not affect them.
+type tlsReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string // verify the client certificates if not empty
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+func newTLSReloader(certFile string, keyFile string, clientCAFile string) (*tlsReloader, error) {
+	r := &tlsReloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+
+	if err := r.reload(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return r, nil
+}
+
+// reload loads the certificate and the client CAs, the former ones are kept
+// if any of them fails.
+func (r *tlsReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var clientCAs *x509.CertPool
+	if len(r.clientCAFile) > 0 {
+		pem, err := ioutil.ReadFile(r.clientCAFile)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return errors.Errorf("no certificate found in %s", r.clientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.mu.Unlock()
+
+	return nil
+}
+
+// config returns the config of the handshake with the current certificate.
+func (r *tlsReloader) config() *tls.Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{*r.cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if r.clientCAs != nil {
+		cfg.ClientCAs = r.clientCAs
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg
+}
+
+func (r *tlsReloader) listen(l net.Listener) net.Listener {
+	return tls.NewListener(l, &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.config(), nil
+		},
+	})
+}
+
+// reloadTLS reloads the certificate of the client listener, it is called
+// on SIGHUP.
+func (s *Server) reloadTLS() {
+	if s.tls == nil {
+		return
+	}
+
+	if err := s.tls.reload(); err != nil {
+		s.counter.Add("TLSReloadFailed", 1)
+		log.Errorf("reload tls certificate failed, keep the former one, %v", errors.ErrorStack(err))
+		return
+	}
+
+	s.counter.Add("TLSReload", 1)
+	log.Warningf("tls certificate reloaded from %s", s.tls.certFile)
+}
+
+// listen listens on the client address, with tls if the certificate is set.
+func (s *Server) listen() (net.Listener, error) {
+	l, err := net.Listen(s.conf.Proto, s.conf.Addr)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if s.tls != nil {
+		l = s.tls.listen(l)
+	}
+
+	return l, nil
+}
@@ -0,0 +1,194 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	stats "github.com/ngaut/gostats"
+	. "gopkg.in/check.v1"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// testGenCert generates a certificate signed by the parent, self-signed if
+// the parent is nil.
+func (s *testProxyRouterSuite) testGenCert(c *C, serial int64, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "reborn test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	c.Assert(err, IsNil)
+
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+
+	return &testCert{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (s *testProxyRouterSuite) testWriteCert(c *C, cert *testCert, certFile string, keyFile string) {
+	der, err := x509.MarshalECPrivateKey(cert.key)
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(certFile, cert.pem, 0600)
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	c.Assert(err, IsNil)
+}
+
+func (s *testProxyRouterSuite) testTLSEcho(c *C, conn *tls.Conn, r *bufio.Reader) error {
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		return err
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+
+	c.Assert(line, Equals, "ping\n")
+	return nil
+}
+
+func (s *testProxyRouterSuite) TestTLSListener(c *C) {
+	base := "/tmp/test_reborn/test_proxy_router/tls"
+	err := os.MkdirAll(base, 0700)
+	c.Assert(err, IsNil)
+
+	certFile, keyFile, caFile := path.Join(base, "proxy.crt"), path.Join(base, "proxy.key"), path.Join(base, "ca.crt")
+
+	ca := s.testGenCert(c, 1, nil, true)
+	err = ioutil.WriteFile(caFile, ca.pem, 0600)
+	c.Assert(err, IsNil)
+
+	s.testWriteCert(c, s.testGenCert(c, 100, ca, false), certFile, keyFile)
+	client := s.testGenCert(c, 200, ca, false)
+
+	reloader, err := newTLSReloader(certFile, keyFile, caFile)
+	c.Assert(err, IsNil)
+
+	srv := &Server{conf: &Conf{Proto: "tcp4", Addr: "127.0.0.1:0"}, tls: reloader, counter: stats.NewCounters("")}
+	l, err := srv.listen()
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					conn.Write([]byte(line))
+				}
+			}()
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	dial := func(withCert bool) (*tls.Conn, *bufio.Reader, error) {
+		cfg := &tls.Config{RootCAs: roots}
+		if withCert {
+			cfg.Certificates = []tls.Certificate{{Certificate: [][]byte{client.cert.Raw}, PrivateKey: client.key}}
+		}
+
+		conn, err := tls.Dial("tcp4", l.Addr().String(), cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return conn, bufio.NewReader(conn), nil
+	}
+
+	// the client certificate is required
+	conn, r, err := dial(false)
+	if err == nil {
+		err = s.testTLSEcho(c, conn, r)
+		conn.Close()
+	}
+	c.Assert(err, NotNil)
+
+	conn1, r1, err := dial(true)
+	c.Assert(err, IsNil)
+	defer conn1.Close()
+
+	err = s.testTLSEcho(c, conn1, r1)
+	c.Assert(err, IsNil)
+	c.Assert(conn1.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), Equals, int64(100))
+
+	// reload, the former session is kept
+	s.testWriteCert(c, s.testGenCert(c, 101, ca, false), certFile, keyFile)
+	srv.reloadTLS()
+	c.Assert(srv.counter.Counts()["TLSReload"], Equals, int64(1))
+
+	err = s.testTLSEcho(c, conn1, r1)
+	c.Assert(err, IsNil)
+
+	conn2, r2, err := dial(true)
+	c.Assert(err, IsNil)
+	defer conn2.Close()
+
+	err = s.testTLSEcho(c, conn2, r2)
+	c.Assert(err, IsNil)
+	c.Assert(conn2.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), Equals, int64(101))
+
+	// a broken certificate is not loaded
+	err = ioutil.WriteFile(certFile, []byte("broken"), 0600)
+	c.Assert(err, IsNil)
+	srv.reloadTLS()
+	c.Assert(srv.counter.Counts()["TLSReloadFailed"], Equals, int64(1))
+
+	conn3, r3, err := dial(true)
+	c.Assert(err, IsNil)
+	defer conn3.Close()
+
+	err = s.testTLSEcho(c, conn3, r3)
+	c.Assert(err, IsNil)
+	c.Assert(conn3.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), Equals, int64(101))
+}
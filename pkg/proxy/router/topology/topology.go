@@ -6,6 +6,7 @@ package topology
 import (
 	"encoding/json"
 	"path"
+	"sync"
 
 	"github.com/juju/errors"
 	topo "github.com/ngaut/go-zookeeper/zk"
@@ -24,21 +25,50 @@ type CoordFactory func(coordAddr string) (zkhelper.Conn, error)
 type Topology struct {
 	ProductName string
 	coordAddr   string
-	coordConn   zkhelper.Conn
 	fact        CoordFactory
 	coordinator string
+
+	mu        sync.RWMutex
+	coordConn zkhelper.Conn
+	watchGen  int // watches of former connections are dropped
+}
+
+func (top *Topology) conn() zkhelper.Conn {
+	top.mu.RLock()
+	defer top.mu.RUnlock()
+	return top.coordConn
 }
 
 func (top *Topology) GetGroup(groupId int) (*models.ServerGroup, error) {
-	return models.GetGroup(top.coordConn, top.ProductName, groupId)
+	return models.GetGroup(top.conn(), top.ProductName, groupId)
+}
+
+func (top *Topology) GetServerGroups() ([]*models.ServerGroup, error) {
+	return models.ServerGroups(top.conn(), top.ProductName)
+}
+
+func (top *Topology) GetCommands() ([]*models.Command, error) {
+	return models.GetCommands(top.conn(), top.ProductName)
+}
+
+func (top *Topology) GetCredentials(storeAuth string) (*models.Credentials, error) {
+	return models.GetCredentials(top.conn(), top.ProductName, storeAuth)
+}
+
+func (top *Topology) GetUsers() ([]*models.User, error) {
+	return models.GetUsers(top.conn(), top.ProductName)
+}
+
+func (top *Topology) GetSlotConfig() (*models.SlotConfig, error) {
+	return models.GetSlotConfig(top.conn(), top.ProductName)
 }
 
 func (top *Topology) Exist(path string) (bool, error) {
-	return zkhelper.NodeExists(top.coordConn, path)
+	return zkhelper.NodeExists(top.conn(), path)
 }
 
 func (top *Topology) GetSlotByIndex(i int) (*models.Slot, *models.ServerGroup, error) {
-	slot, err := models.GetSlot(top.coordConn, top.ProductName, i)
+	slot, err := models.GetSlot(top.conn(), top.ProductName, i)
 	if err != nil {
 		return nil, nil, errors.Trace(err)
 	}
@@ -48,7 +78,7 @@ func (top *Topology) GetSlotByIndex(i int) (*models.Slot, *models.ServerGroup, e
 		log.Errorf("slot not online, %+v", slot)
 	}
 
-	groupServer, err := models.GetGroup(top.coordConn, top.ProductName, slot.GroupId)
+	groupServer, err := models.GetGroup(top.conn(), top.ProductName, slot.GroupId)
 	if err != nil {
 		return nil, nil, errors.Trace(err)
 	}
@@ -80,16 +110,45 @@ func (top *Topology) InitCoordConn() {
 	}
 }
 
+// Reconnect replaces the coordinator connection with a new one, the events
+// of the watches set with the former connection are dropped, so the caller
+// must watch again.
+func (top *Topology) Reconnect() error {
+	c, err := top.fact(top.coordAddr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	top.mu.Lock()
+	old := top.coordConn
+	top.coordConn = c
+	top.watchGen++
+	top.mu.Unlock()
+
+	// the factory may return the same connection, e.g, the fake one in tests
+	if old != nil && old != c {
+		old.Close()
+	}
+
+	return nil
+}
+
+func (top *Topology) connWithGen() (zkhelper.Conn, int) {
+	top.mu.RLock()
+	defer top.mu.RUnlock()
+	return top.coordConn, top.watchGen
+}
+
 func (top *Topology) GetActionWithSeq(seq int64) (*models.Action, error) {
-	return models.GetActionWithSeq(top.coordConn, top.ProductName, seq, top.coordinator)
+	return models.GetActionWithSeq(top.conn(), top.ProductName, seq, top.coordinator)
 }
 
 func (top *Topology) GetActionWithSeqObject(seq int64, act *models.Action) error {
-	return models.GetActionObject(top.coordConn, top.ProductName, seq, act, top.coordinator)
+	return models.GetActionObject(top.conn(), top.ProductName, seq, act, top.coordinator)
 }
 
 func (top *Topology) GetActionSeqList(productName string) ([]int, error) {
-	return models.GetActionSeqList(top.coordConn, productName)
+	return models.GetActionSeqList(top.conn(), productName)
 }
 
 func (top *Topology) IsChildrenChangedEvent(e interface{}) bool {
@@ -97,36 +156,40 @@ func (top *Topology) IsChildrenChangedEvent(e interface{}) bool {
 }
 
 func (top *Topology) CreateProxyInfo(pi *models.ProxyInfo) (string, error) {
-	return models.CreateProxyInfo(top.coordConn, top.ProductName, pi)
+	return models.CreateProxyInfo(top.conn(), top.ProductName, pi)
 }
 
 func (top *Topology) CreateProxyFenceNode(pi *models.ProxyInfo) (string, error) {
-	return models.CreateProxyFenceNode(top.coordConn, top.ProductName, pi)
+	return models.CreateProxyFenceNode(top.conn(), top.ProductName, pi)
+}
+
+func (top *Topology) GetProxyList(filter func(*models.ProxyInfo) bool) ([]models.ProxyInfo, error) {
+	return models.ProxyList(top.conn(), top.ProductName, filter)
 }
 
 func (top *Topology) GetProxyInfo(proxyName string) (*models.ProxyInfo, error) {
-	return models.GetProxyInfo(top.coordConn, top.ProductName, proxyName)
+	return models.GetProxyInfo(top.conn(), top.ProductName, proxyName)
 }
 
 func (top *Topology) GetActionResponsePath(seq int) string {
-	return path.Join(models.GetActionResponsePath(top.ProductName), top.coordConn.Seq2Str(int64(seq)))
+	return path.Join(models.GetActionResponsePath(top.ProductName), top.conn().Seq2Str(int64(seq)))
 }
 
 func (top *Topology) SetProxyStatus(proxyName string, status string) error {
-	return models.SetProxyStatus(top.coordConn, top.ProductName, proxyName, status)
+	return models.SetProxyStatus(top.conn(), top.ProductName, proxyName, status)
 }
 
 func (top *Topology) Close(proxyName string) {
 	// delete fence znode
-	pi, err := models.GetProxyInfo(top.coordConn, top.ProductName, proxyName)
+	pi, err := models.GetProxyInfo(top.conn(), top.ProductName, proxyName)
 	if err != nil {
 		log.Error("killing fence error, proxy %s is not exists", proxyName)
 	} else {
-		zkhelper.DeleteRecursive(top.coordConn, path.Join(models.GetProxyFencePath(top.ProductName), pi.Addr), -1)
+		zkhelper.DeleteRecursive(top.conn(), path.Join(models.GetProxyFencePath(top.ProductName), pi.Addr), -1)
 	}
 	// delete ephemeral znode
-	zkhelper.DeleteRecursive(top.coordConn, path.Join(models.GetProxyPath(top.ProductName), proxyName), -1)
-	top.coordConn.Close()
+	zkhelper.DeleteRecursive(top.conn(), path.Join(models.GetProxyPath(top.ProductName), proxyName), -1)
+	top.conn().Close()
 }
 
 func (top *Topology) DoResponse(seq int, pi *models.ProxyInfo) error {
@@ -138,7 +201,7 @@ func (top *Topology) DoResponse(seq int, pi *models.ProxyInfo) error {
 		return errors.Trace(err)
 	}
 
-	_, err = top.coordConn.Create(path.Join(actionPath, pi.ID), data,
+	_, err = top.conn().Create(path.Join(actionPath, pi.ID), data,
 		0, zkhelper.DefaultFileACLs())
 
 	return errors.Trace(err)
@@ -157,10 +220,15 @@ func (top *Topology) IsSessionExpiredEvent(event interface{}) bool {
 	return false
 }
 
-func (top *Topology) doWatch(evtch <-chan topo.Event, evtbus chan interface{}) {
+func (top *Topology) doWatch(evtch <-chan topo.Event, evtbus chan interface{}, gen int) {
 	e := <-evtch
 	log.Warningf("topo event %+v", e)
 
+	if _, cur := top.connWithGen(); cur != gen {
+		log.Warningf("drop event of former connection, %+v", e)
+		return
+	}
+
 	switch e.Type {
 	//case topo.EventNodeCreated:
 	//case topo.EventNodeDataChanged:
@@ -174,21 +242,23 @@ func (top *Topology) doWatch(evtch <-chan topo.Event, evtbus chan interface{}) {
 }
 
 func (top *Topology) WatchChildren(path string, evtbus chan interface{}) ([]string, error) {
-	content, _, evtch, err := top.coordConn.ChildrenW(path)
+	c, gen := top.connWithGen()
+	content, _, evtch, err := c.ChildrenW(path)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
-	go top.doWatch(evtch, evtbus)
+	go top.doWatch(evtch, evtbus, gen)
 	return content, nil
 }
 
 func (top *Topology) WatchNode(path string, evtbus chan interface{}) ([]byte, error) {
-	content, _, evtch, err := top.coordConn.GetW(path)
+	c, gen := top.connWithGen()
+	content, _, evtch, err := c.GetW(path)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
-	go top.doWatch(evtch, evtbus)
+	go top.doWatch(evtch, evtbus, gen)
 	return content, nil
 }
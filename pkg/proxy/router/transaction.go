@@ -0,0 +1,356 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juju/errors"
+	"github.com/ngaut/log"
+	"github.com/reborndb/reborn/pkg/proxy/parser"
+	"github.com/reborndb/reborn/pkg/proxy/redisconn"
+)
+
+var (
+	QUEUED_BYTES    = []byte("+QUEUED\r\n")
+	NIL_MULTI_BYTES = []byte("*-1\r\n")
+)
+
+// transaction keeps the MULTI/WATCH state of a session.
+// All keys in a transaction must hash to the same slot, so that
+// the whole transaction can be executed by one backend server.
+type transaction struct {
+	slot    int // -1 if no key is bound yet
+	multi   bool
+	aborted bool // some commands failed to queue, EXEC must be discarded
+	dirty   bool // the slot moved or the watching connection broke after WATCH, EXEC must fail
+
+	cmds [][]byte
+	keys [][]byte
+
+	// dedicated backend connection pinned by WATCH
+	conn *redisconn.Conn
+	addr string
+}
+
+func isTxnOp(op string) bool {
+	switch op {
+	case "MULTI", "EXEC", "DISCARD", "WATCH", "UNWATCH":
+		return true
+	}
+
+	return false
+}
+
+// isTxnDeniedOp returns whether the command can not be queued in MULTI,
+// these commands are handled by proxy itself, so their replies from one
+// backend server in EXEC are wrong, e.g. cursors of SCAN or DBSIZE of one group,
+// and pub/sub commands would switch the backend connection to subscribe mode.
+func isTxnDeniedOp(op string) bool {
+	if isFanoutOp(op) || isSubOp(op) {
+		return true
+	}
+
+	switch op {
+	case "SCAN", "CLUSTER", "COMMAND", "CLIENT", "SLOWLOG":
+		return true
+	}
+
+	return false
+}
+
+func newTransaction() *transaction {
+	return &transaction{slot: -1}
+}
+
+// bindSlot checks all keys against the slot bound to the transaction.
+func (t *transaction) bindSlot(keys [][]byte) bool {
+	for _, k := range keys {
+		i := mapKey2Slot(k)
+		if t.slot == -1 {
+			t.slot = i
+		} else if t.slot != i {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (t *transaction) release() {
+	if t.conn != nil {
+		// the connection may still watch some keys, close it
+		t.conn.Close()
+		t.conn = nil
+	}
+}
+
+func (s *Server) releaseTxn(c *session) {
+	if c.txn != nil {
+		c.txn.release()
+		c.txn = nil
+	}
+}
+
+func (s *Server) handleTxnCommand(c *session, opstr string, op []byte, keys [][]byte, resp *parser.Resp) error {
+	if c.txn == nil {
+		c.txn = newTransaction()
+	}
+
+	t := c.txn
+
+	switch opstr {
+	case "MULTI":
+		if t.multi {
+			s.sendBack(c, op, keys, resp, []byte("-ERR MULTI calls can not be nested\r\n"))
+			return nil
+		}
+		t.multi = true
+		s.sendBack(c, op, keys, resp, OK_BYTES)
+		return nil
+	case "DISCARD":
+		if !t.multi {
+			s.sendBack(c, op, keys, resp, []byte("-ERR DISCARD without MULTI\r\n"))
+			return nil
+		}
+		s.releaseTxn(c)
+		s.sendBack(c, op, keys, resp, OK_BYTES)
+		return nil
+	case "UNWATCH":
+		if !t.multi {
+			s.releaseTxn(c)
+			s.sendBack(c, op, keys, resp, OK_BYTES)
+			return nil
+		}
+	case "WATCH":
+		if t.multi {
+			s.sendBack(c, op, keys, resp, []byte("-ERR WATCH inside MULTI is not allowed\r\n"))
+			return nil
+		}
+		if len(resp.Multi) < 2 {
+			s.sendBack(c, op, keys, resp, []byte("-ERR wrong number of arguments for 'watch' command\r\n"))
+			return nil
+		}
+		if !t.bindSlot(keys) {
+			s.sendBack(c, op, keys, resp, CROSSSLOT_BYTES)
+			return nil
+		}
+		return s.sendTxnRequest(c, op, keys, resp, s.doWatch)
+	case "EXEC":
+		if !t.multi {
+			s.sendBack(c, op, keys, resp, []byte("-ERR EXEC without MULTI\r\n"))
+			return nil
+		}
+		if t.aborted {
+			s.releaseTxn(c)
+			s.sendBack(c, op, keys, resp, []byte("-EXECABORT Transaction discarded because of previous errors.\r\n"))
+			return nil
+		}
+		if t.dirty {
+			s.releaseTxn(c)
+			s.sendBack(c, op, keys, resp, NIL_MULTI_BYTES)
+			return nil
+		}
+		return s.sendTxnRequest(c, op, t.keys, resp, s.doExec)
+	}
+
+	// queue command in MULTI
+	cmd := s.getCommand(opstr)
+	if cmd.denied() {
+		return s.abortTxn(c, op, keys, resp, []byte("-ERR "+opstr+" not allowed\r\n"))
+	}
+
+	if cmd.admin() {
+		if buf := s.checkAdmin(c, opstr); buf != nil {
+			return s.abortTxn(c, op, keys, resp, buf)
+		}
+	}
+
+	if isTxnDeniedOp(opstr) {
+		return s.abortTxn(c, op, keys, resp, []byte("-ERR "+opstr+" not allowed in transaction\r\n"))
+	}
+
+	if !cmd.checkArity(len(resp.Multi)) {
+		return s.abortTxn(c, op, keys, resp, []byte("-ERR wrong number of arguments for '"+strings.ToLower(opstr)+"' command\r\n"))
+	}
+
+	if len(resp.Multi) > 1 {
+		keys = cmd.keys(keys)
+		if !t.bindSlot(keys) {
+			return s.abortTxn(c, op, keys, resp, CROSSSLOT_BYTES)
+		}
+		t.keys = append(t.keys, keys...)
+	}
+
+	b, err := resp.Bytes()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	t.cmds = append(t.cmds, b)
+	s.sendBack(c, op, keys, resp, QUEUED_BYTES)
+	return nil
+}
+
+// abortTxn replies the error of a command failed to queue, EXEC will be discarded.
+func (s *Server) abortTxn(c *session, op []byte, keys [][]byte, resp *parser.Resp, buf []byte) error {
+	c.txn.aborted = true
+	s.sendBack(c, op, keys, resp, buf)
+	return nil
+}
+
+// sendTxnRequest sends the request to dispatcher to resolve the backend
+// server of the transaction slot, then runs f with a dedicated connection.
+func (s *Server) sendTxnRequest(c *session, op []byte, keys [][]byte, resp *parser.Resp,
+	f func(c *session, r *PipelineRequest, addr string)) error {
+	slot := c.txn.slot
+	if slot == -1 {
+		slot = 0
+	}
+
+	c.pipelineSeq++
+	pr := &PipelineRequest{
+		slotIdx: slot,
+		op:      op,
+		keys:    keys,
+		seq:     c.pipelineSeq,
+		backQ:   c.backQ,
+		req:     resp,
+		wg:      &sync.WaitGroup{},
+	}
+	pr.direct = func(addr string) {
+		f(c, pr, addr)
+	}
+	pr.wg.Add(1)
+
+	s.sendRequest(pr)
+	pr.wg.Wait()
+
+	return nil
+}
+
+func (s *Server) newTxnConn(addr string) (*redisconn.Conn, error) {
+	return newRedisConn(addr, s.conf.NetTimeout, RedisConnReaderSize, RedisConnWiterSize, s.getCredential(addr))
+}
+
+func (s *Server) doWatch(c *session, r *PipelineRequest, addr string) {
+	t := c.txn
+	if t.conn != nil && t.addr != addr {
+		// slot has been moved to another server, we can not
+		// know whether the watched keys are changed or not
+		t.dirty = true
+		r.backQ <- &PipelineResponse{ctx: r, resp: &parser.Resp{Type: parser.SimpleString, Raw: OK_BYTES}}
+		return
+	}
+
+	if t.conn == nil {
+		conn, err := s.newTxnConn(addr)
+		if err != nil {
+			r.backQ <- &PipelineResponse{ctx: r, err: errors.Trace(err)}
+			return
+		}
+		t.conn = conn
+		t.addr = addr
+	}
+
+	resp, err := s.doTxnRoundTrip(t.conn, r.req, false)
+	if err != nil {
+		// the keys are not watched any more, EXEC must fail
+		t.release()
+		t.dirty = true
+	}
+
+	r.backQ <- &PipelineResponse{ctx: r, resp: resp, err: err}
+}
+
+func (s *Server) doExec(c *session, r *PipelineRequest, addr string) {
+	t := c.txn
+	c.txn = nil
+
+	if t.conn != nil && t.addr != addr {
+		t.release()
+		r.backQ <- &PipelineResponse{ctx: r, resp: &parser.Resp{Type: parser.MultiResp, Raw: NIL_MULTI_BYTES}}
+		return
+	}
+
+	if t.conn != nil {
+		defer t.release()
+
+		resp, err := s.doTxnRoundTrip(t.conn, r.req, true, t.cmds...)
+		r.backQ <- &PipelineResponse{ctx: r, resp: resp, err: err}
+		return
+	}
+
+	// EXEC is not retried, a broken pooled connection just fails it, and the
+	// connection may be left in MULTI by any error, so it is closed then
+	conn, err := s.pools.GetConn(addr)
+	if err != nil {
+		r.backQ <- &PipelineResponse{ctx: r, err: errors.Trace(err)}
+		return
+	}
+
+	resp, err := s.doTxnRoundTrip(conn, r.req, true, t.cmds...)
+	if err != nil {
+		conn.Close()
+	}
+	s.pools.PutConn(conn)
+
+	r.backQ <- &PipelineResponse{ctx: r, resp: resp, err: err}
+}
+
+// doTxnRoundTrip sends the queued commands wrapped in MULTI and the last request,
+// like EXEC or WATCH, then returns the reply of the last request.
+// If MULTI fails, its error is returned instead, e.g. the backend does not support it.
+func (s *Server) doTxnRoundTrip(conn *redisconn.Conn, last *parser.Resp, multi bool, queued ...[]byte) (*parser.Resp, error) {
+	replies := 1
+	if multi {
+		if err := parser.WriteCommand(conn, "MULTI"); err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		for _, b := range queued {
+			if _, err := conn.Write(b); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+
+		replies += len(queued) + 1
+	}
+
+	if err := last.WriteTo(conn); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err := conn.Flush(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	timeout := time.Duration(s.conf.NetTimeout) * time.Second
+	var first *parser.Resp
+	var resp *parser.Resp
+	for i := 0; i < replies; i++ {
+		if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		var err error
+		resp, err = parser.Parse(conn.BufioReader())
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		if first == nil {
+			first = resp
+		}
+	}
+
+	if multi && first.Type == parser.ErrorResp {
+		log.Warningf("transaction failed, %s", first.Raw)
+		return first, nil
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package router
+
+import (
+	"github.com/garyburd/redigo/redis"
+	. "gopkg.in/check.v1"
+)
+
+func (s *testProxyRouterSuite) TestTxnBindSlot(c *C) {
+	t := newTransaction()
+	c.Assert(t.bindSlot([][]byte{[]byte("{a}1"), []byte("{a}2")}), Equals, true)
+	c.Assert(t.slot, Equals, mapKey2Slot([]byte("a")))
+	c.Assert(t.bindSlot([][]byte{[]byte("a")}), Equals, true)
+
+	keys := s.testGenKeysInSlot(c, (t.slot+1)%1024, 1)
+	c.Assert(t.bindSlot([][]byte{[]byte(keys[0])}), Equals, false)
+}
+
+func (s *testProxyRouterSuite) TestTxnCommands(c *C) {
+	cc := s.testDialConn(c, proxyAddr, proxyAuth)
+	defer cc.Close()
+
+	_, err := cc.Do("EXEC")
+	c.Assert(err, ErrorMatches, "ERR EXEC without MULTI")
+
+	_, err = cc.Do("DISCARD")
+	c.Assert(err, ErrorMatches, "ERR DISCARD without MULTI")
+
+	ok, err := redis.String(cc.Do("MULTI"))
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, "OK")
+
+	_, err = cc.Do("MULTI")
+	c.Assert(err, ErrorMatches, "ERR MULTI calls can not be nested")
+
+	_, err = cc.Do("WATCH", "foo")
+	c.Assert(err, ErrorMatches, "ERR WATCH inside MULTI is not allowed")
+
+	queued, err := redis.String(cc.Do("SET", "{foo}1", "bar"))
+	c.Assert(err, IsNil)
+	c.Assert(queued, Equals, "QUEUED")
+
+	// PING must be queued too
+	queued, err = redis.String(cc.Do("PING"))
+	c.Assert(err, IsNil)
+	c.Assert(queued, Equals, "QUEUED")
+
+	ok, err = redis.String(cc.Do("DISCARD"))
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, "OK")
+
+	// keys in different slots abort the transaction
+	_, err = cc.Do("MULTI")
+	c.Assert(err, IsNil)
+
+	_, err = cc.Do("SET", "{foo}1", "bar")
+	c.Assert(err, IsNil)
+
+	keys := s.testGenKeysInSlot(c, (mapKey2Slot([]byte("foo"))+1)%1024, 1)
+	_, err = cc.Do("SET", keys[0], "bar")
+	c.Assert(err, ErrorMatches, "CROSSSLOT.*")
+
+	_, err = cc.Do("EXEC")
+	c.Assert(err, ErrorMatches, "EXECABORT.*")
+
+	// commands handled by proxy and wrong arity abort the transaction
+	for _, args := range [][]interface{}{
+		{"SCAN", "0"}, {"DBSIZE"}, {"RANDOMKEY"}, {"INFO"}, {"CLUSTER", "INFO"},
+		{"COMMAND"}, {"CLIENT", "ID"}, {"SLOWLOG", "LEN"}, {"GET"},
+		{"SUBSCRIBE", "ch"}, {"PSUBSCRIBE", "ch*"}, {"UNSUBSCRIBE"},
+	} {
+		_, err = cc.Do("MULTI")
+		c.Assert(err, IsNil)
+
+		_, err = cc.Do(args[0].(string), args[1:]...)
+		c.Assert(err, NotNil)
+
+		_, err = cc.Do("EXEC")
+		c.Assert(err, ErrorMatches, "EXECABORT.*")
+	}
+
+	// connection still works after transaction
+	_, err = cc.Do("SET", "foo", "bar")
+	c.Assert(err, IsNil)
+
+	_, err = cc.Do("WATCH", "foo", keys[0])
+	c.Assert(err, ErrorMatches, "CROSSSLOT.*")
+
+	_, err = cc.Do("UNWATCH")
+	c.Assert(err, IsNil)
+
+	// the backend in test does not support MULTI, its error is returned by EXEC
+	_, err = cc.Do("MULTI")
+	c.Assert(err, IsNil)
+
+	_, err = cc.Do("SET", "{foo}1", "bar")
+	c.Assert(err, IsNil)
+
+	_, err = cc.Do("EXEC")
+	c.Assert(err, NotNil)
+
+	got, err := redis.String(cc.Do("GET", "foo"))
+	c.Assert(err, IsNil)
+	c.Assert(got, Equals, "bar")
+
+	s.s1.store.Reset()
+	s.s2.store.Reset()
+}
@@ -0,0 +1,112 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"time"
+
+	"github.com/juju/errors"
+)
+
+// Credential is how to connect to a backend server, the connection is
+// plain and not authenticated if it is nil.
+type Credential struct {
+	Auth string `json:"auth,omitempty"`
+
+	// tls to the server, the server certificate is verified with the CA
+	// certificates in PEM, or the system roots if empty
+	TLS           bool   `json:"tls,omitempty"`
+	TLSCACert     string `json:"tls_ca_cert,omitempty"`
+	TLSServerName string `json:"tls_server_name,omitempty"`
+	TLSSkipVerify bool   `json:"tls_skip_verify,omitempty"`
+}
+
+// GetAuth returns the password, empty if c is nil.
+func (c *Credential) GetAuth() string {
+	if c == nil {
+		return ""
+	}
+	return c.Auth
+}
+
+// Equal returns whether the connections of the two credentials are the same.
+func (c *Credential) Equal(o *Credential) bool {
+	if c == nil || o == nil {
+		return c.GetAuth() == o.GetAuth() && !c.useTLS() && !o.useTLS()
+	}
+	return *c == *o
+}
+
+func (c *Credential) useTLS() bool {
+	return c != nil && c.TLS
+}
+
+func (c *Credential) Validate() error {
+	if c == nil || len(c.TLSCACert) == 0 {
+		return nil
+	}
+
+	if !x509.NewCertPool().AppendCertsFromPEM([]byte(c.TLSCACert)) {
+		return errors.NotValidf("tls ca cert")
+	}
+
+	return nil
+}
+
+func (c *Credential) tlsConfig(addr string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         c.TLSServerName,
+		InsecureSkipVerify: c.TLSSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if len(cfg.ServerName) == 0 {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		cfg.ServerName = host
+	}
+
+	if len(c.TLSCACert) > 0 {
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM([]byte(c.TLSCACert)) {
+			return nil, errors.NotValidf("tls ca cert")
+		}
+	}
+
+	return cfg, nil
+}
+
+// Dial connects to the server, with tls if the credential requires, the
+// handshake is done in the timeout too.
+func (c *Credential) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if !c.useTLS() {
+		return conn, nil
+	}
+
+	cfg, err := c.tlsConfig(addr)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Trace(err)
+	}
+
+	tc := tls.Client(conn, cfg)
+	tc.SetDeadline(time.Now().Add(timeout))
+	if err = tc.Handshake(); err != nil {
+		tc.Close()
+		return nil, errors.Trace(err)
+	}
+	tc.SetDeadline(time.Time{})
+
+	return tc, nil
+}
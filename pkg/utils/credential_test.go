@@ -0,0 +1,100 @@
+// Copyright 2015 Reborndb Org. All Rights Reserved.
+// Licensed under the MIT (MIT-LICENSE.txt) license.
+
+package utils
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+// testTLSServer serves PING with a self-signed certificate, it returns the
+// address and the certificate in PEM.
+func (s *testUtilsSuite) testTLSServer(c *C) (net.Listener, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "reborn test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	c.Assert(err, IsNil)
+
+	cfg := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	l, err := tls.Listen("tcp4", "127.0.0.1:0", cfg)
+	c.Assert(err, IsNil)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					// *1\r\n$4\r\nPING\r\n
+					for i := 0; i < 3; i++ {
+						if _, err := r.ReadString('\n'); err != nil {
+							return
+						}
+					}
+					conn.Write([]byte("+PONG\r\n"))
+				}
+			}()
+		}
+	}()
+
+	return l, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func (s *testUtilsSuite) TestCredentialTLS(c *C) {
+	l, caCert := s.testTLSServer(c)
+	defer l.Close()
+
+	addr := l.Addr().String()
+
+	// not trusted
+	err := Ping(addr, &Credential{TLS: true})
+	c.Assert(err, NotNil)
+
+	err = Ping(addr, &Credential{TLS: true, TLSCACert: caCert})
+	c.Assert(err, IsNil)
+
+	err = Ping(addr, &Credential{TLS: true, TLSSkipVerify: true})
+	c.Assert(err, IsNil)
+
+	err = (&Credential{TLSCACert: "broken"}).Validate()
+	c.Assert(err, NotNil)
+}
+
+func (s *testUtilsSuite) TestCredentialEqual(c *C) {
+	var null *Credential
+	c.Assert(null.Equal(&Credential{}), Equals, true)
+	c.Assert(null.Equal(&Credential{Auth: "abc"}), Equals, false)
+	c.Assert(null.Equal(&Credential{TLS: true}), Equals, false)
+	c.Assert((&Credential{Auth: "abc"}).Equal(&Credential{Auth: "abc"}), Equals, true)
+	c.Assert((&Credential{Auth: "abc"}).Equal(&Credential{Auth: "abc", TLS: true}), Equals, false)
+}
@@ -18,13 +18,20 @@ const (
 	RedisConnWriteTimeout   = 1 * time.Second
 )
 
-func newRedisConn(addr string, auth string) (redis.Conn, error) {
-	c, err := redis.DialTimeout("tcp", addr, RedisConnConnectTimeout, RedisConnReadTimeout, RedisConnWriteTimeout)
+func newRedisConn(addr string, cred *Credential) (redis.Conn, error) {
+	c, err := DialRedis(addr, cred, RedisConnConnectTimeout, RedisConnReadTimeout, RedisConnWriteTimeout)
+	return c, errors.Trace(err)
+}
+
+// DialRedis connects to the server with the credential, like redis.DialTimeout.
+func DialRedis(addr string, cred *Credential, connectTimeout, readTimeout, writeTimeout time.Duration) (redis.Conn, error) {
+	nc, err := cred.Dial(addr, connectTimeout)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
-	if len(auth) > 0 {
+	c := redis.NewConn(nc, readTimeout, writeTimeout)
+	if auth := cred.GetAuth(); len(auth) > 0 {
 		if _, err = c.Do("AUTH", auth); err != nil {
 			c.Close()
 			return nil, errors.Trace(err)
@@ -35,8 +42,8 @@ func newRedisConn(addr string, auth string) (redis.Conn, error) {
 }
 
 // get redis's slot size
-func SlotsInfo(addr string, fromSlot int, toSlot int, auth string) (map[int]int, error) {
-	c, err := newRedisConn(addr, auth)
+func SlotsInfo(addr string, fromSlot int, toSlot int, cred *Credential) (map[int]int, error) {
+	c, err := newRedisConn(addr, cred)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -71,8 +78,8 @@ func SlotsInfo(addr string, fromSlot int, toSlot int, auth string) (map[int]int,
 	return ret, nil
 }
 
-func GetRedisStat(addr string, auth string) (map[string]string, error) {
-	c, err := newRedisConn(addr, auth)
+func GetRedisStat(addr string, cred *Credential) (map[string]string, error) {
+	c, err := newRedisConn(addr, cred)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -112,8 +119,8 @@ func GetRedisStat(addr string, auth string) (map[string]string, error) {
 	return m, nil
 }
 
-func GetRedisConfig(addr string, configName string, auth string) (string, error) {
-	c, err := newRedisConn(addr, auth)
+func GetRedisConfig(addr string, configName string, cred *Credential) (string, error) {
+	c, err := newRedisConn(addr, cred)
 	if err != nil {
 		return "", errors.Trace(err)
 	}
@@ -131,12 +138,12 @@ func GetRedisConfig(addr string, configName string, auth string) (string, error)
 	return "", nil
 }
 
-func SlaveOf(slave string, master string, auth string) error {
+func SlaveOf(slave string, master string, cred *Credential) error {
 	if master == slave {
 		return errors.New("can not slave of itself")
 	}
 
-	c, err := newRedisConn(slave, auth)
+	c, err := newRedisConn(slave, cred)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -158,8 +165,8 @@ func SlaveOf(slave string, master string, auth string) error {
 	return nil
 }
 
-func SlaveNoOne(addr string, auth string) error {
-	c, err := newRedisConn(addr, auth)
+func SlaveNoOne(addr string, cred *Credential) error {
+	c, err := newRedisConn(addr, cred)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -173,8 +180,8 @@ func SlaveNoOne(addr string, auth string) error {
 	return nil
 }
 
-func Ping(addr string, auth string) error {
-	c, err := newRedisConn(addr, auth)
+func Ping(addr string, cred *Credential) error {
+	c, err := newRedisConn(addr, cred)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -189,8 +196,8 @@ func Ping(addr string, auth string) error {
 	return nil
 }
 
-func GetRedisInfo(addr string, section string, auth string) (string, error) {
-	c, err := newRedisConn(addr, auth)
+func GetRedisInfo(addr string, section string, cred *Credential) (string, error) {
+	c, err := newRedisConn(addr, cred)
 	if err != nil {
 		return "", errors.Trace(err)
 	}
@@ -203,8 +210,8 @@ func GetRedisInfo(addr string, section string, auth string) (string, error) {
 	}
 }
 
-func GetRole(addr string, auth string) (string, error) {
-	c, err := newRedisConn(addr, auth)
+func GetRole(addr string, cred *Credential) (string, error) {
+	c, err := newRedisConn(addr, cred)
 	if err != nil {
 		return "", errors.Trace(err)
 	}
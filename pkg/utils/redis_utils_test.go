@@ -26,6 +26,7 @@ type testUtilsSuite struct {
 	s *testServer
 
 	auth string
+	cred *Credential
 }
 
 type testServer struct {
@@ -43,6 +44,7 @@ func (s *testServer) Close() {
 
 func (s *testUtilsSuite) SetUpSuite(c *C) {
 	s.auth = "abc"
+	s.cred = &Credential{Auth: s.auth}
 	s.s = s.testCreateServer(c, 36380, s.auth)
 }
 
@@ -86,17 +88,17 @@ func (s *testUtilsSuite) TearDownSuite(c *C) {
 }
 
 func (s *testUtilsSuite) TestPing(c *C) {
-	err := Ping(s.s.addr, s.auth)
+	err := Ping(s.s.addr, s.cred)
 	c.Assert(err, IsNil)
 }
 
 func (s *testUtilsSuite) TestGetInfo(c *C) {
-	_, err := GetRedisInfo(s.s.addr, "", s.auth)
+	_, err := GetRedisInfo(s.s.addr, "", s.cred)
 	c.Assert(err, IsNil)
 }
 
 func (s *testUtilsSuite) TestGetRole(c *C) {
-	role, err := GetRole(s.s.addr, s.auth)
+	role, err := GetRole(s.s.addr, s.cred)
 	c.Assert(err, IsNil)
 	c.Assert(role, Equals, "master")
 }